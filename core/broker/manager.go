@@ -0,0 +1,353 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrNotConnected is returned while the manager has no connection to the broker.
+var ErrNotConnected = errors.New("not connected to the broker")
+
+type State string
+
+const (
+	StateConnecting State = "connecting"
+	StateConnected  State = "connected"
+	StateClosed     State = "closed"
+)
+
+// Status describes the connection of a manager to health checks.
+type Status struct {
+	State     State     `json:"state"`
+	Since     time.Time `json:"since"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Topology declares exchanges, queues and bindings. Topologies are declared again after
+// every reconnection, which brings back the exclusive and auto-deleted queues the broker
+// dropped with the previous connection.
+type Topology func(channel *amqp.Channel) error
+
+// Backoff spaces reconnection attempts: the delay doubles from Initial up to Max, with
+// some jitter so the services of a cluster do not all reconnect at once.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+func (b Backoff) delay(attempt int) time.Duration {
+	delay := b.Initial
+	for i := 1; i < attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, b.Max)
+
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// Manager keeps a connection to RabbitMQ open. It watches the connection, reconnects
+// with backoff when it is lost, declares the registered topologies again and resumes
+// the supervised consumers.
+type Manager struct {
+	url        string
+	backoff    Backoff
+	topologies []Topology
+
+	mu      sync.RWMutex
+	status  Status
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	ready   chan struct{}
+}
+
+func NewManager(url string, backoff Backoff) *Manager {
+	return &Manager{
+		url:     url,
+		backoff: backoff,
+		status:  Status{State: StateConnecting, Since: time.Now()},
+		ready:   make(chan struct{}),
+	}
+}
+
+// Declare registers a topology, declared on every connection. Topologies are
+// registered before Start.
+func (m *Manager) Declare(topology Topology) {
+	m.topologies = append(m.topologies, topology)
+}
+
+// Start connects to the broker, retrying until it succeeds or ctx is done, then keeps
+// the connection open in the background until ctx is done.
+func (m *Manager) Start(ctx context.Context) error {
+	closed, err := m.reconnect(ctx)
+	if err != nil {
+		return err
+	}
+
+	go m.watch(ctx, closed)
+	return nil
+}
+
+// State returns the state of the connection.
+func (m *Manager) State() State {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status.State
+}
+
+// Status returns the state of the connection, since when it is in that state and the
+// error that closed the last connection.
+func (m *Manager) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+// Ready returns a channel closed once the manager is connected.
+func (m *Manager) Ready() <-chan struct{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ready
+}
+
+// Channel returns the channel the manager shares for publishing. It is replaced on
+// every reconnection, so callers fetch it for each use instead of keeping it.
+func (m *Manager) Channel() (*amqp.Channel, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.status.State != StateConnected || m.channel.IsClosed() {
+		return nil, ErrNotConnected
+	}
+	return m.channel, nil
+}
+
+// OpenChannel opens a channel of its own on the current connection.
+func (m *Manager) OpenChannel() (*amqp.Channel, error) {
+	m.mu.RLock()
+	conn := m.conn
+	state := m.status.State
+	m.mu.RUnlock()
+
+	if state != StateConnected || conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+	return conn.Channel()
+}
+
+// Supervise runs a consumer while the manager is connected, until ctx is done. A
+// consumer returns once its channel is closed; it is run again after the reconnection,
+// or after a backoff delay when it stopped while the connection was still open.
+func (m *Manager) Supervise(ctx context.Context, name string, consume func() error) {
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.Ready():
+		}
+
+		started := time.Now()
+		err := consume()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > m.backoff.Max {
+			failures = 0
+		}
+		failures++
+
+		delay := m.backoff.delay(failures)
+		log.Printf("Consumer %s stopped, resuming it in %s: %v", name, delay, err)
+		if !sleep(ctx, delay) {
+			return
+		}
+	}
+}
+
+// Close closes the connection for good.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.setState(StateClosed, nil)
+	m.ready = make(chan struct{})
+	if m.conn == nil {
+		return nil
+	}
+	return m.conn.Close()
+}
+
+// watch reconnects every time the connection is lost, and reopens the shared channel
+// when the broker closes it alone.
+func (m *Manager) watch(ctx context.Context, closed connectionClosed) {
+	for {
+		select {
+		case <-ctx.Done():
+			m.Close()
+			return
+
+		case err := <-closed.channel:
+			if !m.connected() {
+				return
+			}
+			log.Printf("RabbitMQ closed the shared channel, reopening it: %v", err)
+			if channelClosed, err := m.reopenChannel(); err == nil {
+				closed.channel = channelClosed
+				continue
+			}
+			// The connection is going down as well.
+			closed.channel = nil
+
+		case err, ok := <-closed.conn:
+			if !m.connected() {
+				return
+			}
+			m.lost(err, ok)
+
+			var reconnectErr error
+			closed, reconnectErr = m.reconnect(ctx)
+			if reconnectErr != nil {
+				// ctx is done: leave the manager closed rather than connecting forever.
+				m.Close()
+				return
+			}
+		}
+	}
+}
+
+// connectionClosed notifies the closing of the connection and of the shared channel.
+type connectionClosed struct {
+	conn    chan *amqp.Error
+	channel chan *amqp.Error
+}
+
+// reconnect connects to the broker, retrying with backoff until it succeeds or ctx is
+// done.
+func (m *Manager) reconnect(ctx context.Context) (connectionClosed, error) {
+	for attempt := 1; ; attempt++ {
+		closed, err := m.connect()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Reconnected to RabbitMQ after %d attempts", attempt)
+			}
+			return closed, nil
+		}
+
+		m.mu.Lock()
+		m.status.LastError = err.Error()
+		m.mu.Unlock()
+
+		delay := m.backoff.delay(attempt)
+		log.Printf("Failed to connect to RabbitMQ, retrying in %s: %v", delay, err)
+		if !sleep(ctx, delay) {
+			return connectionClosed{}, ctx.Err()
+		}
+	}
+}
+
+// connect dials the broker, declares the topologies and opens the shared channel.
+func (m *Manager) connect() (connectionClosed, error) {
+	conn, err := amqp.Dial(m.url)
+	if err != nil {
+		return connectionClosed{}, err
+	}
+	closed := connectionClosed{conn: conn.NotifyClose(make(chan *amqp.Error, 1))}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return connectionClosed{}, err
+	}
+
+	for _, topology := range m.topologies {
+		if err := topology(channel); err != nil {
+			conn.Close()
+			return connectionClosed{}, err
+		}
+	}
+
+	// A failed declaration closes the channel, so the channel is watched once the
+	// topologies are declared.
+	if channel.IsClosed() {
+		conn.Close()
+		return connectionClosed{}, amqp.ErrClosed
+	}
+	closed.channel = channel.NotifyClose(make(chan *amqp.Error, 1))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.status.State == StateClosed {
+		conn.Close()
+		return connectionClosed{}, ErrNotConnected
+	}
+
+	m.conn = conn
+	m.channel = channel
+	m.setState(StateConnected, nil)
+	close(m.ready)
+	return closed, nil
+}
+
+// reopenChannel replaces the shared channel after the broker closed it.
+func (m *Manager) reopenChannel() (chan *amqp.Error, error) {
+	channel, err := m.OpenChannel()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.channel = channel
+	return channel.NotifyClose(make(chan *amqp.Error, 1)), nil
+}
+
+// lost records that the connection was closed by the broker or by the network.
+func (m *Manager) lost(err *amqp.Error, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !ok || err == nil {
+		err = amqp.ErrClosed
+	}
+	log.Printf("Lost the connection to RabbitMQ, reconnecting: %v", err)
+
+	m.setState(StateConnecting, err)
+	m.ready = make(chan struct{})
+}
+
+func (m *Manager) connected() bool {
+	return m.State() != StateClosed
+}
+
+// setState changes the state of the manager. m.mu is held.
+func (m *Manager) setState(state State, err error) {
+	m.status.State = state
+	m.status.Since = time.Now()
+	if err != nil {
+		m.status.LastError = err.Error()
+	}
+}
+
+// sleep waits for d, returning false when ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,182 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/hoyci/ms-chat/core/broker"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// AMQPBus is the bus of a RabbitMQ connection kept by a broker.Manager. Publishings are
+// mandatory and confirmed; consumers are resumed after every reconnection.
+type AMQPBus struct {
+	manager *broker.Manager
+
+	// mu guards the publishing channel. It is only held to open the channel and to
+	// publish on it, so publishers wait for their confirmations concurrently.
+	mu      sync.Mutex
+	channel *amqp.Channel
+	returns *returns
+}
+
+func NewAMQPBus(manager *broker.Manager) *AMQPBus {
+	return &AMQPBus{manager: manager}
+}
+
+// Publish sends msg and waits for the broker to confirm it.
+func (b *AMQPBus) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	// Returns are matched to their publishing through its ID.
+	if msg.MessageId == "" {
+		msg.MessageId = uuid.NewString()
+	}
+
+	confirmation, returns, err := b.publish(ctx, exchange, key, msg)
+	if err != nil {
+		return err
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		returns.forget(msg.MessageId)
+		return err
+	}
+
+	if returns.has(msg.MessageId) {
+		return ErrUnroutable
+	}
+
+	if !acked {
+		return fmt.Errorf("broker refused the publishing %s", msg.MessageId)
+	}
+	return nil
+}
+
+// publish sends msg on the publishing channel, opening it first when needed, and
+// returns the confirmation to wait for along with the returns of that channel.
+func (b *AMQPBus) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) (*amqp.DeferredConfirmation, *returns, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.open(); err != nil {
+		return nil, nil, err
+	}
+
+	confirmation, err := b.channel.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return confirmation, b.returns, nil
+}
+
+// open puts a channel in confirm mode, replacing the previous one once it was closed.
+func (b *AMQPBus) open() error {
+	if b.channel != nil && !b.channel.IsClosed() {
+		return nil
+	}
+
+	channel, err := b.manager.OpenChannel()
+	if err != nil {
+		return err
+	}
+	if err := channel.Confirm(false); err != nil {
+		channel.Close()
+		return err
+	}
+
+	b.channel = channel
+	b.returns = &returns{
+		notify:   channel.NotifyReturn(make(chan amqp.Return, 64)),
+		returned: make(map[string]bool),
+	}
+	return nil
+}
+
+// returns keeps the publishings a channel returned until their publishers look for
+// them. The broker returns an unroutable publishing before confirming it, so the return
+// was notified by the time its publisher got the confirmation; whichever publisher
+// drains the notifications sets the returns of the others aside.
+type returns struct {
+	mu       sync.Mutex
+	notify   chan amqp.Return
+	returned map[string]bool
+}
+
+// has reports whether the publishing with the given ID was returned.
+func (r *returns) has(messageID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.drain()
+	returned := r.returned[messageID]
+	delete(r.returned, messageID)
+	return returned
+}
+
+// forget drops the return of a publishing whose confirmation was not awaited.
+func (r *returns) forget(messageID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.drain()
+	delete(r.returned, messageID)
+}
+
+func (r *returns) drain() {
+	for {
+		select {
+		case returned := <-r.notify:
+			r.returned[returned.MessageId] = true
+		default:
+			return
+		}
+	}
+}
+
+// Consume delivers the messages of a queue, from a channel of its own so the prefetch
+// applies to this queue only. The channel is replaced after every reconnection; the
+// deliveries left unacknowledged on the previous one are redelivered by the broker.
+func (b *AMQPBus) Consume(ctx context.Context, queueName string, prefetch int) (<-chan amqp.Delivery, error) {
+	out := make(chan amqp.Delivery)
+
+	go func() {
+		defer close(out)
+		b.manager.Supervise(ctx, queueName, func() error {
+			return b.forward(ctx, queueName, prefetch, out)
+		})
+	}()
+
+	return out, nil
+}
+
+// forward hands the deliveries of a queue to out until its channel is closed.
+func (b *AMQPBus) forward(ctx context.Context, queueName string, prefetch int, out chan<- amqp.Delivery) error {
+	channel, err := b.manager.OpenChannel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	deliveries, err := Subscribe(channel, queueName, prefetch)
+	if err != nil {
+		return fmt.Errorf("failed to start consumption from %s: %w", queueName, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case delivery, ok := <-deliveries:
+			if !ok {
+				return fmt.Errorf("consumption of %s stopped", queueName)
+			}
+			select {
+			case out <- delivery:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrUnroutable is returned for publishings no queue is bound to receive.
+var ErrUnroutable = errors.New("publishing could not be routed to any queue")
+
+// Bus carries the events between services. AMQPBus goes through RabbitMQ; MemoryBus
+// routes them in process, for tests.
+type Bus interface {
+	// Publish returns once the bus took msg in charge, with ErrUnroutable when no queue
+	// receives it. key is only used by direct exchanges and by the default exchange,
+	// which routes to the queue of that name.
+	Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+	// Consume delivers the messages of a queue, to be acknowledged, until ctx is done.
+	// A prefetch of 0 leaves the number of unacknowledged deliveries unbounded.
+	Consume(ctx context.Context, queueName string, prefetch int) (<-chan amqp.Delivery, error)
+}
+
+// Publish encodes payload and publishes it routed like the event.
+func (e Event[T]) Publish(ctx context.Context, bus Bus, payload T) error {
+	msg, err := e.Publishing(payload)
+	if err != nil {
+		return err
+	}
+	return bus.Publish(ctx, e.Exchange, "", msg)
+}
+
+// Subscribe starts consuming a queue on channel, with manual acknowledgements.
+func Subscribe(channel *amqp.Channel, queueName string, prefetch int) (<-chan amqp.Delivery, error) {
+	if prefetch > 0 {
+		if err := channel.Qos(prefetch, 0, false); err != nil {
+			return nil, err
+		}
+	}
+	return channel.Consume(queueName, "", false, false, false, false, nil)
+}
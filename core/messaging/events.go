@@ -0,0 +1,142 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// SchemaVersionHeader carries the version of the schema the body of a publishing
+// follows. Publishings without it follow version 1.
+const SchemaVersionHeader = "schema_version"
+
+// EventBroadcast is the type of the broadcasts message-service asks ws-service to
+// deliver, and of their parts ws-service routes to its nodes.
+const EventBroadcast = "broadcast"
+
+// ErrUnsupportedVersion is returned for deliveries following a schema version newer
+// than the one the service knows.
+var ErrUnsupportedVersion = errors.New("unsupported schema version")
+
+// Event describes a kind of event on the bus: the type set on its publishings, the
+// version of the schema of T they follow and where they are published. A change to T
+// that older consumers cannot read bumps Version.
+type Event[T any] struct {
+	Type      string
+	Version   int
+	Exchange  string
+	headers   amqp.Table
+	transient bool
+}
+
+// versions holds the schema version of every event type, for CheckVersion.
+var versions = map[string]int{}
+
+func define[T any](eventType string, version int, exchange string, headers amqp.Table, transient bool) Event[T] {
+	versions[eventType] = version
+	return Event[T]{Type: eventType, Version: version, Exchange: exchange, headers: headers, transient: transient}
+}
+
+// Events ws-service hands over to message-service, through the persistence queue
+// except for the typing events which go through the ephemeral queue.
+var (
+	ChatMessage   = define[coreTypes.Message](coreTypes.EventChatMessage, 1, ChatEventsExchange, amqp.Table{routePersistence: "true"}, false)
+	Receipt       = define[coreTypes.Receipt](coreTypes.EventReceipt, 1, ChatEventsExchange, amqp.Table{routePersistence: "true"}, false)
+	Sync          = define[coreTypes.SyncRequest](coreTypes.EventSync, 1, ChatEventsExchange, amqp.Table{routePersistence: "true"}, false)
+	MessageEdit   = define[coreTypes.MessageEdit](coreTypes.EventMessageEdit, 1, ChatEventsExchange, amqp.Table{routePersistence: "true"}, false)
+	MessageDelete = define[coreTypes.MessageDelete](coreTypes.EventMessageDelete, 1, ChatEventsExchange, amqp.Table{routePersistence: "true"}, false)
+	Reaction      = define[coreTypes.ReactionChange](coreTypes.EventReaction, 1, ChatEventsExchange, amqp.Table{routePersistence: "true"}, false)
+	Typing        = define[coreTypes.TypingEvent](coreTypes.EventTyping, 1, ChatEventsExchange, amqp.Table{routeEphemeral: "true"}, true)
+)
+
+// Broadcast is published by message-service for ws-service to deliver; ws-service
+// routes its parts to the nodes holding the devices with ToNode.
+var Broadcast = define[coreTypes.BroadcastMessage](EventBroadcast, 1, ChatEventsExchange, amqp.Table{routeBroadcast: "true"}, false)
+
+// Presence is published on user_events when the presence of a user changes.
+var Presence = define[coreTypes.PresenceEvent](coreTypes.EventPresence, 1, UserEventsExchange, nil, true)
+
+// ToNode returns the event routed to the queue of a ws-service node instead.
+func (e Event[T]) ToNode(nodeID string) Event[T] {
+	e.headers = amqp.Table{routeNode: nodeID}
+	return e
+}
+
+// Publishing encodes payload into a publishing routed like the event.
+func (e Event[T]) Publishing(payload T) (amqp.Publishing, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	headers := amqp.Table{SchemaVersionHeader: int32(e.Version)}
+	maps.Copy(headers, e.headers)
+
+	deliveryMode := amqp.Persistent
+	if e.transient {
+		deliveryMode = amqp.Transient
+	}
+
+	return amqp.Publishing{
+		Headers:      headers,
+		ContentType:  "application/json",
+		DeliveryMode: deliveryMode,
+		Timestamp:    time.Now(),
+		Type:         e.Type,
+		Body:         body,
+	}, nil
+}
+
+// Decode reads the payload of a delivery of the event.
+func (e Event[T]) Decode(delivery amqp.Delivery) (T, error) {
+	var payload T
+	if err := CheckVersion(delivery); err != nil {
+		return payload, err
+	}
+
+	if err := json.Unmarshal(delivery.Body, &payload); err != nil {
+		return payload, fmt.Errorf("invalid %s event: %w", e.Type, err)
+	}
+	return payload, nil
+}
+
+// CheckVersion refuses the deliveries following a newer schema than the one their
+// type has in this service, which would be misread. Deliveries of unknown types are let
+// through for their consumer to handle.
+func CheckVersion(delivery amqp.Delivery) error {
+	supported, ok := versions[delivery.Type]
+	if !ok {
+		return nil
+	}
+
+	if version := SchemaVersion(delivery.Headers); version > supported {
+		return fmt.Errorf("%w: %s event follows version %d, at most %d is supported", ErrUnsupportedVersion, delivery.Type, version, supported)
+	}
+	return nil
+}
+
+// SchemaVersion reads the schema version of a publishing from its headers. Headers may
+// have gone through JSON, which turns numbers into floats.
+func SchemaVersion(headers amqp.Table) int {
+	switch version := headers[SchemaVersionHeader].(type) {
+	case int32:
+		return int(version)
+	case int64:
+		return int(version)
+	case int:
+		return version
+	case float64:
+		return int(version)
+	case string:
+		if parsed, err := strconv.Atoi(version); err == nil {
+			return parsed
+		}
+	}
+	return 1
+}
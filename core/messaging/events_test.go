@@ -0,0 +1,102 @@
+package messaging
+
+import (
+	"testing"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    int
+	}{
+		{name: "it should default to version 1 without headers", headers: nil, want: 1},
+		{name: "it should default to version 1 without the header", headers: amqp.Table{"other": int32(3)}, want: 1},
+		{name: "it should read an int32 version", headers: amqp.Table{SchemaVersionHeader: int32(2)}, want: 2},
+		{name: "it should read an int64 version", headers: amqp.Table{SchemaVersionHeader: int64(3)}, want: 3},
+		{name: "it should read an int version", headers: amqp.Table{SchemaVersionHeader: 4}, want: 4},
+		{name: "it should read a version that went through JSON", headers: amqp.Table{SchemaVersionHeader: float64(5)}, want: 5},
+		{name: "it should read a version written as a string", headers: amqp.Table{SchemaVersionHeader: "6"}, want: 6},
+		{name: "it should default to version 1 for an unreadable string", headers: amqp.Table{SchemaVersionHeader: "v2"}, want: 1},
+		{name: "it should default to version 1 for another type", headers: amqp.Table{SchemaVersionHeader: true}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SchemaVersion(tt.headers))
+		})
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		delivery amqp.Delivery
+		wantErr  bool
+	}{
+		{
+			name:     "it should accept a delivery without a version",
+			delivery: amqp.Delivery{Type: coreTypes.EventChatMessage},
+		},
+		{
+			name:     "it should accept a delivery of the supported version",
+			delivery: amqp.Delivery{Type: coreTypes.EventChatMessage, Headers: amqp.Table{SchemaVersionHeader: int32(ChatMessage.Version)}},
+		},
+		{
+			name:     "it should accept a delivery of an older version",
+			delivery: amqp.Delivery{Type: coreTypes.EventChatMessage, Headers: amqp.Table{SchemaVersionHeader: int32(0)}},
+		},
+		{
+			name:     "it should refuse a delivery of a newer version",
+			delivery: amqp.Delivery{Type: coreTypes.EventChatMessage, Headers: amqp.Table{SchemaVersionHeader: int32(ChatMessage.Version + 1)}},
+			wantErr:  true,
+		},
+		{
+			name:     "it should refuse a newer version that went through JSON",
+			delivery: amqp.Delivery{Type: EventBroadcast, Headers: amqp.Table{SchemaVersionHeader: float64(Broadcast.Version + 1)}},
+			wantErr:  true,
+		},
+		{
+			name:     "it should let through deliveries of unknown types",
+			delivery: amqp.Delivery{Type: "unknown", Headers: amqp.Table{SchemaVersionHeader: int32(99)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckVersion(tt.delivery)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrUnsupportedVersion)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestEventDecode(t *testing.T) {
+	t.Run("it should decode a publishing of the event", func(t *testing.T) {
+		publishing, err := Receipt.Publishing(coreTypes.Receipt{UserID: "user-1", Status: coreTypes.StatusRead})
+		assert.NoError(t, err)
+
+		receipt, err := Receipt.Decode(amqp.Delivery{Type: publishing.Type, Headers: publishing.Headers, Body: publishing.Body})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "user-1", receipt.UserID)
+		assert.Equal(t, coreTypes.StatusRead, receipt.Status)
+	})
+
+	t.Run("it should not decode a delivery of a newer version", func(t *testing.T) {
+		_, err := Receipt.Decode(amqp.Delivery{
+			Type:    Receipt.Type,
+			Headers: amqp.Table{SchemaVersionHeader: int32(Receipt.Version + 1)},
+			Body:    []byte(`{}`),
+		})
+
+		assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	})
+}
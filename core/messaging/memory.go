@@ -0,0 +1,312 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MemoryBus routes events in process the way RabbitMQ routes them with the topology of
+// the given Queues, so the flow between services can be tested without a broker.
+// Deliveries expiring in a queue with a dead-letter exchange, like the retry queues, are
+// dead-lettered; other queue arguments are ignored. The prefetch of a consumer bounds
+// the unacknowledged deliveries of its queue.
+type MemoryBus struct {
+	mu        sync.Mutex
+	exchanges map[string]string
+	queues    map[string]*memoryQueue
+	names     []string
+	tags      map[uint64]*memoryQueue
+	nextTag   uint64
+}
+
+type memoryQueue struct {
+	queue
+	ready   []amqp.Delivery
+	unacked map[uint64]amqp.Delivery
+	wake    chan struct{}
+}
+
+// NewMemoryBus declares the topologies of every service taking part in a test.
+func NewMemoryBus(queues ...Queues) *MemoryBus {
+	b := &MemoryBus{
+		exchanges: map[string]string{},
+		queues:    map[string]*memoryQueue{},
+		tags:      map[uint64]*memoryQueue{},
+	}
+
+	for _, q := range queues {
+		exchanges, declared := q.declarations()
+		for _, exchange := range exchanges {
+			b.exchanges[exchange.name] = exchange.kind
+		}
+		for _, spec := range declared {
+			if _, ok := b.queues[spec.name]; ok {
+				continue
+			}
+			b.queues[spec.name] = &memoryQueue{queue: spec, unacked: map[uint64]amqp.Delivery{}, wake: make(chan struct{})}
+			b.names = append(b.names, spec.name)
+		}
+	}
+	slices.Sort(b.names)
+
+	return b
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.publish(exchange, key, msg)
+}
+
+// publish routes msg to the queues bound to exchange. b.mu is held.
+func (b *MemoryBus) publish(exchange, key string, msg amqp.Publishing) error {
+	var targets []*memoryQueue
+	if exchange == "" {
+		if q, ok := b.queues[key]; ok {
+			targets = append(targets, q)
+		}
+	} else {
+		kind, ok := b.exchanges[exchange]
+		if !ok {
+			return fmt.Errorf("exchange %q is not declared", exchange)
+		}
+		for _, name := range b.names {
+			if q := b.queues[name]; q.exchange == exchange && q.matches(kind, key, msg.Headers) {
+				targets = append(targets, q)
+			}
+		}
+	}
+
+	if len(targets) == 0 {
+		return ErrUnroutable
+	}
+
+	for _, q := range targets {
+		b.enqueue(q, exchange, key, msg)
+	}
+	return nil
+}
+
+// matches tells whether a publishing sent with key and headers to an exchange of the
+// given kind is routed to the queue.
+func (q *memoryQueue) matches(kind, key string, headers amqp.Table) bool {
+	switch kind {
+	case "fanout":
+		return true
+	case "direct":
+		return q.key == key
+	case "headers":
+		// x-match any routes on the first header matching, all on the first one not
+		// matching.
+		matchAny := q.headers["x-match"] == "any"
+		for name, value := range q.headers {
+			if strings.HasPrefix(name, "x-") {
+				continue
+			}
+			if matched := headers[name] == value; matched == matchAny {
+				return matched
+			}
+		}
+		return !matchAny
+	default:
+		return false
+	}
+}
+
+// enqueue adds a delivery to a queue. b.mu is held.
+func (b *MemoryBus) enqueue(q *memoryQueue, exchange, key string, msg amqp.Publishing) {
+	b.nextTag++
+	delivery := amqp.Delivery{
+		Acknowledger:  b,
+		Headers:       maps.Clone(msg.Headers),
+		ContentType:   msg.ContentType,
+		DeliveryMode:  msg.DeliveryMode,
+		CorrelationId: msg.CorrelationId,
+		Expiration:    msg.Expiration,
+		MessageId:     msg.MessageId,
+		Timestamp:     msg.Timestamp,
+		Type:          msg.Type,
+		AppId:         msg.AppId,
+		DeliveryTag:   b.nextTag,
+		Exchange:      exchange,
+		RoutingKey:    key,
+		Body:          msg.Body,
+	}
+	q.ready = append(q.ready, delivery)
+	q.wakeUp()
+
+	deadLetterExchange, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok || msg.Expiration == "" {
+		return
+	}
+	if ttl, err := strconv.Atoi(msg.Expiration); err == nil {
+		time.AfterFunc(time.Duration(ttl)*time.Millisecond, func() {
+			b.expire(q, delivery.DeliveryTag, deadLetterExchange)
+		})
+	}
+}
+
+// expire dead-letters a delivery still waiting in its queue.
+func (b *MemoryBus) expire(q *memoryQueue, tag uint64, deadLetterExchange string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := slices.IndexFunc(q.ready, func(delivery amqp.Delivery) bool { return delivery.DeliveryTag == tag })
+	if i < 0 {
+		return
+	}
+	delivery := q.ready[i]
+	q.ready = slices.Delete(q.ready, i, i+1)
+
+	key := delivery.RoutingKey
+	if routingKey, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		key = routingKey
+	}
+	b.publish(deadLetterExchange, key, publishingOf(delivery))
+}
+
+func (b *MemoryBus) Consume(ctx context.Context, queueName string, prefetch int) (<-chan amqp.Delivery, error) {
+	b.mu.Lock()
+	q, ok := b.queues[queueName]
+	b.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("queue %q is not declared", queueName)
+	}
+
+	out := make(chan amqp.Delivery)
+	go func() {
+		defer close(out)
+		for {
+			delivery, wake, ok := b.next(q, prefetch)
+			if !ok {
+				select {
+				case <-ctx.Done():
+					return
+				case <-wake:
+					continue
+				}
+			}
+
+			select {
+			case out <- delivery:
+			case <-ctx.Done():
+				b.Nack(delivery.DeliveryTag, false, true)
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// next takes the next delivery of a queue, or returns a channel closed once the queue
+// changes.
+func (b *MemoryBus) next(q *memoryQueue, prefetch int) (amqp.Delivery, <-chan struct{}, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(q.ready) == 0 || (prefetch > 0 && len(q.unacked) >= prefetch) {
+		return amqp.Delivery{}, q.wake, false
+	}
+
+	delivery := q.ready[0]
+	q.ready = q.ready[1:]
+	q.unacked[delivery.DeliveryTag] = delivery
+	b.tags[delivery.DeliveryTag] = q
+	return delivery, nil, true
+}
+
+// Len returns the number of deliveries waiting in a queue, acknowledged ones aside.
+func (b *MemoryBus) Len(queueName string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[queueName]
+	if !ok {
+		return 0
+	}
+	return len(q.ready) + len(q.unacked)
+}
+
+func (b *MemoryBus) Ack(tag uint64, multiple bool) error {
+	return b.settle(tag, multiple, func(*memoryQueue, amqp.Delivery) {})
+}
+
+func (b *MemoryBus) Nack(tag uint64, multiple, requeue bool) error {
+	return b.settle(tag, multiple, func(q *memoryQueue, delivery amqp.Delivery) {
+		if requeue {
+			delivery.Redelivered = true
+			q.ready = append([]amqp.Delivery{delivery}, q.ready...)
+		}
+	})
+}
+
+func (b *MemoryBus) Reject(tag uint64, requeue bool) error {
+	return b.Nack(tag, false, requeue)
+}
+
+// settle removes the unacknowledged deliveries up to tag, or only tag, and hands them
+// to done.
+func (b *MemoryBus) settle(tag uint64, multiple bool, done func(*memoryQueue, amqp.Delivery)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.tags[tag]
+	if !ok {
+		return fmt.Errorf("unknown delivery tag %d", tag)
+	}
+
+	tags := []uint64{tag}
+	if multiple {
+		tags = nil
+		for t := range q.unacked {
+			if t <= tag {
+				tags = append(tags, t)
+			}
+		}
+		slices.Sort(tags)
+	}
+
+	// Requeued deliveries go back in front of the queue in their order.
+	for _, t := range slices.Backward(tags) {
+		delivery := q.unacked[t]
+		delete(q.unacked, t)
+		delete(b.tags, t)
+		done(q, delivery)
+	}
+	q.wakeUp()
+	return nil
+}
+
+// wakeUp tells the consumers of the queue it changed. b.mu is held.
+func (q *memoryQueue) wakeUp() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// publishingOf turns a delivery back into a publishing.
+func publishingOf(delivery amqp.Delivery) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:       delivery.Headers,
+		ContentType:   delivery.ContentType,
+		DeliveryMode:  delivery.DeliveryMode,
+		CorrelationId: delivery.CorrelationId,
+		MessageId:     delivery.MessageId,
+		Timestamp:     delivery.Timestamp,
+		Type:          delivery.Type,
+		AppId:         delivery.AppId,
+		Body:          delivery.Body,
+	}
+}
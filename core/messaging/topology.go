@@ -0,0 +1,168 @@
+package messaging
+
+import (
+	"time"
+
+	"github.com/hoyci/ms-chat/core/broker"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Every event goes through one of two exchanges. chat_events is a headers exchange: the
+// headers of a publishing pick the queues it is routed to. user_events is a fanout
+// exchange every service interested in users binds a queue of its own to.
+const (
+	ChatEventsExchange = "chat_events"
+	UserEventsExchange = "user_events"
+)
+
+// Headers routing the publishings of chat_events.
+const (
+	routePersistence = "persistence"
+	routeBroadcast   = "broadcast"
+	routeEphemeral   = "ephemeral"
+	routeNode        = "node"
+)
+
+// Queues names the queues a service uses. Each service declares the queues it publishes
+// to or consumes from; those left empty are not declared. Services declaring the same
+// queue must agree on its arguments, EphemeralTTL included, or the broker refuses the
+// second declaration.
+type Queues struct {
+	// Persistence receives the events message-service persists.
+	Persistence string
+	// Broadcast receives the broadcasts message-service asks ws-service to deliver.
+	Broadcast string
+	// Ephemeral receives the typing events, dropped after EphemeralTTL.
+	Ephemeral    string
+	EphemeralTTL time.Duration
+	// UserEvents is the queue of the service on user_events.
+	UserEvents string
+	// NodeID is set by the ws-service nodes, which receive the broadcasts routed to
+	// their devices on a queue that lives as long as the node.
+	NodeID string
+	// DeadLetterExchange is set by the consumer of the persistence queue, which retries
+	// and dead-letters the deliveries it fails to process.
+	DeadLetterExchange string
+}
+
+func NodeQueueName(nodeID string) string {
+	return "ws_node." + nodeID
+}
+
+func RetryQueueName(queueName string) string {
+	return queueName + ".retry"
+}
+
+func DeadLetterQueueName(queueName string) string {
+	return queueName + ".dead"
+}
+
+// exchange is an exchange of the topology.
+type exchange struct {
+	name string
+	kind string
+}
+
+// queue is a queue of the topology and the binding routing publishings to it. Transient
+// queues are auto-deleted along with the connection that declared them.
+type queue struct {
+	name      string
+	durable   bool
+	transient bool
+	args      amqp.Table
+	exchange  string
+	key       string
+	headers   amqp.Table
+}
+
+// declarations lists the exchanges and the queues of queues, in declaration order.
+func (queues Queues) declarations() ([]exchange, []queue) {
+	exchanges := []exchange{
+		{name: ChatEventsExchange, kind: "headers"},
+		{name: UserEventsExchange, kind: "fanout"},
+	}
+	var declared []queue
+
+	if queues.Persistence != "" {
+		declared = append(declared, queue{
+			name: queues.Persistence, durable: true, exchange: ChatEventsExchange,
+			headers: amqp.Table{"x-match": "any", routePersistence: "true"},
+		})
+
+		// The retry queue has no consumer: deliveries expire there and are
+		// dead-lettered back to the queue. The dead-letter queue keeps the deliveries
+		// that could not be processed.
+		if queues.DeadLetterExchange != "" {
+			exchanges = append(exchanges, exchange{name: queues.DeadLetterExchange, kind: "direct"})
+			declared = append(declared,
+				queue{
+					name: RetryQueueName(queues.Persistence), durable: true,
+					args: amqp.Table{"x-dead-letter-exchange": "", "x-dead-letter-routing-key": queues.Persistence},
+				},
+				queue{
+					name: DeadLetterQueueName(queues.Persistence), durable: true,
+					exchange: queues.DeadLetterExchange, key: queues.Persistence,
+				},
+			)
+		}
+	}
+
+	if queues.Broadcast != "" {
+		declared = append(declared, queue{
+			name: queues.Broadcast, durable: true, exchange: ChatEventsExchange,
+			headers: amqp.Table{"x-match": "any", routeBroadcast: "true"},
+		})
+	}
+
+	// Typing indicators are stale after a few seconds: they are not persisted and are
+	// dropped if message-service does not pick them up in time.
+	if queues.Ephemeral != "" {
+		declared = append(declared, queue{
+			name: queues.Ephemeral, exchange: ChatEventsExchange,
+			args:    amqp.Table{"x-message-ttl": queues.EphemeralTTL.Milliseconds()},
+			headers: amqp.Table{"x-match": "any", routeEphemeral: "true"},
+		})
+	}
+
+	// Every replica of a service consumes the same queue, so each user event is handled
+	// once per service.
+	if queues.UserEvents != "" {
+		declared = append(declared, queue{name: queues.UserEvents, durable: true, exchange: UserEventsExchange})
+	}
+
+	if queues.NodeID != "" {
+		declared = append(declared, queue{
+			name: NodeQueueName(queues.NodeID), transient: true, exchange: ChatEventsExchange,
+			headers: amqp.Table{"x-match": "all", routeNode: queues.NodeID},
+		})
+	}
+
+	return exchanges, declared
+}
+
+// Topology declares the exchanges, and the queues of queues with their bindings.
+func Topology(queues Queues) broker.Topology {
+	return func(channel *amqp.Channel) error {
+		exchanges, declared := queues.declarations()
+
+		for _, exchange := range exchanges {
+			if err := channel.ExchangeDeclare(exchange.name, exchange.kind, true, false, false, false, nil); err != nil {
+				return err
+			}
+		}
+
+		for _, queue := range declared {
+			if _, err := channel.QueueDeclare(queue.name, queue.durable, queue.transient, queue.transient, false, queue.args); err != nil {
+				return err
+			}
+			if queue.exchange == "" {
+				continue
+			}
+			if err := channel.QueueBind(queue.name, queue.key, queue.exchange, false, queue.headers); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
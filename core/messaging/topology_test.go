@@ -0,0 +1,92 @@
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueuesDeclarations(t *testing.T) {
+	chatEvents := exchange{name: ChatEventsExchange, kind: "headers"}
+	userEvents := exchange{name: UserEventsExchange, kind: "fanout"}
+
+	tests := []struct {
+		name          string
+		queues        Queues
+		wantExchanges []exchange
+		wantQueues    []queue
+	}{
+		{
+			name:          "it should only declare the exchanges without queues",
+			queues:        Queues{},
+			wantExchanges: []exchange{chatEvents, userEvents},
+		},
+		{
+			name:          "it should bind the persistence queue to the persistence route",
+			queues:        Queues{Persistence: "chat_messages"},
+			wantExchanges: []exchange{chatEvents, userEvents},
+			wantQueues: []queue{{
+				name: "chat_messages", durable: true, exchange: ChatEventsExchange,
+				headers: amqp.Table{"x-match": "any", routePersistence: "true"},
+			}},
+		},
+		{
+			name:          "it should declare the retry and dead-letter queues of the persistence queue",
+			queues:        Queues{Persistence: "chat_messages", DeadLetterExchange: "chat_dead"},
+			wantExchanges: []exchange{chatEvents, userEvents, {name: "chat_dead", kind: "direct"}},
+			wantQueues: []queue{
+				{
+					name: "chat_messages", durable: true, exchange: ChatEventsExchange,
+					headers: amqp.Table{"x-match": "any", routePersistence: "true"},
+				},
+				{
+					name: "chat_messages.retry", durable: true,
+					args: amqp.Table{"x-dead-letter-exchange": "", "x-dead-letter-routing-key": "chat_messages"},
+				},
+				{name: "chat_messages.dead", durable: true, exchange: "chat_dead", key: "chat_messages"},
+			},
+		},
+		{
+			name:          "it should not declare a dead-letter exchange without a persistence queue",
+			queues:        Queues{DeadLetterExchange: "chat_dead"},
+			wantExchanges: []exchange{chatEvents, userEvents},
+		},
+		{
+			name:          "it should expire the ephemeral queue after its TTL",
+			queues:        Queues{Ephemeral: "chat_typing", EphemeralTTL: 5 * time.Second},
+			wantExchanges: []exchange{chatEvents, userEvents},
+			wantQueues: []queue{{
+				name: "chat_typing", exchange: ChatEventsExchange,
+				args:    amqp.Table{"x-message-ttl": int64(5000)},
+				headers: amqp.Table{"x-match": "any", routeEphemeral: "true"},
+			}},
+		},
+		{
+			name:          "it should declare every queue of a service in order",
+			queues:        Queues{Broadcast: "chat_broadcasts", UserEvents: "ws_user_events", NodeID: "node-1"},
+			wantExchanges: []exchange{chatEvents, userEvents},
+			wantQueues: []queue{
+				{
+					name: "chat_broadcasts", durable: true, exchange: ChatEventsExchange,
+					headers: amqp.Table{"x-match": "any", routeBroadcast: "true"},
+				},
+				{name: "ws_user_events", durable: true, exchange: UserEventsExchange},
+				{
+					name: "ws_node.node-1", transient: true, exchange: ChatEventsExchange,
+					headers: amqp.Table{"x-match": "all", routeNode: "node-1"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exchanges, declared := tt.queues.declarations()
+
+			assert.Equal(t, tt.wantExchanges, exchanges)
+			assert.Equal(t, tt.wantQueues, declared)
+		})
+	}
+}
@@ -24,11 +24,31 @@ type UnauthorizedResponse struct {
 	Error string `json:"error"`
 }
 
+type ForbiddenResponse struct {
+	Error string `json:"error"`
+}
+
+type ConflictResponse struct {
+	Error string `json:"error"`
+}
+
+type PayloadTooLargeResponse struct {
+	Error string `json:"error"`
+}
+
+type UnsupportedMediaTypeResponse struct {
+	Error string `json:"error"`
+}
+
 type ErrorResponse interface {
 	NotFoundResponse |
 		BadRequestResponse |
 		ContextCanceledResponse |
 		InternalServerErrorResponse |
 		BadRequestStructResponse |
-		UnauthorizedResponse
+		UnauthorizedResponse |
+		ForbiddenResponse |
+		ConflictResponse |
+		PayloadTooLargeResponse |
+		UnsupportedMediaTypeResponse
 }
@@ -4,20 +4,178 @@ import "time"
 
 type Status string
 
+// A message is sent once the server accepted it, delivered once a device of a recipient
+// acknowledged it and read once a recipient read past it.
 const (
-	StatusPending   Status = "pending"
+	StatusSent      Status = "sent"
 	StatusDelivered Status = "delivered"
+	StatusRead      Status = "read"
 )
 
+// Event types set on the chat_events publishings consumed by message-service.
+const (
+	EventChatMessage   = "chat.message"
+	EventReceipt       = "receipt"
+	EventSync          = "sync"
+	EventMessageEdit   = "message.edit"
+	EventMessageDelete = "message.delete"
+	EventReaction      = "reaction"
+)
+
+// DeleteScope tells whether a message is deleted for everyone or only hidden from the
+// user deleting it.
+type DeleteScope string
+
+const (
+	DeleteForMe       DeleteScope = "me"
+	DeleteForEveryone DeleteScope = "everyone"
+)
+
+// Message is a chat message as exchanged between services and delivered to devices. A
+// reply names the message it answers in ReplyTo and the first message of its thread in
+// ThreadID, and carries a Quote of the message it answers so devices can render it
+// without fetching it. Reactions lists the users who reacted with each emoji.
+// Attachments are uploaded to message-service beforehand and referenced by ID.
+// IdempotencyKey is the envelope ID of the frame the message was sent with; a sender
+// never has two messages with the same key.
 type Message struct {
-	ID         string     `json:"_id" bson:"_id"`
-	RoomID     string     `json:"room_id" bson:"room_id" validate:"required"`
-	SenderID   int        `json:"sender_id" bson:"sender_id" validate:"required"`
-	ReceiverID int        `json:"receiver_id" bson:"receiver_id" validate:"required"`
-	Content    string     `json:"content" bson:"content" validate:"required"`
-	Status     Status     `json:"status" bson:"status"`
-	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
-	UpdatedAt  *time.Time `json:"updated_at" bson:"updated_at"`
-	DeletedAt  *time.Time `json:"deleted_at" bson:"deleted_at"`
-	ClientID   string     `json:"-" bson:"-"`
+	ID             string              `json:"_id" bson:"_id"`
+	RoomID         string              `json:"room_id" bson:"room_id" validate:"required_without=ReceiverID"`
+	SenderID       string              `json:"sender_id" bson:"sender_id"`
+	ReceiverID     string              `json:"receiver_id" bson:"receiver_id" validate:"required_without=RoomID,omitempty,uuid"`
+	Content        string              `json:"content" bson:"content" validate:"required_without=Attachments"`
+	Status         Status              `json:"status" bson:"status"`
+	ReplyTo        string              `json:"reply_to,omitempty" bson:"reply_to,omitempty"`
+	ThreadID       string              `json:"thread_id,omitempty" bson:"thread_id,omitempty"`
+	Quote          *Quote              `json:"quote,omitempty" bson:"quote,omitempty"`
+	ReplyCount     int                 `json:"reply_count,omitempty" bson:"reply_count,omitempty"`
+	Reactions      map[string][]string `json:"reactions,omitempty" bson:"reactions,omitempty"`
+	Attachments    []Attachment        `json:"attachments,omitempty" bson:"attachments,omitempty"`
+	CreatedAt      time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt      *time.Time          `json:"updated_at" bson:"updated_at"`
+	DeletedAt      *time.Time          `json:"deleted_at" bson:"deleted_at"`
+	ClientID       string              `json:"client_id,omitempty" bson:"-"`
+	IdempotencyKey string              `json:"idempotency_key,omitempty" bson:"-"`
+}
+
+// Attachment describes a file attached to a message. The file is downloaded from
+// message-service, as is its thumbnail when HasThumbnail is set.
+type Attachment struct {
+	ID           string `json:"_id"`
+	Filename     string `json:"filename,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	HasThumbnail bool   `json:"has_thumbnail,omitempty"`
+}
+
+// Quote is the excerpt of the message a reply answers. Deleted is set, and Snippet
+// emptied, once that message is deleted for everyone.
+type Quote struct {
+	MessageID string `json:"message_id" bson:"message_id"`
+	SenderID  string `json:"sender_id" bson:"sender_id"`
+	Snippet   string `json:"snippet" bson:"snippet"`
+	Deleted   bool   `json:"deleted,omitempty" bson:"deleted,omitempty"`
+}
+
+// Receipt reports that UserID received (delivered) or read messages. Devices send
+// delivered receipts listing MessageIDs and read receipts carrying the last read message
+// of RoomID as UpToID; message-service answers the senders with the MessageIDs whose
+// status changed.
+type Receipt struct {
+	UserID     string    `json:"user_id"`
+	RoomID     string    `json:"room_id,omitempty"`
+	MessageIDs []string  `json:"message_ids,omitempty"`
+	UpToID     string    `json:"up_to_id,omitempty"`
+	Status     Status    `json:"status"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// SyncRequest asks message-service to replay the messages a device missed while it was
+// offline. With LastSeenID set, every message of the user rooms after it is replayed;
+// otherwise the messages the user did not receive yet are.
+type SyncRequest struct {
+	UserID     string    `json:"user_id"`
+	ClientID   string    `json:"client_id"`
+	LastSeenID string    `json:"last_seen_id,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// MessageEdit asks message-service to replace the content of a message on behalf of
+// UserID. RequestID is the envelope ID of the frame, echoed if the edit is rejected.
+type MessageEdit struct {
+	MessageID string    `json:"message_id"`
+	UserID    string    `json:"user_id"`
+	ClientID  string    `json:"client_id"`
+	RequestID string    `json:"request_id,omitempty"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// MessageDelete asks message-service to delete a message on behalf of UserID.
+type MessageDelete struct {
+	MessageID string      `json:"message_id"`
+	UserID    string      `json:"user_id"`
+	ClientID  string      `json:"client_id"`
+	RequestID string      `json:"request_id,omitempty"`
+	Scope     DeleteScope `json:"scope"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// MessageDeletion tells devices to drop a message, or to show it as deleted when it was
+// deleted for everyone.
+type MessageDeletion struct {
+	MessageID string      `json:"message_id"`
+	RoomID    string      `json:"room_id"`
+	Scope     DeleteScope `json:"scope"`
+	DeletedAt time.Time   `json:"deleted_at"`
+}
+
+type ReactionAction string
+
+const (
+	ReactionAdd    ReactionAction = "add"
+	ReactionRemove ReactionAction = "remove"
+)
+
+// ReactionChange asks message-service to add or remove the reaction of UserID to a
+// message and, once applied, tells the room members about it. ClientID and RequestID
+// are only set on requests.
+type ReactionChange struct {
+	MessageID string         `json:"message_id"`
+	RoomID    string         `json:"room_id,omitempty"`
+	UserID    string         `json:"user_id"`
+	Emoji     string         `json:"emoji"`
+	Action    ReactionAction `json:"action"`
+	ClientID  string         `json:"client_id,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// Rejection tells a device that message-service refused one of its frames. RequestID is
+// the envelope ID of the frame.
+type Rejection struct {
+	RequestID string `json:"request_id"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+// BroadcastMessage asks ws-service to deliver messages and their updates, reactions,
+// receipts, presence changes, typing indicators and rejections to every connected device
+// of the given users, except the device identified by ExcludeClientID. When ClientID is
+// set, only that device receives them.
+type BroadcastMessage struct {
+	UserIDs         []string          `json:"user_ids"`
+	Messages        []Message         `json:"messages,omitempty"`
+	Updated         []Message         `json:"updated,omitempty"`
+	Deleted         []MessageDeletion `json:"deleted,omitempty"`
+	Reactions       []ReactionChange  `json:"reactions,omitempty"`
+	Receipts        []Receipt         `json:"receipts,omitempty"`
+	Presence        []PresenceEvent   `json:"presence,omitempty"`
+	Typing          []TypingEvent     `json:"typing,omitempty"`
+	Rejections      []Rejection       `json:"rejections,omitempty"`
+	ClientID        string            `json:"client_id,omitempty"`
+	ExcludeClientID string            `json:"exclude_client_id,omitempty"`
+	Timestamp       time.Time         `json:"timestamp"`
 }
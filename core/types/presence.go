@@ -0,0 +1,25 @@
+package types
+
+import "time"
+
+type PresenceStatus string
+
+const (
+	PresenceOnline  PresenceStatus = "online"
+	PresenceAway    PresenceStatus = "away"
+	PresenceOffline PresenceStatus = "offline"
+)
+
+// EventPresence is the type of the presence events published on the user_events
+// exchange.
+const EventPresence = "presence"
+
+// PresenceEvent is published by ws-service when a device of the user connects, when the
+// user goes away or comes back, and when the last device of the user disconnects,
+// cleanly or not.
+type PresenceEvent struct {
+	UserID     string         `json:"user_id"`
+	Status     PresenceStatus `json:"status"`
+	LastSeenAt time.Time      `json:"last_seen_at"`
+	Timestamp  time.Time      `json:"timestamp"`
+}
@@ -0,0 +1,25 @@
+package types
+
+import "time"
+
+type TypingState string
+
+const (
+	TypingStart TypingState = "start"
+	TypingStop  TypingState = "stop"
+)
+
+// EventTyping is the type of the typing events published on the chat_events exchange.
+// They are never persisted: they only live in the ephemeral queue for a few seconds.
+const EventTyping = "typing"
+
+// TypingEvent tells the other members of a room that a user started or stopped typing.
+// A start event stands until ExpiresAt unless it is refreshed, so devices stop showing
+// the indicator even if the stop event is lost.
+type TypingEvent struct {
+	UserID    string      `json:"user_id"`
+	RoomID    string      `json:"room_id"`
+	State     TypingState `json:"state"`
+	ExpiresAt *time.Time  `json:"expires_at,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
@@ -6,7 +6,6 @@ import (
 	"github.com/gorilla/mux"
 	coreUtils "github.com/hoyci/ms-chat/core/utils"
 	"github.com/hoyci/ms-chat/message-service/config"
-	"github.com/hoyci/ms-chat/message-service/service/healthcheck"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
@@ -24,10 +23,14 @@ func NewApiServer(addr string) *APIServer {
 	}
 }
 
-func (s *APIServer) SetupRouter(
-	healthCheckHandler *healthcheck.HealthCheckHandler,
-	// roomHandler *room.RoomHandler,
-) *mux.Router {
+// Routes is implemented by the handlers of the service packages, which each add their
+// own endpoints to the versioned API router.
+type Routes interface {
+	RegisterRoutes(router *mux.Router)
+}
+
+// SetupRouter serves the documentation and the endpoints of handlers under /api/v1.
+func (s *APIServer) SetupRouter(handlers ...Routes) *mux.Router {
 	coreUtils.InitLogger()
 	router := mux.NewRouter()
 	subrouter := router.PathPrefix("/api/v1").Subrouter()
@@ -43,10 +46,9 @@ func (s *APIServer) SetupRouter(
 		httpSwagger.DomID("swagger-ui"),
 	)).Methods(http.MethodGet)
 
-	subrouter.HandleFunc("/healthcheck", healthCheckHandler.HandleHealthCheck).Methods(http.MethodGet)
-
-	// subrouter.HandleFunc("/rooms", roomHandler.HandleCreateRoom).Methods(http.MethodPost)
-	// subrouter.HandleFunc("/rooms", roomHandler.HandleGetRoomByID).Methods(http.MethodGet)
+	for _, handler := range handlers {
+		handler.RegisterRoutes(subrouter)
+	}
 
 	s.Router = router
 
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	"github.com/hoyci/ms-chat/message-service/config"
+	"github.com/hoyci/ms-chat/message-service/service/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Deliveries message-service failed to process are kept in the dead-letter queue of
+// the queue they came from. This command lists them, without removing them, or hands
+// them back to their queue once the cause of the failure is fixed:
+//
+//	go run cmd/deadletters/main.go -queue persistence_queue -limit 20 list
+//	go run cmd/deadletters/main.go -queue persistence_queue -limit 20 replay
+func main() {
+	queueName := flag.String("queue", config.Envs.PersistenceQueueName, "Queue whose dead letters are handled")
+	limit := flag.Int("limit", 20, "Maximum number of dead letters to handle")
+	flag.Parse()
+
+	conn, err := amqp.Dial(config.Envs.RabbitMQURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+	}
+	defer conn.Close()
+
+	channel, err := conn.Channel()
+	if err != nil {
+		log.Fatalf("Failed to open channel: %v", err)
+	}
+	defer channel.Close()
+
+	switch flag.Arg(0) {
+	case "list":
+		deadLetters, err := rabbitmq.InspectDeadLetters(channel, *queueName, *limit)
+		if err != nil {
+			log.Fatalf("Failed to list dead letters: %v", err)
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		for _, deadLetter := range deadLetters {
+			if err := encoder.Encode(deadLetter); err != nil {
+				log.Fatalf("Failed to print dead letter: %v", err)
+			}
+		}
+		log.Printf("%d dead letters listed from %s", len(deadLetters), messaging.DeadLetterQueueName(*queueName))
+	case "replay":
+		replayed, err := rabbitmq.ReplayDeadLetters(channel, *queueName, *limit)
+		if err != nil {
+			log.Fatalf("Replay stopped after %d dead letters: %v", replayed, err)
+		}
+		log.Printf("%d dead letters replayed to %s", replayed, *queueName)
+	default:
+		fmt.Fprintln(os.Stderr, "usage: deadletters [-queue name] [-limit n] list|replay")
+		os.Exit(2)
+	}
+}
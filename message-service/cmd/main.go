@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -8,9 +9,14 @@ import (
 	"github.com/hoyci/ms-chat/message-service/cmd/api"
 	"github.com/hoyci/ms-chat/message-service/config"
 	"github.com/hoyci/ms-chat/message-service/db"
+	"github.com/hoyci/ms-chat/message-service/keys"
+	"github.com/hoyci/ms-chat/message-service/service/attachment"
+	"github.com/hoyci/ms-chat/message-service/service/blob"
 	"github.com/hoyci/ms-chat/message-service/service/healthcheck"
+	"github.com/hoyci/ms-chat/message-service/service/message"
 	"github.com/hoyci/ms-chat/message-service/service/rabbitmq"
 	"github.com/hoyci/ms-chat/message-service/service/room"
+	"github.com/hoyci/ms-chat/message-service/service/search"
 )
 
 // @title Message Service API
@@ -22,27 +28,65 @@ import (
 // @in header
 // @name Authorization
 func main() {
+	keys.LoadRunKeys()
 	dbRepo := db.NewMongoRepository(config.Envs)
 
-	rabbitmq.Init(dbRepo)
-	defer rabbitmq.GetChannel().Close()
-	go rabbitmq.ConsumeQueue(
-		rabbitmq.GetChannel(),
-		config.Envs.PersistenceQueueName,
-		rabbitmq.ProcessChatMessage,
-	)
-
 	path := fmt.Sprintf("0.0.0.0:%d", config.Envs.Port)
 	apiServer := api.NewApiServer(path)
 
-	healthCheckHandler := healthcheck.NewHealthCheckHandler(config.Envs)
+	healthCheckHandler := healthcheck.NewHealthCheckHandler(config.Envs, rabbitmq.Monitor{})
 
 	roomStore := room.GetRoomStore(dbRepo)
-	room.NewRoomHandler(roomStore)
+	if err := roomStore.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create room indexes: %v", err)
+	}
+	roomHandler := room.NewRoomHandler(roomStore)
+
+	messageStore := message.GetMessageStore(dbRepo)
+	if err := messageStore.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create message indexes: %v", err)
+	}
+	attachmentStore := attachment.GetAttachmentStore(dbRepo)
+
+	rabbitmq.Init()
+	bus := rabbitmq.NewBus()
+	processor := rabbitmq.NewProcessor(bus, roomStore, messageStore, attachmentStore)
+	go func() {
+		err := rabbitmq.ConsumeQueue(context.Background(), bus, config.Envs.PersistenceQueueName, processor.PersistenceProcessors(), rabbitmq.DefaultRetryPolicy())
+		log.Fatalf("Stopped consuming %s: %v", config.Envs.PersistenceQueueName, err)
+	}()
+	// Typing indicators are stale by the time a retry would handle them.
+	go func() {
+		err := rabbitmq.ConsumeQueue(
+			context.Background(),
+			bus,
+			config.Envs.EphemeralQueueName,
+			processor.EphemeralProcessors(),
+			rabbitmq.RetryPolicy{Prefetch: config.Envs.ConsumerPrefetch, Timeout: config.Envs.ConsumerTimeout},
+		)
+		log.Fatalf("Stopped consuming %s: %v", config.Envs.EphemeralQueueName, err)
+	}()
+
+	messageHandler := message.NewMessageHandler(messageStore, roomStore, rabbitmq.NewBroadcaster(bus))
+
+	blobStore, err := blob.New(config.Envs)
+	if err != nil {
+		log.Fatalf("Failed to set up the blob store: %v", err)
+	}
+	attachmentHandler := attachment.NewAttachmentHandler(attachmentStore, roomStore, blobStore)
+
+	textSearcher := search.GetTextSearcher(dbRepo)
+	if err := textSearcher.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create search indexes: %v", err)
+	}
+	searchHandler := search.NewSearchHandler(textSearcher, roomStore)
 
 	apiServer.SetupRouter(
 		healthCheckHandler,
-		// roomHandler,
+		roomHandler,
+		messageHandler,
+		attachmentHandler,
+		searchHandler,
 	)
 	log.Println("Listening on:", path)
 	http.ListenAndServe(path, apiServer.Router)
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/config"
+	"github.com/hoyci/ms-chat/message-service/db"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Rooms and messages created before user IDs became UUIDs store them as integers.
+// This command rewrites them using a JSON mapping of legacy IDs to UUIDs, e.g.
+// {"1": "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"}:
+//
+//	go run cmd/migrate/main.go -mapping user_ids.json up
+//	go run cmd/migrate/main.go -mapping user_ids.json down
+//
+// Direct rooms created before they had a direct key are backfilled, and duplicates of
+// the same conversation merged, with the command below. It must run before the service
+// starts, as the unique index on direct_key cannot be built over duplicates:
+//
+//	go run cmd/migrate/main.go direct-keys
+//
+// Messages stored as pending before receipts existed are renamed to sent with:
+//
+//	go run cmd/migrate/main.go statuses
+//
+// Messages delivered before deliveries were tracked per recipient are recorded as
+// delivered to every member of their room, so syncs do not replay them, with:
+//
+//	go run cmd/migrate/main.go delivered-to
+func main() {
+	mappingPath := flag.String("mapping", "user_ids.json", "JSON file mapping legacy int user IDs to UUIDs")
+	flag.Parse()
+
+	dbRepo := db.NewMongoRepository(config.Envs)
+	ctx := context.Background()
+
+	cmd := flag.Arg(0)
+	switch cmd {
+	case "direct-keys":
+		if err := migrateDirectKeys(ctx, dbRepo); err != nil {
+			log.Fatalf("Direct key migration failed: %v", err)
+		}
+		log.Println("Direct rooms keyed successfully.")
+		return
+	case "statuses":
+		count, err := migrateStatuses(ctx, dbRepo)
+		if err != nil {
+			log.Fatalf("Status migration failed: %v", err)
+		}
+		log.Printf("Renamed the status of %d pending messages to sent.", count)
+		return
+	case "delivered-to":
+		count, err := migrateDeliveredTo(ctx, dbRepo)
+		if err != nil {
+			log.Fatalf("Delivery migration failed: %v", err)
+		}
+		log.Printf("Recorded the recipients of %d delivered messages.", count)
+		return
+	}
+
+	mapping, err := loadMapping(*mappingPath)
+	if err != nil {
+		log.Fatalf("Failed to load user ID mapping: %v", err)
+	}
+
+	switch cmd {
+	case "up":
+		legacyToUUID := make(map[any]any, len(mapping))
+		for legacyID, userID := range mapping {
+			legacyToUUID[legacyID] = userID
+		}
+		if err := migrate(ctx, dbRepo, legacyToUUID, bson.M{"$type": "number"}); err != nil {
+			log.Fatalf("Migration up failed: %v", err)
+		}
+		log.Println("User IDs migrated to UUIDs successfully.")
+	case "down":
+		uuidToLegacy := make(map[any]any, len(mapping))
+		for legacyID, userID := range mapping {
+			uuidToLegacy[userID] = legacyID
+		}
+		if err := migrate(ctx, dbRepo, uuidToLegacy, bson.M{"$type": "string"}); err != nil {
+			log.Fatalf("Migration down failed: %v", err)
+		}
+		log.Println("User IDs reverted to legacy integers successfully.")
+	default:
+		log.Println("No command provided. Use 'up', 'down', 'direct-keys', 'statuses' or 'delivered-to'.")
+	}
+}
+
+// loadMapping reads the legacy ID mapping keyed by the legacy ID as an int64.
+func loadMapping(path string) (map[int64]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	mapping := make(map[int64]string, len(raw))
+	for legacyID, userID := range raw {
+		id, err := strconv.ParseInt(legacyID, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid legacy user ID %q: %w", legacyID, err)
+		}
+		mapping[id] = userID
+	}
+
+	return mapping, nil
+}
+
+// migrate rewrites every user reference matching typeFilter using ids. Keys of ids are
+// int64 legacy IDs or UUID strings depending on the direction of the migration.
+func migrate(ctx context.Context, dbRepo *db.MongoRepository, ids map[any]any, typeFilter bson.M) error {
+	rooms, err := db.List[bson.M](dbRepo, ctx, "rooms", bson.M{"users": bson.M{"$elemMatch": typeFilter}})
+	if err != nil {
+		return err
+	}
+
+	for _, room := range rooms {
+		users, ok := room["users"].(bson.A)
+		if !ok {
+			continue
+		}
+
+		migrated := make(bson.A, 0, len(users))
+		for _, user := range users {
+			newID, ok := ids[normalizeID(user)]
+			if !ok {
+				newID = user
+			}
+			migrated = append(migrated, newID)
+		}
+
+		if _, err := db.UpdateOne(dbRepo, ctx, "rooms", bson.M{"_id": room["_id"]}, bson.M{"$set": bson.M{"users": migrated}}); err != nil {
+			return err
+		}
+	}
+	log.Printf("Migrated %d rooms", len(rooms))
+
+	for oldID, newID := range ids {
+		for _, field := range []string{"sender_id", "receiver_id"} {
+			count, err := db.UpdateMany(dbRepo, ctx, "messages", bson.M{field: oldID}, bson.M{"$set": bson.M{field: newID}})
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				log.Printf("Migrated %s of %d messages from %v to %v", field, count, oldID, newID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// normalizeID converts the int32/int64 values decoded from Mongo into the int64 keys
+// used by the mapping.
+func normalizeID(id any) any {
+	switch v := id.(type) {
+	case int32:
+		return int64(v)
+	case int:
+		return int64(v)
+	default:
+		return v
+	}
+}
+
+// migrateDirectKeys types the rooms created before groups existed as direct rooms and
+// sets their direct key. When the old users-based lookup created several rooms for the
+// same pair, the oldest one is kept, the messages of the others are moved into it and
+// the others are soft deleted.
+func migrateDirectKeys(ctx context.Context, dbRepo *db.MongoRepository) error {
+	typed, err := db.UpdateMany(
+		dbRepo, ctx, "rooms",
+		bson.M{"type": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"type": types.RoomTypeDirect}},
+	)
+	if err != nil {
+		return err
+	}
+	log.Printf("Typed %d legacy rooms as direct", typed)
+
+	rooms, err := db.List[types.Room](
+		dbRepo, ctx, "rooms",
+		bson.M{"type": types.RoomTypeDirect, "direct_key": bson.M{"$exists": false}, "deleted_at": nil},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, len(rooms))
+	for i, room := range rooms {
+		keys[i] = types.DirectKey(room.Users)
+	}
+	keyedRooms, err := db.List[types.Room](dbRepo, ctx, "rooms", bson.M{"direct_key": bson.M{"$in": keys}})
+	if err != nil {
+		return err
+	}
+	keyed := make(map[string]bson.ObjectID, len(keyedRooms))
+	for _, room := range keyedRooms {
+		keyed[room.DirectKey] = room.ID
+	}
+
+	steps := planDirectKeys(rooms, keyed)
+	for _, step := range steps {
+		if step.merge {
+			moved, err := db.UpdateMany(
+				dbRepo, ctx, "messages", bson.M{"room_id": step.room.ID}, bson.M{"$set": bson.M{"room_id": step.keptID}},
+			)
+			if err != nil {
+				return err
+			}
+			if _, err := db.UpdateOne(
+				dbRepo, ctx, "rooms", bson.M{"_id": step.room.ID}, bson.M{"$set": bson.M{"deleted_at": time.Now()}},
+			); err != nil {
+				return err
+			}
+			log.Printf("Merged duplicate room %s into %s (%d messages)", step.room.ID.Hex(), step.keptID.Hex(), moved)
+			continue
+		}
+
+		if _, err := db.UpdateOne(
+			dbRepo, ctx, "rooms", bson.M{"_id": step.room.ID}, bson.M{"$set": bson.M{"direct_key": step.key}},
+		); err != nil {
+			return err
+		}
+	}
+	log.Printf("Keyed %d direct rooms", len(keyed))
+
+	return nil
+}
+
+// directKeyStep is what becomes of a legacy direct room: it either gets its direct key,
+// or is merged into keptID, the room already keyed for the same users.
+type directKeyStep struct {
+	room   types.Room
+	key    string
+	merge  bool
+	keptID bson.ObjectID
+}
+
+// planDirectKeys decides, oldest room first, which legacy rooms are keyed and which are
+// merged. keyed maps the direct keys already set to their room and is completed with
+// the rooms the plan keys.
+func planDirectKeys(rooms []types.Room, keyed map[string]bson.ObjectID) []directKeyStep {
+	steps := make([]directKeyStep, len(rooms))
+	for i, room := range rooms {
+		key := types.DirectKey(room.Users)
+		keptID, merge := keyed[key]
+		if !merge {
+			keyed[key] = room.ID
+		}
+		steps[i] = directKeyStep{room: room, key: key, merge: merge, keptID: keptID}
+	}
+	return steps
+}
+
+// migrateStatuses renames the pending status of the messages stored before receipts
+// existed to sent, the status receipts advance from.
+func migrateStatuses(ctx context.Context, dbRepo *db.MongoRepository) (int64, error) {
+	return db.UpdateMany(
+		dbRepo, ctx, "messages",
+		bson.M{"status": "pending"},
+		bson.M{"$set": bson.M{"status": coreTypes.StatusSent}},
+	)
+}
+
+// migrateDeliveredTo records the messages that left the sent status before deliveries
+// were tracked per recipient as delivered to every current member of their room. Which
+// members actually received them is unknown; replaying them to everyone once more would
+// be worse than missing one.
+func migrateDeliveredTo(ctx context.Context, dbRepo *db.MongoRepository) (int64, error) {
+	rooms, err := db.List[types.Room](dbRepo, ctx, "rooms", bson.M{})
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, room := range rooms {
+		count, err := db.UpdateMany(
+			dbRepo, ctx, "messages",
+			bson.M{
+				"room_id":      room.ID,
+				"status":       bson.M{"$ne": coreTypes.StatusSent},
+				"delivered_to": bson.M{"$exists": false},
+			},
+			bson.M{"$set": bson.M{"delivered_to": room.Users}},
+		)
+		if err != nil {
+			return total, err
+		}
+		total += count
+	}
+
+	return total, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/db"
+	"github.com/hoyci/ms-chat/message-service/testutils/mongotest"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestPlanDirectKeys(t *testing.T) {
+	oldest := types.Room{ID: bson.NewObjectID(), Users: []string{"bob", "alice"}}
+	duplicate := types.Room{ID: bson.NewObjectID(), Users: []string{"alice", "bob"}}
+	other := types.Room{ID: bson.NewObjectID(), Users: []string{"carol", "alice", "carol"}}
+	keyedID := bson.NewObjectID()
+
+	tests := []struct {
+		name  string
+		rooms []types.Room
+		keyed map[string]bson.ObjectID
+		want  []directKeyStep
+	}{
+		{
+			name:  "it should key the oldest room of a pair and merge the others into it",
+			rooms: []types.Room{oldest, duplicate},
+			keyed: map[string]bson.ObjectID{},
+			want: []directKeyStep{
+				{room: oldest, key: "alice:bob"},
+				{room: duplicate, key: "alice:bob", merge: true, keptID: oldest.ID},
+			},
+		},
+		{
+			name:  "it should merge into the room already keyed for the pair",
+			rooms: []types.Room{oldest, other},
+			keyed: map[string]bson.ObjectID{"alice:bob": keyedID},
+			want: []directKeyStep{
+				{room: oldest, key: "alice:bob", merge: true, keptID: keyedID},
+				{room: other, key: "alice:carol"},
+			},
+		},
+		{
+			name:  "it should plan nothing without legacy rooms",
+			rooms: nil,
+			keyed: map[string]bson.ObjectID{},
+			want:  []directKeyStep{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, planDirectKeys(tt.rooms, tt.keyed))
+		})
+	}
+}
+
+func TestMigrateStatuses(t *testing.T) {
+	ctx := context.Background()
+	dbRepo := mongotest.NewRepository(t)
+
+	statuses := map[bson.ObjectID]string{
+		bson.NewObjectID(): "pending",
+		bson.NewObjectID(): "pending",
+		bson.NewObjectID(): string(coreTypes.StatusDelivered),
+		bson.NewObjectID(): string(coreTypes.StatusRead),
+	}
+	for id, status := range statuses {
+		_, err := db.Add(dbRepo, ctx, "messages", bson.M{"_id": id, "status": status})
+		assert.NoError(t, err)
+	}
+
+	count, err := migrateStatuses(ctx, dbRepo)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	messages, err := db.List[types.Message](dbRepo, ctx, "messages", bson.M{})
+	assert.NoError(t, err)
+	for _, message := range messages {
+		want := coreTypes.Status(statuses[message.ID])
+		if want == "pending" {
+			want = coreTypes.StatusSent
+		}
+		assert.Equal(t, want, message.Status)
+	}
+
+	count, err = migrateStatuses(ctx, dbRepo)
+	assert.NoError(t, err)
+	assert.Zero(t, count, "running the migration again changes nothing")
+}
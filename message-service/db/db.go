@@ -36,6 +36,14 @@ func NewMongoRepository(cfg config.Config) *MongoRepository {
 	}
 }
 
+// DropDatabase drops the database of the repository and disconnects from MongoDB.
+func (repo *MongoRepository) DropDatabase(ctx context.Context) error {
+	if err := repo.client.Database(repo.config.DatabaseName).Drop(ctx); err != nil {
+		return err
+	}
+	return repo.client.Disconnect(ctx)
+}
+
 func Add[T any](repo *MongoRepository, ctx context.Context, collectionName string, document T) (bson.ObjectID, error) {
 	collection := repo.client.Database(repo.config.DatabaseName).Collection(collectionName)
 	result, err := collection.InsertOne(ctx, document)
@@ -49,9 +57,9 @@ func Add[T any](repo *MongoRepository, ctx context.Context, collectionName strin
 	return oid, nil
 }
 
-func List[T any](repo *MongoRepository, ctx context.Context, collectionName string, filter bson.M) ([]T, error) {
+func List[T any](repo *MongoRepository, ctx context.Context, collectionName string, filter bson.M, opts ...options.Lister[options.FindOptions]) ([]T, error) {
 	collection := repo.client.Database(repo.config.DatabaseName).Collection(collectionName)
-	cursor, err := collection.Find(ctx, filter)
+	cursor, err := collection.Find(ctx, filter, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -82,25 +90,92 @@ func GetByFilter[T any](repo *MongoRepository, ctx context.Context, collectionNa
 	return &result, nil
 }
 
+// GetOrCreate atomically returns the document matching filter, inserting document when
+// there is none. filter must be backed by a unique index: two concurrent upserts can
+// both miss and race on the insert, in which case the loser reads the winner's document.
 func GetOrCreate[T any](repo *MongoRepository, ctx context.Context, collectionName string, filter bson.M, document T) (*T, error) {
-	existing, err := GetByFilter[T](repo, ctx, collectionName, filter)
-	if err == nil {
-		return existing, nil
+	update := bson.M{"$setOnInsert": document}
+	upsert := options.FindOneAndUpdate().SetUpsert(true)
+
+	result, err := FindOneAndUpdate[T](repo, ctx, collectionName, filter, update, upsert)
+	if mongo.IsDuplicateKeyError(err) {
+		return GetByFilter[T](repo, ctx, collectionName, filter)
+	}
+	return result, err
+}
+
+func UpdateOne(repo *MongoRepository, ctx context.Context, collectionName string, filter bson.M, update bson.M) (int64, error) {
+	collection := repo.client.Database(repo.config.DatabaseName).Collection(collectionName)
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func UpdateMany(repo *MongoRepository, ctx context.Context, collectionName string, filter bson.M, update bson.M) (int64, error) {
+	collection := repo.client.Database(repo.config.DatabaseName).Collection(collectionName)
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func Count(repo *MongoRepository, ctx context.Context, collectionName string, filter bson.M) (int64, error) {
+	collection := repo.client.Database(repo.config.DatabaseName).Collection(collectionName)
+	return collection.CountDocuments(ctx, filter)
+}
+
+func DeleteOne(repo *MongoRepository, ctx context.Context, collectionName string, filter bson.M) (int64, error) {
+	collection := repo.client.Database(repo.config.DatabaseName).Collection(collectionName)
+	result, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func DeleteMany(repo *MongoRepository, ctx context.Context, collectionName string, filter bson.M) (int64, error) {
+	collection := repo.client.Database(repo.config.DatabaseName).Collection(collectionName)
+	result, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
 	}
-	if err != mongo.ErrNoDocuments && err != bson.ErrInvalidHex {
+	return result.DeletedCount, nil
+}
+
+func CreateIndexes(repo *MongoRepository, ctx context.Context, collectionName string, models []mongo.IndexModel) error {
+	collection := repo.client.Database(repo.config.DatabaseName).Collection(collectionName)
+	_, err := collection.Indexes().CreateMany(ctx, models)
+	return err
+}
+
+func Aggregate[T any](repo *MongoRepository, ctx context.Context, collectionName string, pipeline mongo.Pipeline) ([]T, error) {
+	collection := repo.client.Database(repo.config.DatabaseName).Collection(collectionName)
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
 		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	_, err = Add(repo, ctx, collectionName, document)
-	if err == nil {
-		return &document, nil
+	var results []T
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
 	}
-	if mongo.IsDuplicateKeyError(err) {
-		existing, err := GetByFilter[T](repo, ctx, collectionName, filter)
-		if err == nil {
-			return existing, nil
-		}
+	return results, nil
+}
+
+// FindOneAndUpdate applies update, a document or a pipeline, to the first document
+// matching filter and returns the document as it is after the update.
+func FindOneAndUpdate[T any](repo *MongoRepository, ctx context.Context, collectionName string, filter bson.M, update any, opts ...options.Lister[options.FindOneAndUpdateOptions]) (*T, error) {
+	collection := repo.client.Database(repo.config.DatabaseName).Collection(collectionName)
+	opts = append([]options.Lister[options.FindOneAndUpdateOptions]{options.FindOneAndUpdate().SetReturnDocument(options.After)}, opts...)
+
+	var result T
+	if err := collection.FindOneAndUpdate(ctx, filter, update, opts...).Decode(&result); err != nil {
 		return nil, err
 	}
-	return nil, err
+
+	return &result, nil
 }
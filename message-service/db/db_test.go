@@ -0,0 +1,80 @@
+package db_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/hoyci/ms-chat/message-service/db"
+	"github.com/hoyci/ms-chat/message-service/testutils/mongotest"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type keyedDocument struct {
+	ID    bson.ObjectID `bson:"_id"`
+	Key   string        `bson:"key"`
+	Value int           `bson:"value"`
+}
+
+func TestGetOrCreate(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) *db.MongoRepository {
+		repo := mongotest.NewRepository(t)
+		err := db.CreateIndexes(repo, ctx, "documents", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		})
+		assert.NoError(t, err)
+		return repo
+	}
+
+	t.Run("it should insert the document when none matches", func(t *testing.T) {
+		repo := setup(t)
+		document := keyedDocument{ID: bson.NewObjectID(), Key: "alice:bob", Value: 1}
+
+		result, err := db.GetOrCreate(repo, ctx, "documents", bson.M{"key": "alice:bob"}, document)
+
+		assert.NoError(t, err)
+		assert.Equal(t, document, *result)
+	})
+
+	t.Run("it should return the existing document untouched", func(t *testing.T) {
+		repo := setup(t)
+		existing := keyedDocument{ID: bson.NewObjectID(), Key: "alice:bob", Value: 1}
+		_, err := db.Add(repo, ctx, "documents", existing)
+		assert.NoError(t, err)
+
+		result, err := db.GetOrCreate(repo, ctx, "documents", bson.M{"key": "alice:bob"}, keyedDocument{ID: bson.NewObjectID(), Key: "alice:bob", Value: 2})
+
+		assert.NoError(t, err)
+		assert.Equal(t, existing, *result)
+	})
+
+	t.Run("it should create a single document for concurrent calls", func(t *testing.T) {
+		repo := setup(t)
+
+		var wg sync.WaitGroup
+		ids := make([]bson.ObjectID, 8)
+		for i := range ids {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result, err := db.GetOrCreate(repo, ctx, "documents", bson.M{"key": "alice:bob"}, keyedDocument{ID: bson.NewObjectID(), Key: "alice:bob", Value: i})
+				if assert.NoError(t, err) {
+					ids[i] = result.ID
+				}
+			}()
+		}
+		wg.Wait()
+
+		for _, id := range ids {
+			assert.Equal(t, ids[0], id)
+		}
+		count, err := db.Count(repo, ctx, "documents", bson.M{})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+}
@@ -0,0 +1,48 @@
+package mocks
+
+import (
+	"context"
+	"io"
+
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type MockAttachmentStore struct {
+	mock.Mock
+}
+
+func (m *MockAttachmentStore) Create(ctx context.Context, attachment types.Attachment) error {
+	args := m.Called(ctx, attachment)
+	return args.Error(0)
+}
+
+func (m *MockAttachmentStore) GetByID(ctx context.Context, attachmentID string) (*types.Attachment, error) {
+	args := m.Called(ctx, attachmentID)
+	return args.Get(0).(*types.Attachment), args.Error(1)
+}
+
+func (m *MockAttachmentStore) ListByIDs(ctx context.Context, ids []bson.ObjectID) ([]types.Attachment, error) {
+	args := m.Called(ctx, ids)
+	return args.Get(0).([]types.Attachment), args.Error(1)
+}
+
+type MockBlobStore struct {
+	mock.Mock
+}
+
+func (m *MockBlobStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	args := m.Called(ctx, key, body, size, contentType)
+	return args.Error(0)
+}
+
+func (m *MockBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	args := m.Called(ctx, key)
+	return args.Get(0).(io.ReadCloser), args.Error(1)
+}
+
+func (m *MockBlobStore) Delete(ctx context.Context, key string) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
@@ -0,0 +1,17 @@
+package mocks
+
+import (
+	"context"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockBroadcaster struct {
+	mock.Mock
+}
+
+func (m *MockBroadcaster) PublishBroadcast(ctx context.Context, broadcast coreTypes.BroadcastMessage) error {
+	args := m.Called(ctx, broadcast)
+	return args.Error(0)
+}
@@ -0,0 +1,15 @@
+package mocks
+
+import (
+	"github.com/hoyci/ms-chat/core/broker"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockBrokerMonitor struct {
+	mock.Mock
+}
+
+func (m *MockBrokerMonitor) Status() broker.Status {
+	args := m.Called()
+	return args.Get(0).(broker.Status)
+}
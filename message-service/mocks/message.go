@@ -0,0 +1,105 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type MockMessageStore struct {
+	mock.Mock
+}
+
+func (m *MockMessageStore) Create(ctx context.Context, newMessage map[string]any) (bson.ObjectID, error) {
+	args := m.Called(ctx, newMessage)
+	return args.Get(0).(bson.ObjectID), args.Error(1)
+}
+
+func (m *MockMessageStore) ListByRoom(ctx context.Context, roomID bson.ObjectID, page types.MessagePage) ([]types.Message, bool, error) {
+	args := m.Called(ctx, roomID, page)
+	return args.Get(0).([]types.Message), args.Bool(1), args.Error(2)
+}
+
+func (m *MockMessageStore) ListByIDs(ctx context.Context, ids []bson.ObjectID) ([]types.Message, error) {
+	args := m.Called(ctx, ids)
+	return args.Get(0).([]types.Message), args.Error(1)
+}
+
+func (m *MockMessageStore) ListReceivedBetween(ctx context.Context, roomID bson.ObjectID, userID string, after, upTo time.Time) ([]types.Message, error) {
+	args := m.Called(ctx, roomID, userID, after, upTo)
+	return args.Get(0).([]types.Message), args.Error(1)
+}
+
+func (m *MockMessageStore) AdvanceStatus(ctx context.Context, ids []bson.ObjectID, status coreTypes.Status) (int64, error) {
+	args := m.Called(ctx, ids, status)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockMessageStore) MarkDelivered(ctx context.Context, ids []bson.ObjectID, userID string) error {
+	args := m.Called(ctx, ids, userID)
+	return args.Error(0)
+}
+
+func (m *MockMessageStore) ListPending(ctx context.Context, roomIDs []bson.ObjectID, userID string, limit int) ([]types.Message, error) {
+	args := m.Called(ctx, roomIDs, userID, limit)
+	return args.Get(0).([]types.Message), args.Error(1)
+}
+
+func (m *MockMessageStore) ListAfter(ctx context.Context, roomIDs []bson.ObjectID, userID string, after types.Message, limit int) ([]types.Message, error) {
+	args := m.Called(ctx, roomIDs, userID, after, limit)
+	return args.Get(0).([]types.Message), args.Error(1)
+}
+
+func (m *MockMessageStore) GetByID(ctx context.Context, messageID string) (*types.Message, error) {
+	args := m.Called(ctx, messageID)
+	return args.Get(0).(*types.Message), args.Error(1)
+}
+
+func (m *MockMessageStore) GetByIdempotencyKey(ctx context.Context, senderID, key string) (*types.Message, error) {
+	args := m.Called(ctx, senderID, key)
+	return args.Get(0).(*types.Message), args.Error(1)
+}
+
+func (m *MockMessageStore) Edit(ctx context.Context, message types.Message, content string, at time.Time) (*types.Message, error) {
+	args := m.Called(ctx, message, content, at)
+	return args.Get(0).(*types.Message), args.Error(1)
+}
+
+func (m *MockMessageStore) DeleteForEveryone(ctx context.Context, messageID bson.ObjectID, at time.Time) (*types.Message, error) {
+	args := m.Called(ctx, messageID, at)
+	return args.Get(0).(*types.Message), args.Error(1)
+}
+
+func (m *MockMessageStore) HideFor(ctx context.Context, messageID bson.ObjectID, userID string) error {
+	args := m.Called(ctx, messageID, userID)
+	return args.Error(0)
+}
+
+func (m *MockMessageStore) ListRevisions(ctx context.Context, messageID bson.ObjectID) ([]types.MessageRevision, error) {
+	args := m.Called(ctx, messageID)
+	return args.Get(0).([]types.MessageRevision), args.Error(1)
+}
+
+func (m *MockMessageStore) ListThread(ctx context.Context, rootID bson.ObjectID, page types.MessagePage) ([]types.Message, bool, error) {
+	args := m.Called(ctx, rootID, page)
+	return args.Get(0).([]types.Message), args.Bool(1), args.Error(2)
+}
+
+func (m *MockMessageStore) RecountReplies(ctx context.Context, rootID bson.ObjectID) error {
+	args := m.Called(ctx, rootID)
+	return args.Error(0)
+}
+
+func (m *MockMessageStore) AddReaction(ctx context.Context, reaction types.Reaction) (bool, error) {
+	args := m.Called(ctx, reaction)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockMessageStore) RemoveReaction(ctx context.Context, messageID bson.ObjectID, userID, emoji string) (bool, error) {
+	args := m.Called(ctx, messageID, userID, emoji)
+	return args.Bool(0), args.Error(1)
+}
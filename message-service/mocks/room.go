@@ -0,0 +1,74 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type MockRoomStore struct {
+	mock.Mock
+}
+
+func (m *MockRoomStore) Create(ctx context.Context, newRoom types.Room) (bson.ObjectID, error) {
+	args := m.Called(ctx, newRoom)
+	return args.Get(0).(bson.ObjectID), args.Error(1)
+}
+
+func (m *MockRoomStore) GetByID(ctx context.Context, roomID string) (*types.Room, error) {
+	args := m.Called(ctx, roomID)
+	return args.Get(0).(*types.Room), args.Error(1)
+}
+
+func (m *MockRoomStore) GetOrCreate(ctx context.Context, users []string) (*types.Room, error) {
+	args := m.Called(ctx, users)
+	return args.Get(0).(*types.Room), args.Error(1)
+}
+
+func (m *MockRoomStore) ListByUser(ctx context.Context, userID string) ([]types.RoomSummary, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]types.RoomSummary), args.Error(1)
+}
+
+func (m *MockRoomStore) ListIDsByUser(ctx context.Context, userID string) ([]bson.ObjectID, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]bson.ObjectID), args.Error(1)
+}
+
+func (m *MockRoomStore) UpdateDetails(ctx context.Context, roomID bson.ObjectID, payload types.UpdateRoomPayload) (*types.Room, error) {
+	args := m.Called(ctx, roomID, payload)
+	return args.Get(0).(*types.Room), args.Error(1)
+}
+
+func (m *MockRoomStore) AddMembers(ctx context.Context, roomID bson.ObjectID, members []types.RoomMember) (*types.Room, error) {
+	args := m.Called(ctx, roomID, members)
+	return args.Get(0).(*types.Room), args.Error(1)
+}
+
+func (m *MockRoomStore) RemoveMember(ctx context.Context, roomID bson.ObjectID, userID string) (*types.Room, error) {
+	args := m.Called(ctx, roomID, userID)
+	return args.Get(0).(*types.Room), args.Error(1)
+}
+
+func (m *MockRoomStore) SetMemberRole(ctx context.Context, roomID bson.ObjectID, userID string, role types.MemberRole) (*types.Room, error) {
+	args := m.Called(ctx, roomID, userID, role)
+	return args.Get(0).(*types.Room), args.Error(1)
+}
+
+func (m *MockRoomStore) TransferOwnershipAndLeave(ctx context.Context, roomID bson.ObjectID, ownerID, successorID string) (*types.Room, error) {
+	args := m.Called(ctx, roomID, ownerID, successorID)
+	return args.Get(0).(*types.Room), args.Error(1)
+}
+
+func (m *MockRoomStore) Delete(ctx context.Context, roomID bson.ObjectID) error {
+	args := m.Called(ctx, roomID)
+	return args.Error(0)
+}
+
+func (m *MockRoomStore) MarkRead(ctx context.Context, roomID bson.ObjectID, userID string, at time.Time) error {
+	args := m.Called(ctx, roomID, userID, at)
+	return args.Error(0)
+}
@@ -0,0 +1,17 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockMessageSearcher struct {
+	mock.Mock
+}
+
+func (m *MockMessageSearcher) Search(ctx context.Context, query types.SearchQuery) ([]types.SearchHit, bool, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).([]types.SearchHit), args.Bool(1), args.Error(2)
+}
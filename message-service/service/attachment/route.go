@@ -0,0 +1,463 @@
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	coreMiddlewares "github.com/hoyci/ms-chat/core/middlewares"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	coreUtils "github.com/hoyci/ms-chat/core/utils"
+	"github.com/hoyci/ms-chat/message-service/config"
+	"github.com/hoyci/ms-chat/message-service/keys"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	// multipartMemory is the part of an upload kept in memory; the rest is spooled to a
+	// temporary file.
+	multipartMemory = 1 << 20
+	// multipartOverhead is the room left on top of the maximum file size for the
+	// boundaries and headers of the multipart body.
+	multipartOverhead = 64 << 10
+	// sniffLength is the number of bytes http.DetectContentType looks at.
+	sniffLength = 512
+)
+
+type AttachmentHandler struct {
+	attachmentStore types.AttachmentStore
+	roomStore       types.RoomStore
+	blobStore       types.BlobStore
+	maxSize         int64
+	allowedTypes    []string
+	thumbnailSize   int
+}
+
+func NewAttachmentHandler(attachmentStore types.AttachmentStore, roomStore types.RoomStore, blobStore types.BlobStore) *AttachmentHandler {
+	return &AttachmentHandler{
+		attachmentStore: attachmentStore,
+		roomStore:       roomStore,
+		blobStore:       blobStore,
+		maxSize:         config.Envs.MaxAttachmentSize,
+		allowedTypes:    config.Envs.AttachmentTypes,
+		thumbnailSize:   config.Envs.ThumbnailSize,
+	}
+}
+
+// RegisterRoutes adds the endpoints of the handler to the versioned API router.
+func (h *AttachmentHandler) RegisterRoutes(router *mux.Router) {
+	router.Handle(
+		"/rooms/{room_id}/attachments", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleUploadAttachment),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodPost)
+	router.Handle(
+		"/attachments/{attachment_id}", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleDownloadAttachment),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodGet)
+	router.Handle(
+		"/attachments/{attachment_id}/thumbnail", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleDownloadThumbnail),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodGet)
+}
+
+// HandleUploadAttachment
+// @Summary Upload a file to attach to messages of a room
+// @Description The type of the file is detected from its content and must be one of the allowed types. Images also get a thumbnail.
+// @Tags Attachments
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param room_id path string true "Room ID"
+// @Param file formData file true "File to upload"
+// @Success 201 {object} types.AttachmentResponse "Attachment uploaded"
+// @Failure 400 {object} coreTypes.BadRequestResponse "Invalid room ID or missing file"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not a member of the room"
+// @Failure 404 {object} coreTypes.NotFoundResponse "Room not found"
+// @Failure 413 {object} coreTypes.PayloadTooLargeResponse "File too large"
+// @Failure 415 {object} coreTypes.UnsupportedMediaTypeResponse "File type not allowed"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /rooms/{room_id}/attachments [post]
+func (h *AttachmentHandler) HandleUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			"HandleUploadAttachment", coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	room, ok := h.loadRoom(w, r, mux.Vars(r)["room_id"], userID, "HandleUploadAttachment")
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxSize+multipartOverhead)
+	if err := r.ParseMultipartForm(multipartMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.writeTooLarge(w, err)
+			return
+		}
+
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, err, "HandleUploadAttachment",
+			coreTypes.BadRequestResponse{Error: "Body is not a valid multipart form"},
+		)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, err, "HandleUploadAttachment",
+			coreTypes.BadRequestResponse{Error: "Field 'file' is required"},
+		)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > h.maxSize {
+		h.writeTooLarge(w, fmt.Errorf("file of %d bytes exceeds %d", header.Size, h.maxSize))
+		return
+	}
+	if header.Size == 0 {
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, fmt.Errorf("empty file"), "HandleUploadAttachment",
+			coreTypes.BadRequestResponse{Error: "File is empty"},
+		)
+		return
+	}
+
+	contentType, err := sniff(file)
+	if err != nil {
+		writeStoreError(w, err, "HandleUploadAttachment")
+		return
+	}
+	if !slices.Contains(h.allowedTypes, contentType) {
+		coreUtils.WriteError(
+			w, http.StatusUnsupportedMediaType, fmt.Errorf("type %s not allowed", contentType),
+			"HandleUploadAttachment",
+			coreTypes.UnsupportedMediaTypeResponse{Error: fmt.Sprintf("Files of type %s are not allowed", contentType)},
+		)
+		return
+	}
+
+	id := bson.NewObjectID()
+	attachment := types.Attachment{
+		ID:          id,
+		RoomID:      room.ID,
+		UploaderID:  userID,
+		Filename:    cleanFilename(header.Filename),
+		ContentType: contentType,
+		Size:        header.Size,
+		StorageKey:  blobKey(room.ID, id, "original"),
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if err := h.store(r.Context(), &attachment, file); err != nil {
+		writeStoreError(w, err, "HandleUploadAttachment")
+		return
+	}
+
+	_ = coreUtils.WriteJSON(w, http.StatusCreated, types.AttachmentResponse{Attachment: &attachment})
+}
+
+// HandleDownloadAttachment
+// @Summary Download an attachment
+// @Tags Attachments
+// @Produce octet-stream
+// @Security BearerAuth
+// @Param attachment_id path string true "Attachment ID"
+// @Success 200 {file} file "Content of the attachment"
+// @Failure 400 {object} coreTypes.BadRequestResponse "Invalid attachment ID"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not a member of the room"
+// @Failure 404 {object} coreTypes.NotFoundResponse "Attachment not found"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /attachments/{attachment_id} [get]
+func (h *AttachmentHandler) HandleDownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, ok := h.loadAttachment(w, r, "HandleDownloadAttachment")
+	if !ok {
+		return
+	}
+
+	h.serve(w, r, attachment.StorageKey, attachment.ContentType, "attachment", attachment.Filename, "HandleDownloadAttachment")
+}
+
+// HandleDownloadThumbnail
+// @Summary Download the thumbnail of an image attachment
+// @Tags Attachments
+// @Produce image/jpeg,image/png
+// @Security BearerAuth
+// @Param attachment_id path string true "Attachment ID"
+// @Success 200 {file} file "Thumbnail of the attachment"
+// @Failure 400 {object} coreTypes.BadRequestResponse "Invalid attachment ID"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not a member of the room"
+// @Failure 404 {object} coreTypes.NotFoundResponse "Attachment not found or without thumbnail"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /attachments/{attachment_id}/thumbnail [get]
+func (h *AttachmentHandler) HandleDownloadThumbnail(w http.ResponseWriter, r *http.Request) {
+	attachment, ok := h.loadAttachment(w, r, "HandleDownloadThumbnail")
+	if !ok {
+		return
+	}
+
+	if !attachment.HasThumbnail() {
+		coreUtils.WriteError(
+			w, http.StatusNotFound, fmt.Errorf("attachment %s has no thumbnail", attachment.ID.Hex()),
+			"HandleDownloadThumbnail", coreTypes.NotFoundResponse{Error: "Attachment has no thumbnail"},
+		)
+		return
+	}
+
+	filename := strings.TrimSuffix(attachment.Filename, filepath.Ext(attachment.Filename)) + "-thumbnail"
+	h.serve(w, r, attachment.ThumbnailKey, thumbnailType(attachment.ContentType), "inline", filename, "HandleDownloadThumbnail")
+}
+
+// store saves the content of an upload, and the thumbnail of images, before recording
+// the attachment. Blobs already saved are removed if a later step fails.
+func (h *AttachmentHandler) store(ctx context.Context, attachment *types.Attachment, file multipart.File) error {
+	if err := h.blobStore.Put(ctx, attachment.StorageKey, file, attachment.Size, attachment.ContentType); err != nil {
+		return err
+	}
+	stored := []string{attachment.StorageKey}
+
+	if thumbnailable[attachment.ContentType] {
+		if err := h.storeThumbnail(ctx, attachment, file); err != nil {
+			h.deleteBlobs(stored)
+			return err
+		}
+		if attachment.HasThumbnail() {
+			stored = append(stored, attachment.ThumbnailKey)
+		}
+	}
+
+	if err := h.attachmentStore.Create(ctx, *attachment); err != nil {
+		h.deleteBlobs(stored)
+		return err
+	}
+
+	return nil
+}
+
+// storeThumbnail saves the thumbnail of an image along with its dimensions. Images that
+// cannot be decoded are kept as plain files.
+func (h *AttachmentHandler) storeThumbnail(ctx context.Context, attachment *types.Attachment, file multipart.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	thumb, err := makeThumbnail(file, attachment.ContentType, h.thumbnailSize)
+	if err != nil {
+		log.Printf("Storing attachment %s without thumbnail: %v", attachment.ID.Hex(), err)
+		return nil
+	}
+
+	key := blobKey(attachment.RoomID, attachment.ID, "thumbnail")
+	if err := h.blobStore.Put(ctx, key, bytes.NewReader(thumb.data), int64(len(thumb.data)), thumb.contentType); err != nil {
+		return err
+	}
+
+	attachment.ThumbnailKey = key
+	attachment.Width = thumb.width
+	attachment.Height = thumb.height
+	return nil
+}
+
+func (h *AttachmentHandler) deleteBlobs(keys []string) {
+	for _, key := range keys {
+		if err := h.blobStore.Delete(context.Background(), key); err != nil {
+			log.Printf("Failed to delete blob %s: %v", key, err)
+		}
+	}
+}
+
+// loadAttachment fetches the attachment named in the request if the user is a member of
+// its room, answering with the matching error otherwise.
+func (h *AttachmentHandler) loadAttachment(w http.ResponseWriter, r *http.Request, handlerName string) (*types.Attachment, bool) {
+	userID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			handlerName, coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return nil, false
+	}
+
+	attachment, err := h.attachmentStore.GetByID(r.Context(), mux.Vars(r)["attachment_id"])
+	if err != nil {
+		if errors.Is(err, bson.ErrInvalidHex) {
+			coreUtils.WriteError(
+				w, http.StatusBadRequest, err, handlerName,
+				coreTypes.BadRequestResponse{Error: "Invalid attachment_id"},
+			)
+			return nil, false
+		}
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			coreUtils.WriteError(
+				w, http.StatusNotFound, err, handlerName,
+				coreTypes.NotFoundResponse{Error: "Attachment not found"},
+			)
+			return nil, false
+		}
+
+		writeStoreError(w, err, handlerName)
+		return nil, false
+	}
+
+	if _, ok := h.loadRoom(w, r, attachment.RoomID.Hex(), userID, handlerName); !ok {
+		return nil, false
+	}
+
+	return attachment, true
+}
+
+// loadRoom fetches a room if userID is one of its members, answering with the matching
+// error otherwise.
+func (h *AttachmentHandler) loadRoom(w http.ResponseWriter, r *http.Request, roomID, userID, handlerName string) (*types.Room, bool) {
+	room, err := h.roomStore.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, bson.ErrInvalidHex) {
+			coreUtils.WriteError(
+				w, http.StatusBadRequest, err, handlerName,
+				coreTypes.BadRequestResponse{Error: "Invalid room_id"},
+			)
+			return nil, false
+		}
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			coreUtils.WriteError(
+				w, http.StatusNotFound, err, handlerName,
+				coreTypes.NotFoundResponse{Error: "Room not found"},
+			)
+			return nil, false
+		}
+
+		writeStoreError(w, err, handlerName)
+		return nil, false
+	}
+
+	if !room.IsMember(userID) {
+		coreUtils.WriteError(
+			w, http.StatusForbidden, fmt.Errorf("user %s is not a member of room %s", userID, roomID),
+			handlerName, coreTypes.ForbiddenResponse{Error: "You are not a member of this room"},
+		)
+		return nil, false
+	}
+
+	return room, true
+}
+
+// serve streams a blob to the client. Blobs are served with their recorded type and
+// never sniffed, so an uploaded file cannot be turned into a page of this origin.
+func (h *AttachmentHandler) serve(w http.ResponseWriter, r *http.Request, key, contentType, disposition, filename, handlerName string) {
+	body, err := h.blobStore.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, types.ErrBlobNotFound) {
+			coreUtils.WriteError(
+				w, http.StatusNotFound, err, handlerName,
+				coreTypes.NotFoundResponse{Error: "Attachment not found"},
+			)
+			return
+		}
+
+		writeStoreError(w, err, handlerName)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("Failed to send blob %s: %v", key, err)
+	}
+}
+
+func (h *AttachmentHandler) writeTooLarge(w http.ResponseWriter, err error) {
+	coreUtils.WriteError(
+		w, http.StatusRequestEntityTooLarge, err, "HandleUploadAttachment",
+		coreTypes.PayloadTooLargeResponse{Error: "Files must not exceed " + strconv.FormatInt(h.maxSize, 10) + " bytes"},
+	)
+}
+
+// blobKey names the blob holding the original file or the thumbnail of an attachment.
+func blobKey(roomID, attachmentID bson.ObjectID, variant string) string {
+	return fmt.Sprintf("attachments/%s/%s/%s", roomID.Hex(), attachmentID.Hex(), variant)
+}
+
+// sniff detects the type of an upload from its first bytes, ignoring what the client
+// claims, and rewinds it.
+func sniff(file multipart.File) (string, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "application/octet-stream", nil
+	}
+	return mediaType, nil
+}
+
+// cleanFilename keeps the base name of the file the client uploaded.
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	return name
+}
+
+func writeStoreError(w http.ResponseWriter, err error, handlerName string) {
+	if errors.Is(err, context.Canceled) {
+		coreUtils.WriteError(
+			w, http.StatusServiceUnavailable, err, handlerName,
+			coreTypes.ContextCanceledResponse{Error: "Request canceled"},
+		)
+		return
+	}
+
+	coreUtils.WriteError(
+		w, http.StatusInternalServerError, err, handlerName,
+		coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+	)
+}
@@ -0,0 +1,251 @@
+package attachment_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hoyci/ms-chat/message-service/config"
+	"github.com/hoyci/ms-chat/message-service/mocks"
+	"github.com/hoyci/ms-chat/message-service/service/attachment"
+	"github.com/hoyci/ms-chat/message-service/service/blob"
+	"github.com/hoyci/ms-chat/message-service/testutils"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestMain(m *testing.M) {
+	config.Envs.MaxAttachmentSize = 64 << 10
+	config.Envs.ThumbnailSize = 16
+	m.Run()
+}
+
+func setupTestServer(t *testing.T) (*mocks.MockAttachmentStore, *mocks.MockRoomStore, *blob.FilesystemStore, *mux.Router) {
+	mockAttachmentStore := new(mocks.MockAttachmentStore)
+	mockRoomStore := new(mocks.MockRoomStore)
+	blobStore, err := blob.NewFilesystemStore(t.TempDir())
+	assert.NoError(t, err)
+
+	attachmentHandler := attachment.NewAttachmentHandler(mockAttachmentStore, mockRoomStore, blobStore)
+	router := testutils.NewRouter(attachmentHandler)
+	return mockAttachmentStore, mockRoomStore, blobStore, router
+}
+
+func newUploadRequest(t *testing.T, url, userID, filename string, content []byte) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	assert.NoError(t, err)
+	_, _ = part.Write(content)
+	assert.NoError(t, writer.Close())
+
+	req := testutils.NewAuthenticatedRequestWithBody(http.MethodPost, url, userID, body.String())
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func pngImage(t *testing.T, width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func readBlob(t *testing.T, store *blob.FilesystemStore, key string) []byte {
+	body, err := store.Get(context.Background(), key)
+	assert.NoError(t, err)
+	defer body.Close()
+	content, _ := io.ReadAll(body)
+	return content
+}
+
+func TestHandleUploadAttachment(t *testing.T) {
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	otherUserID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+	roomID := bson.NewObjectID()
+	room := &types.Room{ID: roomID, Users: []string{userID, otherUserID}}
+	url := "/api/v1/rooms/" + roomID.Hex() + "/attachments"
+
+	t.Run("it should store an image along with its thumbnail", func(t *testing.T) {
+		mockAttachmentStore, mockRoomStore, blobStore, router := setupTestServer(t)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockAttachmentStore.On("Create", mock.Anything, mock.MatchedBy(func(a types.Attachment) bool {
+			return a.RoomID == roomID && a.UploaderID == userID && a.HasThumbnail()
+		})).Return(nil)
+
+		content := pngImage(t, 64, 32)
+		req := newUploadRequest(t, url, userID, "../holidays.png", content)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var response types.AttachmentResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "holidays.png", response.Attachment.Filename)
+		assert.Equal(t, "image/png", response.Attachment.ContentType)
+		assert.Equal(t, int64(len(content)), response.Attachment.Size)
+		assert.Equal(t, 64, response.Attachment.Width)
+		assert.Equal(t, 32, response.Attachment.Height)
+
+		stored := mockAttachmentStore.Calls[0].Arguments.Get(1).(types.Attachment)
+		assert.Equal(t, content, readBlob(t, blobStore, stored.StorageKey))
+
+		thumb, err := png.Decode(bytes.NewReader(readBlob(t, blobStore, stored.ThumbnailKey)))
+		assert.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 16, 8), thumb.Bounds())
+	})
+
+	t.Run("it should store files that are not images without thumbnail", func(t *testing.T) {
+		mockAttachmentStore, mockRoomStore, _, router := setupTestServer(t)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockAttachmentStore.On("Create", mock.Anything, mock.MatchedBy(func(a types.Attachment) bool {
+			return a.ContentType == "text/plain" && !a.HasThumbnail()
+		})).Return(nil)
+
+		req := newUploadRequest(t, url, userID, "notes.txt", []byte("meeting at noon"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		mockAttachmentStore.AssertExpectations(t)
+	})
+
+	t.Run("it should detect the type from the content rather than the filename", func(t *testing.T) {
+		_, mockRoomStore, _, router := setupTestServer(t)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := newUploadRequest(t, url, userID, "photo.png", []byte("\x7fELF\x02\x01\x01\x00\x00\x00"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+		assert.JSONEq(t, `{"error":"Files of type application/octet-stream are not allowed"}`, w.Body.String())
+	})
+
+	t.Run("it should reject files larger than the limit", func(t *testing.T) {
+		_, mockRoomStore, _, router := setupTestServer(t)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := newUploadRequest(t, url, userID, "big.txt", []byte(strings.Repeat("a", 64<<10+1)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("it should reject users who are not members of the room", func(t *testing.T) {
+		_, mockRoomStore, _, router := setupTestServer(t)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := newUploadRequest(t, url, "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d", "notes.txt", []byte("hi"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("it should remove the stored blob when the attachment cannot be recorded", func(t *testing.T) {
+		mockAttachmentStore, mockRoomStore, blobStore, router := setupTestServer(t)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockAttachmentStore.On("Create", mock.Anything, mock.Anything).Return(assert.AnError)
+
+		req := newUploadRequest(t, url, userID, "notes.txt", []byte("meeting at noon"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		stored := mockAttachmentStore.Calls[0].Arguments.Get(1).(types.Attachment)
+		_, err := blobStore.Get(context.Background(), stored.StorageKey)
+		assert.ErrorIs(t, err, types.ErrBlobNotFound)
+	})
+}
+
+func TestHandleDownloadAttachment(t *testing.T) {
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	roomID := bson.NewObjectID()
+	room := &types.Room{ID: roomID, Users: []string{userID}}
+	attachmentID := bson.NewObjectID()
+	stored := &types.Attachment{
+		ID:          attachmentID,
+		RoomID:      roomID,
+		Filename:    "notes.txt",
+		ContentType: "text/plain",
+		Size:        15,
+		StorageKey:  "attachments/" + roomID.Hex() + "/" + attachmentID.Hex(),
+	}
+
+	t.Run("it should serve the content to members of the room", func(t *testing.T) {
+		mockAttachmentStore, mockRoomStore, blobStore, router := setupTestServer(t)
+		mockAttachmentStore.On("GetByID", mock.Anything, attachmentID.Hex()).Return(stored, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		assert.NoError(t, blobStore.Put(context.Background(), stored.StorageKey, strings.NewReader("meeting at noon"), 15, "text/plain"))
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/attachments/"+attachmentID.Hex(), userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "meeting at noon", w.Body.String())
+		assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+		assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+		assert.Equal(t, `attachment; filename=notes.txt`, w.Header().Get("Content-Disposition"))
+	})
+
+	t.Run("it should hide attachments from users outside the room", func(t *testing.T) {
+		mockAttachmentStore, mockRoomStore, _, router := setupTestServer(t)
+		mockAttachmentStore.On("GetByID", mock.Anything, attachmentID.Hex()).Return(stored, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := testutils.NewAuthenticatedRequest(
+			http.MethodGet, "/api/v1/attachments/"+attachmentID.Hex(), "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d",
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("it should return not found for unknown attachments", func(t *testing.T) {
+		mockAttachmentStore, _, _, router := setupTestServer(t)
+		mockAttachmentStore.On("GetByID", mock.Anything, attachmentID.Hex()).Return((*types.Attachment)(nil), mongo.ErrNoDocuments)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/attachments/"+attachmentID.Hex(), userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"error":"Attachment not found"}`, w.Body.String())
+	})
+
+	t.Run("it should return not found for the thumbnail of a file that has none", func(t *testing.T) {
+		mockAttachmentStore, mockRoomStore, _, router := setupTestServer(t)
+		mockAttachmentStore.On("GetByID", mock.Anything, attachmentID.Hex()).Return(stored, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/attachments/"+attachmentID.Hex()+"/thumbnail", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"error":"Attachment has no thumbnail"}`, w.Body.String())
+	})
+}
@@ -0,0 +1,44 @@
+package attachment
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hoyci/ms-chat/message-service/db"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var (
+	instance *AttachmentStore
+	once     sync.Once
+)
+
+type AttachmentStore struct {
+	dbRepo *db.MongoRepository
+}
+
+func GetAttachmentStore(dbRepo *db.MongoRepository) *AttachmentStore {
+	once.Do(func() {
+		instance = &AttachmentStore{dbRepo: dbRepo}
+	})
+	return instance
+}
+
+func (s *AttachmentStore) Create(ctx context.Context, attachment types.Attachment) error {
+	_, err := db.Add(s.dbRepo, ctx, "attachments", attachment)
+	return err
+}
+
+func (s *AttachmentStore) GetByID(ctx context.Context, attachmentID string) (*types.Attachment, error) {
+	objectID, err := bson.ObjectIDFromHex(attachmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.GetByFilter[types.Attachment](s.dbRepo, ctx, "attachments", bson.M{"_id": objectID, "deleted_at": nil})
+}
+
+func (s *AttachmentStore) ListByIDs(ctx context.Context, ids []bson.ObjectID) ([]types.Attachment, error) {
+	return db.List[types.Attachment](s.dbRepo, ctx, "attachments", bson.M{"_id": bson.M{"$in": ids}, "deleted_at": nil})
+}
@@ -0,0 +1,30 @@
+package attachment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hoyci/ms-chat/message-service/db"
+	"github.com/hoyci/ms-chat/message-service/testutils/mongotest"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestGetByID(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it should not find an attachment withdrawn with its message", func(t *testing.T) {
+		store := &AttachmentStore{dbRepo: mongotest.NewRepository(t)}
+		deletedAt := time.Now()
+		attachment := types.Attachment{ID: bson.NewObjectID(), Filename: "plan.pdf", StorageKey: "plan", DeletedAt: &deletedAt}
+		_, err := db.Add(store.dbRepo, ctx, "attachments", attachment)
+		assert.NoError(t, err)
+
+		_, err = store.GetByID(ctx, attachment.ID.Hex())
+
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	})
+}
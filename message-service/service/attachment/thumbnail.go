@@ -0,0 +1,131 @@
+package attachment
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// thumbnailable lists the image types thumbnails are made of; other images are stored
+// without one.
+var thumbnailable = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// thumbnail is a downscaled copy of an uploaded image along with the size of the
+// original.
+type thumbnail struct {
+	data        []byte
+	contentType string
+	width       int
+	height      int
+}
+
+// maxThumbnailPixels bounds the images thumbnails are made of, so a small file that
+// decodes to a huge image cannot exhaust memory.
+const maxThumbnailPixels = 50_000_000
+
+var errImageTooLarge = errors.New("image too large to thumbnail")
+
+// makeThumbnail decodes an image and scales it down so that its longest side is at most
+// maxSide pixels. JPEG images keep their format and the others become PNG, which keeps
+// their transparency.
+func makeThumbnail(r io.ReadSeeker, contentType string, maxSide int) (*thumbnail, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return nil, errImageTooLarge
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	source, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := source.Bounds()
+	scaled := scaleDown(source, maxSide)
+
+	var buf bytes.Buffer
+	contentType = thumbnailType(contentType)
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 80})
+	} else {
+		err = png.Encode(&buf, scaled)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &thumbnail{
+		data:        buf.Bytes(),
+		contentType: contentType,
+		width:       bounds.Dx(),
+		height:      bounds.Dy(),
+	}, nil
+}
+
+// thumbnailType is the type of the thumbnail of an image of type contentType.
+func thumbnailType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return contentType
+	}
+	return "image/png"
+}
+
+// scaleDown resizes src to fit in a maxSide square by averaging the source pixels each
+// thumbnail pixel covers. Images already small enough are only copied.
+func scaleDown(src image.Image, maxSide int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width <= maxSide && height <= maxSide {
+		dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+		return dst
+	}
+
+	dstWidth, dstHeight := maxSide, height*maxSide/width
+	if height > width {
+		dstWidth, dstHeight = width*maxSide/height, maxSide
+	}
+	dstWidth, dstHeight = max(dstWidth, 1), max(dstHeight, 1)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0, y1 := y*height/dstHeight, max((y+1)*height/dstHeight, y*height/dstHeight+1)
+		for x := 0; x < dstWidth; x++ {
+			x0, x1 := x*width/dstWidth, max((x+1)*width/dstWidth, x*width/dstWidth+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pixel := color.NRGBA64Model.Convert(src.At(bounds.Min.X+sx, bounds.Min.Y+sy)).(color.NRGBA64)
+					r += uint64(pixel.R)
+					g += uint64(pixel.G)
+					b += uint64(pixel.B)
+					a += uint64(pixel.A)
+					n++
+				}
+			}
+
+			dst.Set(x, y, color.NRGBA64{
+				R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
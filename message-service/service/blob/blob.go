@@ -0,0 +1,21 @@
+package blob
+
+import (
+	"fmt"
+
+	"github.com/hoyci/ms-chat/message-service/config"
+	"github.com/hoyci/ms-chat/message-service/types"
+)
+
+// New returns the BlobStore selected by BLOB_DRIVER: filesystem, the default, keeps blobs
+// under BLOB_PATH; s3 keeps them in a bucket of any S3-compatible service.
+func New(cfg config.Config) (types.BlobStore, error) {
+	switch cfg.BlobDriver {
+	case "filesystem":
+		return NewFilesystemStore(cfg.BlobPath)
+	case "s3":
+		return NewS3Store(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey)
+	default:
+		return nil, fmt.Errorf("unknown blob driver %q", cfg.BlobDriver)
+	}
+}
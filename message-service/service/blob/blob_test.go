@@ -0,0 +1,148 @@
+package blob
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilesystemStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it should read back what was put and forget deleted blobs", func(t *testing.T) {
+		store, err := NewFilesystemStore(t.TempDir())
+		assert.NoError(t, err)
+
+		assert.NoError(t, store.Put(ctx, "attachments/room/file", strings.NewReader("hello"), 5, "text/plain"))
+
+		body, err := store.Get(ctx, "attachments/room/file")
+		assert.NoError(t, err)
+		content, _ := io.ReadAll(body)
+		body.Close()
+		assert.Equal(t, "hello", string(content))
+
+		assert.NoError(t, store.Delete(ctx, "attachments/room/file"))
+		_, err = store.Get(ctx, "attachments/room/file")
+		assert.ErrorIs(t, err, types.ErrBlobNotFound)
+		assert.NoError(t, store.Delete(ctx, "attachments/room/file"))
+	})
+
+	t.Run("it should not keep a blob shorter than announced", func(t *testing.T) {
+		store, err := NewFilesystemStore(t.TempDir())
+		assert.NoError(t, err)
+
+		assert.Error(t, store.Put(ctx, "short", strings.NewReader("abc"), 5, "text/plain"))
+		_, err = store.Get(ctx, "short")
+		assert.ErrorIs(t, err, types.ErrBlobNotFound)
+	})
+
+	t.Run("it should refuse keys escaping its root", func(t *testing.T) {
+		store, err := NewFilesystemStore(t.TempDir())
+		assert.NoError(t, err)
+
+		for _, key := range []string{"../outside", "/etc/passwd", "a/../../b", ""} {
+			assert.Error(t, store.Put(ctx, key, strings.NewReader("x"), 1, "text/plain"), key)
+		}
+	})
+}
+
+// fakeS3 is a bucket of an S3-compatible service that only accepts signed requests.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]string
+	types   map[string]string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=access/20240102/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=") ||
+		r.Header.Get("X-Amz-Date") != "20240102T030405Z" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = string(body)
+		f.types[r.URL.Path] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		object, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, object)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{objects: map[string]string{}, types: map[string]string{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store, err := NewS3Store(server.URL, "us-east-1", "chat", "access", "secret")
+	assert.NoError(t, err)
+	store.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	t.Run("it should store signed objects under the bucket path", func(t *testing.T) {
+		assert.NoError(t, store.Put(ctx, "attachments/room/file", strings.NewReader("hello"), 5, "text/plain"))
+		assert.Equal(t, "hello", fake.objects["/chat/attachments/room/file"])
+		assert.Equal(t, "text/plain", fake.types["/chat/attachments/room/file"])
+
+		body, err := store.Get(ctx, "attachments/room/file")
+		assert.NoError(t, err)
+		content, _ := io.ReadAll(body)
+		body.Close()
+		assert.Equal(t, "hello", string(content))
+	})
+
+	t.Run("it should report missing objects as not found", func(t *testing.T) {
+		assert.NoError(t, store.Delete(ctx, "attachments/room/file"))
+
+		_, err := store.Get(ctx, "attachments/room/file")
+		assert.ErrorIs(t, err, types.ErrBlobNotFound)
+	})
+
+	t.Run("it should surface errors returned by the service", func(t *testing.T) {
+		unsigned, err := NewS3Store(server.URL, "eu-west-1", "chat", "access", "secret")
+		assert.NoError(t, err)
+
+		err = unsigned.Put(ctx, "file", strings.NewReader("x"), 1, "text/plain")
+		assert.ErrorContains(t, err, "403")
+	})
+}
+
+func TestSign(t *testing.T) {
+	t.Run("it should derive the signing key AWS documents", func(t *testing.T) {
+		key := hmacSHA256([]byte("AWS4wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"), "20150830")
+		key = hmacSHA256(key, "us-east-1")
+		key = hmacSHA256(key, "iam")
+		key = hmacSHA256(key, "aws4_request")
+
+		assert.Equal(t,
+			"c4afb1cc5771d871763a393e44b703571b55cc28424d1a5e86da6ed3c154a4b9",
+			hex.EncodeToString(key),
+		)
+	})
+
+	t.Run("it should escape keys segment by segment", func(t *testing.T) {
+		assert.Equal(t, "chat/a%20b/c%2Bd~e", escapePath("chat/a b/c+d~e"))
+	})
+}
@@ -2,27 +2,53 @@ package healthcheck
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/hoyci/ms-chat/core/broker"
 	coreUtils "github.com/hoyci/ms-chat/core/utils"
 	"github.com/hoyci/ms-chat/message-service/config"
 	"github.com/hoyci/ms-chat/message-service/types"
 )
 
 type HealthCheckHandler struct {
-	cfg config.Config
+	cfg    config.Config
+	broker types.BrokerMonitor
 }
 
-func NewHealthCheckHandler(cfg config.Config) *HealthCheckHandler {
+func NewHealthCheckHandler(cfg config.Config, broker types.BrokerMonitor) *HealthCheckHandler {
 	return &HealthCheckHandler{
-		cfg: cfg,
+		cfg:    cfg,
+		broker: broker,
 	}
 }
 
+// RegisterRoutes adds the endpoints of the handler to the versioned API router.
+func (h *HealthCheckHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/healthcheck", h.HandleHealthCheck).Methods(http.MethodGet)
+}
+
+// HandleHealthCheck reports the service as unavailable while it is not connected to
+// RabbitMQ: it can neither persist the messages sent nor deliver broadcasts then.
 func (h *HealthCheckHandler) HandleHealthCheck(w http.ResponseWriter, r *http.Request) {
-	coreUtils.WriteJSON(w, http.StatusOK, &types.HealthcheckResponse{
-		Status: "available",
-		SystemInfo: map[string]string{
-			"environment": h.cfg.Environment,
-		},
+	brokerStatus := h.broker.Status()
+
+	status, code := "available", http.StatusOK
+	if brokerStatus.State != broker.StateConnected {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	systemInfo := map[string]string{
+		"environment":    h.cfg.Environment,
+		"rabbitmq":       string(brokerStatus.State),
+		"rabbitmq_since": brokerStatus.Since.UTC().Format(time.RFC3339),
+	}
+	if brokerStatus.LastError != "" {
+		systemInfo["rabbitmq_last_error"] = brokerStatus.LastError
+	}
+
+	coreUtils.WriteJSON(w, code, &types.HealthcheckResponse{
+		Status:     status,
+		SystemInfo: systemInfo,
 	})
 }
@@ -0,0 +1,77 @@
+package healthcheck_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/broker"
+	"github.com/hoyci/ms-chat/message-service/config"
+	"github.com/hoyci/ms-chat/message-service/mocks"
+	"github.com/hoyci/ms-chat/message-service/service/healthcheck"
+	"github.com/hoyci/ms-chat/message-service/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheck(t *testing.T) {
+	since := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("it should report the service as available while connected to RabbitMQ", func(t *testing.T) {
+		monitor := new(mocks.MockBrokerMonitor)
+		monitor.On("Status").Return(broker.Status{State: broker.StateConnected, Since: since})
+
+		handler := healthcheck.NewHealthCheckHandler(config.Config{Environment: "production"}, monitor)
+		router := testutils.NewRouter(handler)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/healthcheck", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		res := w.Result()
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		responseBody, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{
+			"status": "available",
+			"system_info": {
+				"environment": "production",
+				"rabbitmq": "connected",
+				"rabbitmq_since": "2024-05-01T12:00:00Z"
+			}
+		}`, string(responseBody))
+	})
+
+	t.Run("it should report the service as unavailable while reconnecting to RabbitMQ", func(t *testing.T) {
+		monitor := new(mocks.MockBrokerMonitor)
+		monitor.On("Status").Return(broker.Status{State: broker.StateConnecting, Since: since, LastError: "connection refused"})
+
+		handler := healthcheck.NewHealthCheckHandler(config.Config{Environment: "production"}, monitor)
+		router := testutils.NewRouter(handler)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/healthcheck", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		res := w.Result()
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+		responseBody, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{
+			"status": "unavailable",
+			"system_info": {
+				"environment": "production",
+				"rabbitmq": "connecting",
+				"rabbitmq_since": "2024-05-01T12:00:00Z",
+				"rabbitmq_last_error": "connection refused"
+			}
+		}`, string(responseBody))
+	})
+}
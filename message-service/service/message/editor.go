@@ -0,0 +1,251 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/config"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+var (
+	ErrNotMember      = errors.New("user is not a member of the room")
+	ErrNotAuthor      = errors.New("user is not the author of the message")
+	ErrWindowExpired  = errors.New("message is too old to be changed")
+	ErrMessageDeleted = errors.New("message was deleted")
+	ErrEditConflict   = errors.New("message was changed concurrently")
+	ErrInvalidEmoji   = errors.New("reaction is not an emoji")
+)
+
+// Editor applies the edits and deletions requested over REST and WebSocket and pushes
+// them to the devices of the room members. Only the author may edit a message or delete
+// it for everyone, within the windows configured for each; any member may delete a
+// message for themselves at any time.
+type Editor struct {
+	messageStore types.MessageStore
+	roomStore    types.RoomStore
+	broadcaster  types.Broadcaster
+	editWindow   time.Duration
+	deleteWindow time.Duration
+}
+
+func NewEditor(messageStore types.MessageStore, roomStore types.RoomStore, broadcaster types.Broadcaster) *Editor {
+	return &Editor{
+		messageStore: messageStore,
+		roomStore:    roomStore,
+		broadcaster:  broadcaster,
+		editWindow:   config.Envs.MessageEditWindow,
+		deleteWindow: config.Envs.MessageDeleteWindow,
+	}
+}
+
+func (e *Editor) Edit(ctx context.Context, userID, messageID, content string) (*types.Message, error) {
+	message, room, err := e.load(ctx, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := e.authorize(*message, userID, e.editWindow); err != nil {
+		return nil, err
+	}
+
+	edited, err := e.messageStore.Edit(ctx, *message, content, time.Now())
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrEditConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e.broadcast(ctx, coreTypes.BroadcastMessage{
+		UserIDs: viewers(*room, *edited),
+		Updated: []coreTypes.Message{edited.ToCore()},
+	})
+
+	return edited, nil
+}
+
+func (e *Editor) Delete(ctx context.Context, userID, messageID string, scope coreTypes.DeleteScope) error {
+	message, room, err := e.load(ctx, userID, messageID)
+	if err != nil {
+		return err
+	}
+
+	deletion := coreTypes.MessageDeletion{
+		MessageID: message.ID.Hex(),
+		RoomID:    message.RoomID.Hex(),
+		Scope:     scope,
+		DeletedAt: time.Now(),
+	}
+
+	if scope == coreTypes.DeleteForMe {
+		if err := e.messageStore.HideFor(ctx, message.ID, userID); err != nil {
+			return err
+		}
+
+		e.broadcast(ctx, coreTypes.BroadcastMessage{
+			UserIDs: []string{userID},
+			Deleted: []coreTypes.MessageDeletion{deletion},
+		})
+		return nil
+	}
+
+	if err := e.authorize(*message, userID, e.deleteWindow); err != nil {
+		return err
+	}
+
+	deleted, err := e.messageStore.DeleteForEveryone(ctx, message.ID, deletion.DeletedAt)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrMessageDeleted
+	}
+	if err != nil {
+		return err
+	}
+
+	e.broadcast(ctx, coreTypes.BroadcastMessage{
+		UserIDs: viewers(*room, *deleted),
+		Deleted: []coreTypes.MessageDeletion{deletion},
+	})
+	return nil
+}
+
+// History returns the previous contents of a message the user can see.
+func (e *Editor) History(ctx context.Context, userID, messageID string) ([]types.MessageRevision, error) {
+	message, _, err := e.load(ctx, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.messageStore.ListRevisions(ctx, message.ID)
+}
+
+func (e *Editor) load(ctx context.Context, userID, messageID string) (*types.Message, *types.Room, error) {
+	return loadVisible(ctx, e.messageStore, e.roomStore, userID, messageID)
+}
+
+// loadVisible returns a message the user can see and its room. Messages the user deleted
+// for themselves are reported as not found.
+func loadVisible(ctx context.Context, messageStore types.MessageStore, roomStore types.RoomStore, userID, messageID string) (*types.Message, *types.Room, error) {
+	message, err := messageStore.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	room, err := roomStore.GetByID(ctx, message.RoomID.Hex())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !room.IsMember(userID) {
+		return nil, nil, ErrNotMember
+	}
+
+	if slices.Contains(message.DeletedFor, userID) {
+		return nil, nil, mongo.ErrNoDocuments
+	}
+
+	return message, room, nil
+}
+
+// loadThreadRoot returns the root of the thread of messageID, which is either the root
+// or one of its replies. A root the user deleted for themselves still holds the thread
+// together, so it is returned as deleted, the way the quotes of its replies show it.
+func (e *Editor) loadThreadRoot(ctx context.Context, userID, messageID string) (*types.Message, error) {
+	message, err := e.messageStore.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	root := message
+	if message.ThreadID != nil {
+		root, err = e.messageStore.GetByID(ctx, message.ThreadID.Hex())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	room, err := e.roomStore.GetByID(ctx, root.RoomID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	if !room.IsMember(userID) {
+		return nil, ErrNotMember
+	}
+
+	if message != root && slices.Contains(message.DeletedFor, userID) {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	if slices.Contains(root.DeletedFor, userID) {
+		return shownAsDeleted(*root, time.Now()), nil
+	}
+
+	return root, nil
+}
+
+// shownAsDeleted strips message down to what a deleted message shows. The time the user
+// deleted it for themselves is not recorded, so at stands for it unless the message was
+// also deleted for everyone.
+func shownAsDeleted(message types.Message, at time.Time) *types.Message {
+	deletedAt := message.DeletedAt
+	if deletedAt == nil {
+		deletedAt = &at
+	}
+
+	return &types.Message{
+		ID:         message.ID,
+		RoomID:     message.RoomID,
+		SenderID:   message.SenderID,
+		ReceiverID: message.ReceiverID,
+		Status:     message.Status,
+		ReplyCount: message.ReplyCount,
+		CreatedAt:  message.CreatedAt,
+		DeletedAt:  deletedAt,
+	}
+}
+
+func (e *Editor) authorize(message types.Message, userID string, window time.Duration) error {
+	if message.DeletedAt != nil {
+		return ErrMessageDeleted
+	}
+
+	if message.SenderID != userID {
+		return ErrNotAuthor
+	}
+
+	if time.Since(message.CreatedAt) > window {
+		return ErrWindowExpired
+	}
+
+	return nil
+}
+
+// broadcast pushes a change that is already persisted; devices that miss it catch up
+// from the history.
+func (e *Editor) broadcast(ctx context.Context, broadcast coreTypes.BroadcastMessage) {
+	if len(broadcast.UserIDs) == 0 {
+		return
+	}
+
+	broadcast.Timestamp = time.Now()
+	if err := e.broadcaster.PublishBroadcast(ctx, broadcast); err != nil {
+		log.Printf("Failed to broadcast the change of a message: %v", err)
+	}
+}
+
+// viewers lists the members of room that did not delete message for themselves.
+func viewers(room types.Room, message types.Message) []string {
+	userIDs := make([]string, 0, len(room.Users))
+	for _, userID := range room.Users {
+		if !slices.Contains(message.DeletedFor, userID) {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs
+}
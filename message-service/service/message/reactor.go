@@ -0,0 +1,86 @@
+package message
+
+import (
+	"context"
+	"log"
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Reactor adds and removes the reactions requested over REST and WebSocket and pushes
+// the changes to the devices of the room members. Adding a reaction twice or removing a
+// missing one succeeds without notifying anybody.
+type Reactor struct {
+	messageStore types.MessageStore
+	roomStore    types.RoomStore
+	broadcaster  types.Broadcaster
+}
+
+func NewReactor(messageStore types.MessageStore, roomStore types.RoomStore, broadcaster types.Broadcaster) *Reactor {
+	return &Reactor{messageStore: messageStore, roomStore: roomStore, broadcaster: broadcaster}
+}
+
+func (r *Reactor) React(ctx context.Context, userID, messageID, emoji string, action coreTypes.ReactionAction) error {
+	if !types.ValidEmoji(emoji) {
+		return ErrInvalidEmoji
+	}
+
+	message, room, err := loadVisible(ctx, r.messageStore, r.roomStore, userID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if message.DeletedAt != nil {
+		return ErrMessageDeleted
+	}
+
+	now := time.Now()
+	var changed bool
+	if action == coreTypes.ReactionRemove {
+		changed, err = r.messageStore.RemoveReaction(ctx, message.ID, userID, emoji)
+	} else {
+		changed, err = r.messageStore.AddReaction(ctx, types.Reaction{
+			ID:        bson.NewObjectID(),
+			MessageID: message.ID,
+			RoomID:    message.RoomID,
+			UserID:    userID,
+			Emoji:     emoji,
+			CreatedAt: now,
+		})
+	}
+	if err != nil || !changed {
+		return err
+	}
+
+	// The change is already persisted; devices that miss it catch up from the history.
+	err = r.broadcaster.PublishBroadcast(ctx, coreTypes.BroadcastMessage{
+		UserIDs: viewers(*room, *message),
+		Reactions: []coreTypes.ReactionChange{{
+			MessageID: message.ID.Hex(),
+			RoomID:    message.RoomID.Hex(),
+			UserID:    userID,
+			Emoji:     emoji,
+			Action:    action,
+			Timestamp: now,
+		}},
+		Timestamp: now,
+	})
+	if err != nil {
+		log.Printf("Failed to broadcast the reaction of %s to %s: %v", userID, messageID, err)
+	}
+
+	return nil
+}
+
+// List returns the reactions to a message the user can see, grouped by emoji.
+func (r *Reactor) List(ctx context.Context, userID, messageID string) ([]types.ReactionSummary, error) {
+	message, _, err := loadVisible(ctx, r.messageStore, r.roomStore, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	return types.SummarizeReactions(message.Reactions), nil
+}
@@ -0,0 +1,588 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	coreMiddlewares "github.com/hoyci/ms-chat/core/middlewares"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	coreUtils "github.com/hoyci/ms-chat/core/utils"
+	"github.com/hoyci/ms-chat/message-service/keys"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+)
+
+var validate = validator.New()
+
+type MessageHandler struct {
+	messageStore types.MessageStore
+	roomStore    types.RoomStore
+	editor       *Editor
+	reactor      *Reactor
+}
+
+func NewMessageHandler(messageStore types.MessageStore, roomStore types.RoomStore, broadcaster types.Broadcaster) *MessageHandler {
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+
+		if name == "-" {
+			return ""
+		}
+
+		return name
+	})
+	return &MessageHandler{
+		messageStore: messageStore,
+		roomStore:    roomStore,
+		editor:       NewEditor(messageStore, roomStore, broadcaster),
+		reactor:      NewReactor(messageStore, roomStore, broadcaster),
+	}
+}
+
+// RegisterRoutes adds the endpoints of the handler to the versioned API router.
+func (h *MessageHandler) RegisterRoutes(router *mux.Router) {
+	router.Handle(
+		"/rooms/{room_id}/messages", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleListRoomMessages),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodGet)
+	router.Handle(
+		"/messages/{message_id}", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleEditMessage),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodPatch)
+	router.Handle(
+		"/messages/{message_id}", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleDeleteMessage),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodDelete)
+	router.Handle(
+		"/messages/{message_id}/revisions", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleListMessageRevisions),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodGet)
+	router.Handle(
+		"/messages/{message_id}/thread", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleGetThread),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodGet)
+	router.Handle(
+		"/messages/{message_id}/reactions", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleListReactions),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodGet)
+	router.Handle(
+		"/messages/{message_id}/reactions/{emoji}", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleAddReaction),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodPut)
+	router.Handle(
+		"/messages/{message_id}/reactions/{emoji}", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleRemoveReaction),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodDelete)
+}
+
+// HandleListRoomMessages
+// @Summary List the messages of a room
+// @Tags Messages
+// @Produce json
+// @Security BearerAuth
+// @Param room_id path string true "Room ID"
+// @Param before query string false "Return messages older than this message ID"
+// @Param after query string false "Return messages newer than this message ID"
+// @Param limit query int false "Page size (default 50, max 100)"
+// @Success 200 {object} types.ListMessagesResponse "Messages in chronological order"
+// @Failure 400 {object} coreTypes.BadRequestResponse "Invalid room ID, cursor or limit"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not a member of the room"
+// @Failure 404 {object} coreTypes.NotFoundResponse "Room not found"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /rooms/{room_id}/messages [get]
+func (h *MessageHandler) HandleListRoomMessages(w http.ResponseWriter, r *http.Request) {
+	userID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			"HandleListRoomMessages", coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	page, err := parseMessagePage(r)
+	if err != nil {
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, err, "HandleListRoomMessages",
+			coreTypes.BadRequestResponse{Error: err.Error()},
+		)
+		return
+	}
+	page.UserID = userID
+
+	roomID := mux.Vars(r)["room_id"]
+	room, err := h.roomStore.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, bson.ErrInvalidHex) {
+			coreUtils.WriteError(
+				w, http.StatusBadRequest, err, "HandleListRoomMessages",
+				coreTypes.BadRequestResponse{Error: "Invalid room_id"},
+			)
+			return
+		}
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			coreUtils.WriteError(
+				w, http.StatusNotFound, err, "HandleListRoomMessages",
+				coreTypes.NotFoundResponse{Error: "Room not found"},
+			)
+			return
+		}
+
+		writeStoreError(w, err, "HandleListRoomMessages")
+		return
+	}
+
+	if !room.IsMember(userID) {
+		coreUtils.WriteError(
+			w, http.StatusForbidden, fmt.Errorf("user %s is not a member of room %s", userID, roomID),
+			"HandleListRoomMessages", coreTypes.ForbiddenResponse{Error: "You are not a member of this room"},
+		)
+		return
+	}
+
+	messages, hasMore, err := h.messageStore.ListByRoom(r.Context(), room.ID, page)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			coreUtils.WriteError(
+				w, http.StatusBadRequest, err, "HandleListRoomMessages",
+				coreTypes.BadRequestResponse{Error: "Cursor message not found in this room"},
+			)
+			return
+		}
+
+		writeStoreError(w, err, "HandleListRoomMessages")
+		return
+	}
+
+	if messages == nil {
+		messages = []types.Message{}
+	}
+
+	_ = coreUtils.WriteJSON(
+		w, http.StatusOK, types.ListMessagesResponse{Messages: messages, HasMore: hasMore},
+	)
+}
+
+// HandleEditMessage
+// @Summary Edit a message
+// @Description Only the author can edit a message, within the edit window. The previous content is kept in the message revisions.
+// @Tags Messages
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param message_id path string true "Message ID"
+// @Param body body types.EditMessagePayload true "New content"
+// @Success 200 {object} types.MessageResponse "Edited message"
+// @Failure 400 {object} coreTypes.BadRequestStructResponse "Invalid message ID or payload"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not the author or the edit window expired"
+// @Failure 404 {object} coreTypes.NotFoundResponse "Message not found"
+// @Failure 409 {object} coreTypes.ConflictResponse "Message was deleted or changed concurrently"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /messages/{message_id} [patch]
+func (h *MessageHandler) HandleEditMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			"HandleEditMessage", coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	var requestPayload types.EditMessagePayload
+	if !parseAndValidate(w, r, &requestPayload, "HandleEditMessage") {
+		return
+	}
+
+	edited, err := h.editor.Edit(r.Context(), userID, mux.Vars(r)["message_id"], requestPayload.Content)
+	if err != nil {
+		writeEditorError(w, err, "HandleEditMessage")
+		return
+	}
+
+	_ = coreUtils.WriteJSON(w, http.StatusOK, types.MessageResponse{Message: edited})
+}
+
+// HandleDeleteMessage
+// @Summary Delete a message
+// @Description With scope=me the message is only hidden from the caller. With scope=everyone only the author can delete it, within the delete window.
+// @Tags Messages
+// @Security BearerAuth
+// @Param message_id path string true "Message ID"
+// @Param scope query string false "me (default) or everyone"
+// @Success 204 "Message deleted"
+// @Failure 400 {object} coreTypes.BadRequestResponse "Invalid message ID or scope"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not the author or the delete window expired"
+// @Failure 404 {object} coreTypes.NotFoundResponse "Message not found"
+// @Failure 409 {object} coreTypes.ConflictResponse "Message was already deleted"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /messages/{message_id} [delete]
+func (h *MessageHandler) HandleDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			"HandleDeleteMessage", coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	scope := coreTypes.DeleteScope(r.URL.Query().Get("scope"))
+	if scope == "" {
+		scope = coreTypes.DeleteForMe
+	}
+	if scope != coreTypes.DeleteForMe && scope != coreTypes.DeleteForEveryone {
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, fmt.Errorf("user sent scope %q", scope), "HandleDeleteMessage",
+			coreTypes.BadRequestResponse{Error: "scope must be me or everyone"},
+		)
+		return
+	}
+
+	if err := h.editor.Delete(r.Context(), userID, mux.Vars(r)["message_id"], scope); err != nil {
+		writeEditorError(w, err, "HandleDeleteMessage")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListMessageRevisions
+// @Summary List the previous contents of a message
+// @Tags Messages
+// @Produce json
+// @Security BearerAuth
+// @Param message_id path string true "Message ID"
+// @Success 200 {object} types.ListRevisionsResponse "Revisions, oldest first"
+// @Failure 400 {object} coreTypes.BadRequestResponse "Invalid message ID"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not a member of the room"
+// @Failure 404 {object} coreTypes.NotFoundResponse "Message not found"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /messages/{message_id}/revisions [get]
+func (h *MessageHandler) HandleListMessageRevisions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			"HandleListMessageRevisions", coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	revisions, err := h.editor.History(r.Context(), userID, mux.Vars(r)["message_id"])
+	if err != nil {
+		writeEditorError(w, err, "HandleListMessageRevisions")
+		return
+	}
+
+	if revisions == nil {
+		revisions = []types.MessageRevision{}
+	}
+
+	_ = coreUtils.WriteJSON(w, http.StatusOK, types.ListRevisionsResponse{Revisions: revisions})
+}
+
+// HandleGetThread
+// @Summary List the replies of a thread
+// @Description Any message of the thread can be given; the thread of its root is returned. A root the caller deleted for themselves is returned as deleted.
+// @Tags Messages
+// @Produce json
+// @Security BearerAuth
+// @Param message_id path string true "Message ID"
+// @Param before query string false "Return replies older than this message ID"
+// @Param after query string false "Return replies newer than this message ID"
+// @Param limit query int false "Page size (default 50, max 100)"
+// @Success 200 {object} types.ThreadResponse "Root message and replies in chronological order"
+// @Failure 400 {object} coreTypes.BadRequestResponse "Invalid message ID, cursor or limit"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not a member of the room"
+// @Failure 404 {object} coreTypes.NotFoundResponse "Message not found"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /messages/{message_id}/thread [get]
+func (h *MessageHandler) HandleGetThread(w http.ResponseWriter, r *http.Request) {
+	userID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			"HandleGetThread", coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	page, err := parseMessagePage(r)
+	if err != nil {
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, err, "HandleGetThread",
+			coreTypes.BadRequestResponse{Error: err.Error()},
+		)
+		return
+	}
+	page.UserID = userID
+
+	root, err := h.editor.loadThreadRoot(r.Context(), userID, mux.Vars(r)["message_id"])
+	if err != nil {
+		writeEditorError(w, err, "HandleGetThread")
+		return
+	}
+
+	replies, hasMore, err := h.messageStore.ListThread(r.Context(), root.ID, page)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			coreUtils.WriteError(
+				w, http.StatusBadRequest, err, "HandleGetThread",
+				coreTypes.BadRequestResponse{Error: "Cursor message not found in this thread"},
+			)
+			return
+		}
+
+		writeStoreError(w, err, "HandleGetThread")
+		return
+	}
+
+	if replies == nil {
+		replies = []types.Message{}
+	}
+
+	_ = coreUtils.WriteJSON(
+		w, http.StatusOK, types.ThreadResponse{Root: root, Replies: replies, HasMore: hasMore},
+	)
+}
+
+// HandleAddReaction
+// @Summary React to a message
+// @Description Reacting twice with the same emoji has no effect.
+// @Tags Messages
+// @Security BearerAuth
+// @Param message_id path string true "Message ID"
+// @Param emoji path string true "URL-encoded emoji"
+// @Success 204 "Reaction added"
+// @Failure 400 {object} coreTypes.BadRequestResponse "Invalid message ID or emoji"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not a member of the room"
+// @Failure 404 {object} coreTypes.NotFoundResponse "Message not found"
+// @Failure 409 {object} coreTypes.ConflictResponse "Message was deleted"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /messages/{message_id}/reactions/{emoji} [put]
+func (h *MessageHandler) HandleAddReaction(w http.ResponseWriter, r *http.Request) {
+	h.handleReaction(w, r, coreTypes.ReactionAdd, "HandleAddReaction")
+}
+
+// HandleRemoveReaction
+// @Summary Remove a reaction from a message
+// @Tags Messages
+// @Security BearerAuth
+// @Param message_id path string true "Message ID"
+// @Param emoji path string true "URL-encoded emoji"
+// @Success 204 "Reaction removed"
+// @Failure 400 {object} coreTypes.BadRequestResponse "Invalid message ID or emoji"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not a member of the room"
+// @Failure 404 {object} coreTypes.NotFoundResponse "Message not found"
+// @Failure 409 {object} coreTypes.ConflictResponse "Message was deleted"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /messages/{message_id}/reactions/{emoji} [delete]
+func (h *MessageHandler) HandleRemoveReaction(w http.ResponseWriter, r *http.Request) {
+	h.handleReaction(w, r, coreTypes.ReactionRemove, "HandleRemoveReaction")
+}
+
+func (h *MessageHandler) handleReaction(w http.ResponseWriter, r *http.Request, action coreTypes.ReactionAction, handlerName string) {
+	userID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			handlerName, coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	vars := mux.Vars(r)
+	if err := h.reactor.React(r.Context(), userID, vars["message_id"], vars["emoji"], action); err != nil {
+		writeEditorError(w, err, handlerName)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListReactions
+// @Summary List the reactions to a message
+// @Tags Messages
+// @Produce json
+// @Security BearerAuth
+// @Param message_id path string true "Message ID"
+// @Success 200 {object} types.ListReactionsResponse "Reactions grouped by emoji, most used first"
+// @Failure 400 {object} coreTypes.BadRequestResponse "Invalid message ID"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not a member of the room"
+// @Failure 404 {object} coreTypes.NotFoundResponse "Message not found"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /messages/{message_id}/reactions [get]
+func (h *MessageHandler) HandleListReactions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			"HandleListReactions", coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	reactions, err := h.reactor.List(r.Context(), userID, mux.Vars(r)["message_id"])
+	if err != nil {
+		writeEditorError(w, err, "HandleListReactions")
+		return
+	}
+
+	_ = coreUtils.WriteJSON(w, http.StatusOK, types.ListReactionsResponse{Reactions: reactions})
+}
+
+func parseMessagePage(r *http.Request) (types.MessagePage, error) {
+	query := r.URL.Query()
+	page := types.MessagePage{Limit: defaultPageLimit}
+
+	if rawLimit := query.Get("limit"); rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return page, fmt.Errorf("limit must be a number between 1 and %d", maxPageLimit)
+		}
+		page.Limit = limit
+	}
+
+	before, after := query.Get("before"), query.Get("after")
+	if before != "" && after != "" {
+		return page, fmt.Errorf("before and after cannot be used together")
+	}
+
+	if before != "" {
+		id, err := bson.ObjectIDFromHex(before)
+		if err != nil {
+			return page, fmt.Errorf("before must be a valid message ID")
+		}
+		page.Before = &id
+	}
+
+	if after != "" {
+		id, err := bson.ObjectIDFromHex(after)
+		if err != nil {
+			return page, fmt.Errorf("after must be a valid message ID")
+		}
+		page.After = &id
+	}
+
+	return page, nil
+}
+
+func parseAndValidate(w http.ResponseWriter, r *http.Request, payload any, handlerName string) bool {
+	if err := coreUtils.ParseJSON(r, payload); err != nil {
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, err, handlerName,
+			coreTypes.BadRequestResponse{Error: "Body is not a valid json"},
+		)
+		return false
+	}
+
+	if err := validate.Struct(payload); err != nil {
+		var errorMessages []string
+		for _, e := range err.(validator.ValidationErrors) {
+			errorMessages = append(errorMessages, fmt.Sprintf("Field '%s' is invalid: %s", e.Field(), e.Tag()))
+		}
+
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, err, handlerName,
+			coreTypes.BadRequestStructResponse{Error: errorMessages},
+		)
+		return false
+	}
+
+	return true
+}
+
+// writeEditorError answers with the status matching an error returned by the Editor or
+// the Reactor.
+func writeEditorError(w http.ResponseWriter, err error, handlerName string) {
+	switch {
+	case errors.Is(err, ErrInvalidEmoji):
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, err, handlerName,
+			coreTypes.BadRequestResponse{Error: "Reactions must be a single emoji"},
+		)
+	case errors.Is(err, bson.ErrInvalidHex):
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, err, handlerName,
+			coreTypes.BadRequestResponse{Error: "Invalid message_id"},
+		)
+	case errors.Is(err, mongo.ErrNoDocuments):
+		coreUtils.WriteError(
+			w, http.StatusNotFound, err, handlerName,
+			coreTypes.NotFoundResponse{Error: "Message not found"},
+		)
+	case errors.Is(err, ErrNotMember):
+		coreUtils.WriteError(
+			w, http.StatusForbidden, err, handlerName,
+			coreTypes.ForbiddenResponse{Error: "You are not a member of this room"},
+		)
+	case errors.Is(err, ErrNotAuthor):
+		coreUtils.WriteError(
+			w, http.StatusForbidden, err, handlerName,
+			coreTypes.ForbiddenResponse{Error: "Only the author can change this message"},
+		)
+	case errors.Is(err, ErrWindowExpired):
+		coreUtils.WriteError(
+			w, http.StatusForbidden, err, handlerName,
+			coreTypes.ForbiddenResponse{Error: "This message is too old to be changed"},
+		)
+	case errors.Is(err, ErrMessageDeleted):
+		coreUtils.WriteError(
+			w, http.StatusConflict, err, handlerName,
+			coreTypes.ConflictResponse{Error: "This message was deleted"},
+		)
+	case errors.Is(err, ErrEditConflict):
+		coreUtils.WriteError(
+			w, http.StatusConflict, err, handlerName,
+			coreTypes.ConflictResponse{Error: "This message was changed meanwhile, try again"},
+		)
+	default:
+		writeStoreError(w, err, handlerName)
+	}
+}
+
+func writeStoreError(w http.ResponseWriter, err error, handlerName string) {
+	if errors.Is(err, context.Canceled) {
+		coreUtils.WriteError(
+			w, http.StatusServiceUnavailable, err, handlerName,
+			coreTypes.ContextCanceledResponse{Error: "Request canceled"},
+		)
+		return
+	}
+
+	coreUtils.WriteError(
+		w, http.StatusInternalServerError, err, handlerName,
+		coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+	)
+}
@@ -0,0 +1,564 @@
+package message_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/mocks"
+	"github.com/hoyci/ms-chat/message-service/service/message"
+	"github.com/hoyci/ms-chat/message-service/testutils"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func setupTestServer() (*mocks.MockMessageStore, *mocks.MockRoomStore, *mux.Router) {
+	mockMessageStore, mockRoomStore, _, router := setupTestServerWithBroadcaster()
+	return mockMessageStore, mockRoomStore, router
+}
+
+func setupTestServerWithBroadcaster() (*mocks.MockMessageStore, *mocks.MockRoomStore, *mocks.MockBroadcaster, *mux.Router) {
+	mockMessageStore := new(mocks.MockMessageStore)
+	mockRoomStore := new(mocks.MockRoomStore)
+	mockBroadcaster := new(mocks.MockBroadcaster)
+	messageHandler := message.NewMessageHandler(mockMessageStore, mockRoomStore, mockBroadcaster)
+	router := testutils.NewRouter(messageHandler)
+	return mockMessageStore, mockRoomStore, mockBroadcaster, router
+}
+
+func TestHandleListRoomMessages(t *testing.T) {
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	otherUserID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+	roomID := bson.NewObjectID()
+	room := &types.Room{ID: roomID, Users: []string{userID, otherUserID}}
+
+	t.Run("it should return the latest messages of a room the user belongs to", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		messages := []types.Message{
+			{ID: bson.NewObjectID(), RoomID: roomID, SenderID: userID, Content: "Hi", CreatedAt: time.Now()},
+		}
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockMessageStore.On("ListByRoom", mock.Anything, roomID, types.MessagePage{UserID: userID, Limit: 50}).Return(messages, true, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/rooms/"+roomID.Hex()+"/messages", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response types.ListMessagesResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.True(t, response.HasMore)
+		assert.Len(t, response.Messages, 1)
+		assert.Equal(t, messages[0].ID, response.Messages[0].ID)
+	})
+
+	t.Run("it should forward the cursor and limit to the store", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		before := bson.NewObjectID()
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockMessageStore.On("ListByRoom", mock.Anything, roomID, types.MessagePage{UserID: userID, Before: &before, Limit: 20}).
+			Return([]types.Message{}, false, nil)
+
+		req := testutils.NewAuthenticatedRequest(
+			http.MethodGet, "/api/v1/rooms/"+roomID.Hex()+"/messages?before="+before.Hex()+"&limit=20", userID,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"messages":[],"has_more":false}`, w.Body.String())
+		mockMessageStore.AssertExpectations(t)
+	})
+
+	t.Run("it should reject users who are not members of the room", func(t *testing.T) {
+		_, mockRoomStore, router := setupTestServer()
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := testutils.NewAuthenticatedRequest(
+			http.MethodGet, "/api/v1/rooms/"+roomID.Hex()+"/messages", "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d",
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.JSONEq(t, `{"error":"You are not a member of this room"}`, w.Body.String())
+	})
+
+	t.Run("it should return not found when the room does not exist", func(t *testing.T) {
+		_, mockRoomStore, router := setupTestServer()
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return((*types.Room)(nil), mongo.ErrNoDocuments)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/rooms/"+roomID.Hex()+"/messages", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("it should reject before and after used together", func(t *testing.T) {
+		_, _, router := setupTestServer()
+		cursor := bson.NewObjectID().Hex()
+
+		req := testutils.NewAuthenticatedRequest(
+			http.MethodGet, "/api/v1/rooms/"+roomID.Hex()+"/messages?before="+cursor+"&after="+cursor, userID,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("it should require an access token", func(t *testing.T) {
+		_, _, router := setupTestServer()
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/rooms/"+roomID.Hex()+"/messages", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response coreTypes.UnauthorizedResponse
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	})
+}
+
+func TestHandleEditMessage(t *testing.T) {
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	otherUserID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+	roomID := bson.NewObjectID()
+	room := &types.Room{ID: roomID, Users: []string{userID, otherUserID}}
+
+	newMessage := func(createdAt time.Time) *types.Message {
+		return &types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: userID, Content: "Hi", CreatedAt: createdAt}
+	}
+
+	t.Run("it should edit the message and push it to the room members", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, mockBroadcaster, router := setupTestServerWithBroadcaster()
+		original := newMessage(time.Now().Add(-time.Minute))
+		editedAt := time.Now()
+		edited := *original
+		edited.Content = "Hello"
+		edited.UpdatedAt = &editedAt
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockMessageStore.On("Edit", mock.Anything, *original, "Hello", mock.Anything).Return(&edited, nil)
+		mockBroadcaster.On("PublishBroadcast", mock.Anything, mock.MatchedBy(func(b coreTypes.BroadcastMessage) bool {
+			return len(b.Updated) == 1 && b.Updated[0].Content == "Hello" && len(b.UserIDs) == 2
+		})).Return(nil)
+
+		req := testutils.NewAuthenticatedRequestWithBody(
+			http.MethodPatch, "/api/v1/messages/"+original.ID.Hex(), userID, `{"content":"Hello"}`,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response types.MessageResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "Hello", response.Message.Content)
+		assert.NotNil(t, response.Message.UpdatedAt)
+		mockBroadcaster.AssertExpectations(t)
+	})
+
+	t.Run("it should only let the author edit a message", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		original := newMessage(time.Now())
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := testutils.NewAuthenticatedRequestWithBody(
+			http.MethodPatch, "/api/v1/messages/"+original.ID.Hex(), otherUserID, `{"content":"Hello"}`,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.JSONEq(t, `{"error":"Only the author can change this message"}`, w.Body.String())
+		mockMessageStore.AssertNotCalled(t, "Edit", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should refuse edits past the edit window", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		original := newMessage(time.Now().Add(-24 * time.Hour))
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := testutils.NewAuthenticatedRequestWithBody(
+			http.MethodPatch, "/api/v1/messages/"+original.ID.Hex(), userID, `{"content":"Hello"}`,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.JSONEq(t, `{"error":"This message is too old to be changed"}`, w.Body.String())
+	})
+
+	t.Run("it should refuse to edit a deleted message", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		original := newMessage(time.Now())
+		deletedAt := time.Now()
+		original.DeletedAt = &deletedAt
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := testutils.NewAuthenticatedRequestWithBody(
+			http.MethodPatch, "/api/v1/messages/"+original.ID.Hex(), userID, `{"content":"Hello"}`,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("it should reject an empty content", func(t *testing.T) {
+		_, _, router := setupTestServer()
+
+		req := testutils.NewAuthenticatedRequestWithBody(
+			http.MethodPatch, "/api/v1/messages/"+bson.NewObjectID().Hex(), userID, `{"content":""}`,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.JSONEq(t, `{"error":["Field 'content' is invalid: required"]}`, w.Body.String())
+	})
+}
+
+func TestHandleDeleteMessage(t *testing.T) {
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	otherUserID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+	roomID := bson.NewObjectID()
+	room := &types.Room{ID: roomID, Users: []string{userID, otherUserID}}
+
+	t.Run("it should delete the message for everyone", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, mockBroadcaster, router := setupTestServerWithBroadcaster()
+		original := &types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: userID, Content: "Hi", CreatedAt: time.Now()}
+		deletedAt := time.Now()
+		deleted := *original
+		deleted.Content = ""
+		deleted.DeletedAt = &deletedAt
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockMessageStore.On("DeleteForEveryone", mock.Anything, original.ID, mock.Anything).Return(&deleted, nil)
+		mockBroadcaster.On("PublishBroadcast", mock.Anything, mock.MatchedBy(func(b coreTypes.BroadcastMessage) bool {
+			return len(b.Deleted) == 1 && b.Deleted[0].Scope == coreTypes.DeleteForEveryone && len(b.UserIDs) == 2
+		})).Return(nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodDelete, "/api/v1/messages/"+original.ID.Hex()+"?scope=everyone", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockBroadcaster.AssertExpectations(t)
+	})
+
+	t.Run("it should hide the message from a member who is not the author", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, mockBroadcaster, router := setupTestServerWithBroadcaster()
+		original := &types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: userID, Content: "Hi", CreatedAt: time.Now()}
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockMessageStore.On("HideFor", mock.Anything, original.ID, otherUserID).Return(nil)
+		mockBroadcaster.On("PublishBroadcast", mock.Anything, mock.MatchedBy(func(b coreTypes.BroadcastMessage) bool {
+			return len(b.Deleted) == 1 && b.Deleted[0].Scope == coreTypes.DeleteForMe &&
+				len(b.UserIDs) == 1 && b.UserIDs[0] == otherUserID
+		})).Return(nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodDelete, "/api/v1/messages/"+original.ID.Hex(), otherUserID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockMessageStore.AssertExpectations(t)
+		mockBroadcaster.AssertExpectations(t)
+	})
+
+	t.Run("it should only let the author delete a message for everyone", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		original := &types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: userID, Content: "Hi", CreatedAt: time.Now()}
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodDelete, "/api/v1/messages/"+original.ID.Hex()+"?scope=everyone", otherUserID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("it should reject users who are not members of the room", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		original := &types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: userID, Content: "Hi", CreatedAt: time.Now()}
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := testutils.NewAuthenticatedRequest(
+			http.MethodDelete, "/api/v1/messages/"+original.ID.Hex(), "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d",
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.JSONEq(t, `{"error":"You are not a member of this room"}`, w.Body.String())
+	})
+
+	t.Run("it should reject an unknown scope", func(t *testing.T) {
+		_, _, router := setupTestServer()
+
+		req := testutils.NewAuthenticatedRequest(http.MethodDelete, "/api/v1/messages/"+bson.NewObjectID().Hex()+"?scope=all", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestHandleListMessageRevisions(t *testing.T) {
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	roomID := bson.NewObjectID()
+	room := &types.Room{ID: roomID, Users: []string{userID}}
+
+	t.Run("it should return the previous contents of the message", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		original := &types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: userID, Content: "Hello"}
+		revisions := []types.MessageRevision{
+			{ID: bson.NewObjectID(), MessageID: original.ID, Content: "Hi", EditedAt: time.Now()},
+		}
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockMessageStore.On("ListRevisions", mock.Anything, original.ID).Return(revisions, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/messages/"+original.ID.Hex()+"/revisions", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response types.ListRevisionsResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Len(t, response.Revisions, 1)
+		assert.Equal(t, "Hi", response.Revisions[0].Content)
+	})
+
+	t.Run("it should return not found for a message deleted for the user", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		original := &types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: userID, DeletedFor: []string{userID}}
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/messages/"+original.ID.Hex()+"/revisions", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestHandleGetThread(t *testing.T) {
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	roomID := bson.NewObjectID()
+	room := &types.Room{ID: roomID, Users: []string{userID}}
+	root := &types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: userID, Content: "Lunch?", ReplyCount: 1}
+	reply := types.Message{
+		ID: bson.NewObjectID(), RoomID: roomID, SenderID: userID, Content: "Yes",
+		ReplyTo: &root.ID, ThreadID: &root.ID, Quote: types.QuoteOf(*root),
+	}
+
+	t.Run("it should return the root and a page of its replies", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		mockMessageStore.On("GetByID", mock.Anything, root.ID.Hex()).Return(root, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockMessageStore.On("ListThread", mock.Anything, root.ID, types.MessagePage{UserID: userID, Limit: 10}).
+			Return([]types.Message{reply}, false, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/messages/"+root.ID.Hex()+"/thread?limit=10", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response types.ThreadResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, root.ID, response.Root.ID)
+		assert.Equal(t, 1, response.Root.ReplyCount)
+		assert.Len(t, response.Replies, 1)
+		assert.Equal(t, "Lunch?", response.Replies[0].Quote.Snippet)
+	})
+
+	t.Run("it should return the thread of the root when given a reply", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		mockMessageStore.On("GetByID", mock.Anything, reply.ID.Hex()).Return(&reply, nil)
+		mockMessageStore.On("GetByID", mock.Anything, root.ID.Hex()).Return(root, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockMessageStore.On("ListThread", mock.Anything, root.ID, types.MessagePage{UserID: userID, Limit: 50}).
+			Return([]types.Message{reply}, false, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/messages/"+reply.ID.Hex()+"/thread", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockMessageStore.AssertExpectations(t)
+	})
+
+	t.Run("it should show a root the user deleted for themselves as deleted", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		hidden := *root
+		hidden.DeletedFor = []string{userID}
+		mockMessageStore.On("GetByID", mock.Anything, reply.ID.Hex()).Return(&reply, nil)
+		mockMessageStore.On("GetByID", mock.Anything, root.ID.Hex()).Return(&hidden, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockMessageStore.On("ListThread", mock.Anything, root.ID, types.MessagePage{UserID: userID, Limit: 50}).
+			Return([]types.Message{reply}, false, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/messages/"+reply.ID.Hex()+"/thread", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response types.ThreadResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, root.ID, response.Root.ID)
+		assert.Empty(t, response.Root.Content)
+		assert.NotNil(t, response.Root.DeletedAt)
+		assert.Equal(t, 1, response.Root.ReplyCount)
+		assert.Len(t, response.Replies, 1)
+	})
+
+	t.Run("it should return not found for a reply the user deleted for themselves", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		hidden := reply
+		hidden.DeletedFor = []string{userID}
+		mockMessageStore.On("GetByID", mock.Anything, reply.ID.Hex()).Return(&hidden, nil)
+		mockMessageStore.On("GetByID", mock.Anything, root.ID.Hex()).Return(root, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/messages/"+reply.ID.Hex()+"/thread", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("it should reject users who are not members of the room", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		mockMessageStore.On("GetByID", mock.Anything, root.ID.Hex()).Return(root, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := testutils.NewAuthenticatedRequest(
+			http.MethodGet, "/api/v1/messages/"+root.ID.Hex()+"/thread", "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d",
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestHandleReactions(t *testing.T) {
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	otherUserID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+	roomID := bson.NewObjectID()
+	room := &types.Room{ID: roomID, Users: []string{userID, otherUserID}}
+	thumbsUp := "\U0001F44D"
+
+	newMessage := func() *types.Message {
+		return &types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: otherUserID, Content: "Hi", CreatedAt: time.Now()}
+	}
+
+	t.Run("it should add the reaction and push it to the room members", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, mockBroadcaster, router := setupTestServerWithBroadcaster()
+		original := newMessage()
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockMessageStore.On("AddReaction", mock.Anything, mock.MatchedBy(func(r types.Reaction) bool {
+			return r.MessageID == original.ID && r.UserID == userID && r.Emoji == thumbsUp
+		})).Return(true, nil)
+		mockBroadcaster.On("PublishBroadcast", mock.Anything, mock.MatchedBy(func(b coreTypes.BroadcastMessage) bool {
+			return len(b.Reactions) == 1 && b.Reactions[0].Action == coreTypes.ReactionAdd && len(b.UserIDs) == 2
+		})).Return(nil)
+
+		req := testutils.NewAuthenticatedRequest(
+			http.MethodPut, "/api/v1/messages/"+original.ID.Hex()+"/reactions/%F0%9F%91%8D", userID,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockBroadcaster.AssertExpectations(t)
+	})
+
+	t.Run("it should not push a reaction the user already made", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, mockBroadcaster, router := setupTestServerWithBroadcaster()
+		original := newMessage()
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockMessageStore.On("AddReaction", mock.Anything, mock.Anything).Return(false, nil)
+
+		req := testutils.NewAuthenticatedRequest(
+			http.MethodPut, "/api/v1/messages/"+original.ID.Hex()+"/reactions/%F0%9F%91%8D", userID,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockBroadcaster.AssertNotCalled(t, "PublishBroadcast", mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should remove the reaction", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, mockBroadcaster, router := setupTestServerWithBroadcaster()
+		original := newMessage()
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+		mockMessageStore.On("RemoveReaction", mock.Anything, original.ID, userID, thumbsUp).Return(true, nil)
+		mockBroadcaster.On("PublishBroadcast", mock.Anything, mock.MatchedBy(func(b coreTypes.BroadcastMessage) bool {
+			return len(b.Reactions) == 1 && b.Reactions[0].Action == coreTypes.ReactionRemove
+		})).Return(nil)
+
+		req := testutils.NewAuthenticatedRequest(
+			http.MethodDelete, "/api/v1/messages/"+original.ID.Hex()+"/reactions/%F0%9F%91%8D", userID,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockMessageStore.AssertExpectations(t)
+	})
+
+	t.Run("it should reject reactions that are not emoji", func(t *testing.T) {
+		_, _, router := setupTestServer()
+
+		req := testutils.NewAuthenticatedRequest(http.MethodPut, "/api/v1/messages/"+bson.NewObjectID().Hex()+"/reactions/ok", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.JSONEq(t, `{"error":"Reactions must be a single emoji"}`, w.Body.String())
+	})
+
+	t.Run("it should list the reactions grouped by emoji", func(t *testing.T) {
+		mockMessageStore, mockRoomStore, router := setupTestServer()
+		original := newMessage()
+		original.Reactions = map[string][]string{
+			thumbsUp: {userID, otherUserID},
+			"❤️":     {userID},
+		}
+		mockMessageStore.On("GetByID", mock.Anything, original.ID.Hex()).Return(original, nil)
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(room, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/messages/"+original.ID.Hex()+"/reactions", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response types.ListReactionsResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, []types.ReactionSummary{
+			{Emoji: thumbsUp, Count: 2, UserIDs: []string{userID, otherUserID}},
+			{Emoji: "❤️", Count: 1, UserIDs: []string{userID}},
+		}, response.Reactions)
+	})
+}
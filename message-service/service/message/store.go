@@ -2,11 +2,17 @@ package message
 
 import (
 	"context"
+	"slices"
 	"sync"
+	"time"
 
 	coreTypes "github.com/hoyci/ms-chat/core/types"
+
 	"github.com/hoyci/ms-chat/message-service/db"
+	"github.com/hoyci/ms-chat/message-service/types"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 var (
@@ -25,6 +31,55 @@ func GetMessageStore(dbRepo *db.MongoRepository) *MessageStore {
 	return instance
 }
 
+func (s *MessageStore) EnsureIndexes(ctx context.Context) error {
+	err := db.CreateIndexes(s.dbRepo, ctx, "messages", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "room_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateIndexes(s.dbRepo, ctx, "messages", []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "thread_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"thread_id": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "quote.message_id", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+		{
+			Keys: bson.D{{Key: "sender_id", Value: 1}, {Key: "idempotency_key", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"idempotency_key": bson.M{"$type": "string"}}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateIndexes(s.dbRepo, ctx, "message_revisions", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "message_id", Value: 1}, {Key: "edited_at", Value: 1}}},
+		{
+			Keys: bson.D{{Key: "message_id", Value: 1}, {Key: "written_at", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"written_at": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return db.CreateIndexes(s.dbRepo, ctx, "message_reactions", []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "message_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "emoji", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+}
+
 func (s *MessageStore) Create(ctx context.Context, newMessage map[string]any) (bson.ObjectID, error) {
 	result, err := db.Add(
 		s.dbRepo,
@@ -40,12 +95,13 @@ func (s *MessageStore) Create(ctx context.Context, newMessage map[string]any) (b
 	return result, nil
 }
 
-func (s *MessageStore) List(ctx context.Context, filter bson.M) ([]coreTypes.Message, error) {
-	result, err := db.List[coreTypes.Message](
+func (s *MessageStore) List(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOptions]) ([]types.Message, error) {
+	result, err := db.List[types.Message](
 		s.dbRepo,
 		ctx,
 		"messages",
 		filter,
+		opts...,
 	)
 
 	if err != nil {
@@ -54,3 +110,385 @@ func (s *MessageStore) List(ctx context.Context, filter bson.M) ([]coreTypes.Mes
 
 	return result, nil
 }
+
+// ListByRoom returns up to page.Limit messages of a room in chronological order and
+// whether more messages exist past them in the paging direction. Messages are ordered
+// by (created_at, _id) so the cursor stays stable when several messages share the
+// same timestamp.
+func (s *MessageStore) ListByRoom(ctx context.Context, roomID bson.ObjectID, page types.MessagePage) ([]types.Message, bool, error) {
+	return s.listPage(ctx, bson.M{"room_id": roomID}, page)
+}
+
+// ListThread pages through the replies of a thread like ListByRoom does through a room.
+func (s *MessageStore) ListThread(ctx context.Context, rootID bson.ObjectID, page types.MessagePage) ([]types.Message, bool, error) {
+	return s.listPage(ctx, bson.M{"thread_id": rootID}, page)
+}
+
+// listPage returns a page of the messages matching scope. Cursors must match scope too.
+func (s *MessageStore) listPage(ctx context.Context, scope bson.M, page types.MessagePage) ([]types.Message, bool, error) {
+	filter := bson.M{}
+	for key, value := range scope {
+		filter[key] = value
+	}
+	if page.UserID != "" {
+		filter["deleted_for"] = bson.M{"$ne": page.UserID}
+	}
+
+	cursorID, operator, direction := page.Before, "$lt", -1
+	if page.After != nil {
+		cursorID, operator, direction = page.After, "$gt", 1
+	}
+
+	if cursorID != nil {
+		pivotFilter := bson.M{"_id": *cursorID}
+		for key, value := range scope {
+			pivotFilter[key] = value
+		}
+
+		pivot, err := db.GetByFilter[types.Message](s.dbRepo, ctx, "messages", pivotFilter)
+		if err != nil {
+			return nil, false, err
+		}
+
+		filter["$or"] = bson.A{
+			bson.M{"created_at": bson.M{operator: pivot.CreatedAt}},
+			bson.M{"created_at": pivot.CreatedAt, "_id": bson.M{operator: pivot.ID}},
+		}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(page.Limit + 1))
+
+	messages, err := s.List(ctx, filter, opts)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > page.Limit
+	if hasMore {
+		messages = messages[:page.Limit]
+	}
+
+	if direction == -1 {
+		slices.Reverse(messages)
+	}
+
+	return messages, hasMore, nil
+}
+
+func (s *MessageStore) ListByIDs(ctx context.Context, ids []bson.ObjectID) ([]types.Message, error) {
+	return s.List(ctx, bson.M{"_id": bson.M{"$in": ids}})
+}
+
+// ListReceivedBetween returns the messages other members sent to a room after after and
+// until upTo included.
+func (s *MessageStore) ListReceivedBetween(ctx context.Context, roomID bson.ObjectID, userID string, after, upTo time.Time) ([]types.Message, error) {
+	return s.List(ctx, bson.M{
+		"room_id":    roomID,
+		"sender_id":  bson.M{"$ne": userID},
+		"created_at": bson.M{"$gt": after, "$lte": upTo},
+	})
+}
+
+// AdvanceStatus moves messages forward to status. Messages already at or past it are
+// left untouched, so a late delivery receipt never downgrades a read message.
+func (s *MessageStore) AdvanceStatus(ctx context.Context, ids []bson.ObjectID, status coreTypes.Status) (int64, error) {
+	return db.UpdateMany(
+		s.dbRepo,
+		ctx,
+		"messages",
+		bson.M{"_id": bson.M{"$in": ids}, "status": bson.M{"$in": statusesBefore(status)}},
+		bson.M{"$set": bson.M{"status": status}},
+	)
+}
+
+// MarkDelivered records that userID received the messages. Recording it twice is
+// harmless, so redelivered receipts can apply it again.
+func (s *MessageStore) MarkDelivered(ctx context.Context, ids []bson.ObjectID, userID string) error {
+	_, err := db.UpdateMany(
+		s.dbRepo,
+		ctx,
+		"messages",
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$addToSet": bson.M{"delivered_to": userID}},
+	)
+	return err
+}
+
+// ListPending returns, oldest first, the messages other members sent to the given rooms
+// that userID did not receive yet, whether or not other members of the room did.
+func (s *MessageStore) ListPending(ctx context.Context, roomIDs []bson.ObjectID, userID string, limit int) ([]types.Message, error) {
+	return s.List(
+		ctx,
+		bson.M{
+			"room_id":      bson.M{"$in": roomIDs},
+			"sender_id":    bson.M{"$ne": userID},
+			"delivered_to": bson.M{"$ne": userID},
+			"deleted_for":  bson.M{"$ne": userID},
+		},
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetLimit(int64(limit)),
+	)
+}
+
+// ListAfter returns, oldest first, the messages of the given rooms that come after
+// after in (created_at, _id) order, except those userID deleted for themselves.
+func (s *MessageStore) ListAfter(ctx context.Context, roomIDs []bson.ObjectID, userID string, after types.Message, limit int) ([]types.Message, error) {
+	return s.List(
+		ctx,
+		bson.M{
+			"room_id":     bson.M{"$in": roomIDs},
+			"deleted_for": bson.M{"$ne": userID},
+			"$or": bson.A{
+				bson.M{"created_at": bson.M{"$gt": after.CreatedAt}},
+				bson.M{"created_at": after.CreatedAt, "_id": bson.M{"$gt": after.ID}},
+			},
+		},
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetLimit(int64(limit)),
+	)
+}
+
+func (s *MessageStore) GetByID(ctx context.Context, messageID string) (*types.Message, error) {
+	id, err := bson.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.GetByFilter[types.Message](s.dbRepo, ctx, "messages", bson.M{"_id": id})
+}
+
+// GetByIdempotencyKey returns the message senderID sent with the given key.
+func (s *MessageStore) GetByIdempotencyKey(ctx context.Context, senderID, key string) (*types.Message, error) {
+	return db.GetByFilter[types.Message](s.dbRepo, ctx, "messages", bson.M{"sender_id": senderID, "idempotency_key": key})
+}
+
+// Edit replaces the content of message and keeps the previous one as a revision. The
+// update only applies if the message was neither edited nor deleted since it was read,
+// otherwise mongo.ErrNoDocuments is returned.
+//
+// The revision is saved first so a failed update never loses the previous content. It is
+// keyed by the message and the time its content was written, so saving it again on a
+// retry, or for a concurrent edit of the same content, keeps a single revision.
+func (s *MessageStore) Edit(ctx context.Context, message types.Message, content string, at time.Time) (*types.Message, error) {
+	writtenAt := message.CreatedAt
+	if message.UpdatedAt != nil {
+		writtenAt = *message.UpdatedAt
+	}
+
+	_, err := db.GetOrCreate(
+		s.dbRepo,
+		ctx,
+		"message_revisions",
+		bson.M{"message_id": message.ID, "written_at": writtenAt},
+		types.MessageRevision{
+			ID:        bson.NewObjectID(),
+			MessageID: message.ID,
+			Content:   message.Content,
+			WrittenAt: writtenAt,
+			EditedAt:  at,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	edited, err := db.FindOneAndUpdate[types.Message](
+		s.dbRepo,
+		ctx,
+		"messages",
+		bson.M{"_id": message.ID, "content": message.Content, "updated_at": message.UpdatedAt, "deleted_at": nil},
+		bson.M{"$set": bson.M{"content": content, "updated_at": at}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.refreshQuotes(ctx, *edited); err != nil {
+		return nil, err
+	}
+
+	return edited, nil
+}
+
+// DeleteForEveryone clears the content and the attachments of a message and drops its
+// revisions, leaving a tombstone in the room history. Its attachments are withdrawn
+// first, so they can no longer be downloaded nor attached again even when the deletion
+// fails half-way and is retried.
+func (s *MessageStore) DeleteForEveryone(ctx context.Context, messageID bson.ObjectID, at time.Time) (*types.Message, error) {
+	current, err := db.GetByFilter[types.Message](s.dbRepo, ctx, "messages", bson.M{"_id": messageID, "deleted_at": nil})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(current.Attachments) > 0 {
+		attachmentIDs := make([]bson.ObjectID, len(current.Attachments))
+		for i, attachment := range current.Attachments {
+			attachmentIDs[i] = attachment.ID
+		}
+		if _, err := db.UpdateMany(
+			s.dbRepo,
+			ctx,
+			"attachments",
+			bson.M{"_id": bson.M{"$in": attachmentIDs}},
+			bson.M{"$set": bson.M{"deleted_at": at}},
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	message, err := db.FindOneAndUpdate[types.Message](
+		s.dbRepo,
+		ctx,
+		"messages",
+		bson.M{"_id": messageID, "deleted_at": nil},
+		bson.M{"$set": bson.M{"content": "", "deleted_at": at}, "$unset": bson.M{"attachments": ""}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.DeleteMany(s.dbRepo, ctx, "message_revisions", bson.M{"message_id": messageID}); err != nil {
+		return nil, err
+	}
+
+	if err := s.refreshQuotes(ctx, *message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// refreshQuotes copies the current content of message into the quotes of its replies.
+func (s *MessageStore) refreshQuotes(ctx context.Context, message types.Message) error {
+	_, err := db.UpdateMany(
+		s.dbRepo,
+		ctx,
+		"messages",
+		bson.M{"quote.message_id": message.ID},
+		bson.M{"$set": bson.M{"quote": types.QuoteOf(message)}},
+	)
+	return err
+}
+
+// RecountReplies sets the reply count of rootID to the number of replies in its thread.
+// Counting again is harmless, so it can run again for a redelivered reply. The count
+// never goes back, as a recount racing with a newer reply may finish last.
+func (s *MessageStore) RecountReplies(ctx context.Context, rootID bson.ObjectID) error {
+	count, err := db.Count(s.dbRepo, ctx, "messages", bson.M{"thread_id": rootID})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.UpdateOne(
+		s.dbRepo,
+		ctx,
+		"messages",
+		bson.M{"_id": rootID},
+		bson.M{"$max": bson.M{"reply_count": count}},
+	)
+	return err
+}
+
+// HideFor removes a message from the history userID sees.
+func (s *MessageStore) HideFor(ctx context.Context, messageID bson.ObjectID, userID string) error {
+	_, err := db.UpdateOne(
+		s.dbRepo,
+		ctx,
+		"messages",
+		bson.M{"_id": messageID},
+		bson.M{"$addToSet": bson.M{"deleted_for": userID}},
+	)
+	return err
+}
+
+// ListRevisions returns the previous contents of a message, oldest first.
+func (s *MessageStore) ListRevisions(ctx context.Context, messageID bson.ObjectID) ([]types.MessageRevision, error) {
+	return db.List[types.MessageRevision](
+		s.dbRepo,
+		ctx,
+		"message_revisions",
+		bson.M{"message_id": messageID},
+		options.Find().SetSort(bson.D{{Key: "edited_at", Value: 1}}),
+	)
+}
+
+// AddReaction records a reaction and adds it to the message. It reports false when the
+// user already reacted to the message with the same emoji.
+//
+// The reaction is added to the message even when it was already recorded, so a retry
+// completes an earlier attempt that failed in between.
+func (s *MessageStore) AddReaction(ctx context.Context, reaction types.Reaction) (bool, error) {
+	_, err := db.Add(s.dbRepo, ctx, "message_reactions", reaction)
+	recorded := err == nil
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return false, err
+	}
+
+	added, err := db.UpdateOne(
+		s.dbRepo,
+		ctx,
+		"messages",
+		bson.M{"_id": reaction.MessageID},
+		bson.M{"$addToSet": bson.M{"reactions." + reaction.Emoji: reaction.UserID}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return recorded || added > 0, nil
+}
+
+// RemoveReaction removes a reaction from the message. It reports false when the user had
+// not reacted to the message with this emoji.
+//
+// Like AddReaction, the message is updated even when the reaction was already removed.
+func (s *MessageStore) RemoveReaction(ctx context.Context, messageID bson.ObjectID, userID, emoji string) (bool, error) {
+	deleted, err := db.DeleteOne(s.dbRepo, ctx, "message_reactions", bson.M{
+		"message_id": messageID,
+		"user_id":    userID,
+		"emoji":      emoji,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	field := "reactions." + emoji
+	pulled, err := db.UpdateOne(
+		s.dbRepo,
+		ctx,
+		"messages",
+		bson.M{"_id": messageID},
+		bson.M{"$pull": bson.M{field: userID}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	// Emoji nobody reacts with any more are dropped from the message.
+	_, err = db.UpdateOne(
+		s.dbRepo,
+		ctx,
+		"messages",
+		bson.M{"_id": messageID, field: bson.M{"$size": 0}},
+		bson.M{"$unset": bson.M{field: ""}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return deleted > 0 || pulled > 0, nil
+}
+
+// statusesBefore lists the statuses a message goes through before reaching status.
+func statusesBefore(status coreTypes.Status) []coreTypes.Status {
+	order := []coreTypes.Status{coreTypes.StatusSent, coreTypes.StatusDelivered, coreTypes.StatusRead}
+	index := slices.Index(order, status)
+	if index < 0 {
+		return nil
+	}
+	return order[:index]
+}
@@ -0,0 +1,138 @@
+package message
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/db"
+	"github.com/hoyci/ms-chat/message-service/testutils/mongotest"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestStatusesBefore(t *testing.T) {
+	tests := []struct {
+		status coreTypes.Status
+		want   []coreTypes.Status
+	}{
+		{status: coreTypes.StatusSent, want: []coreTypes.Status{}},
+		{status: coreTypes.StatusDelivered, want: []coreTypes.Status{coreTypes.StatusSent}},
+		{status: coreTypes.StatusRead, want: []coreTypes.Status{coreTypes.StatusSent, coreTypes.StatusDelivered}},
+		{status: "pending", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run("it should list the statuses before "+string(tt.status), func(t *testing.T) {
+			assert.Equal(t, tt.want, statusesBefore(tt.status))
+		})
+	}
+
+	t.Run("it should only ever move a message forward", func(t *testing.T) {
+		order := []coreTypes.Status{coreTypes.StatusSent, coreTypes.StatusDelivered, coreTypes.StatusRead}
+		for from, current := range order {
+			for to, target := range order {
+				assert.Equal(t, from < to, slices.Contains(statusesBefore(target), current), "%s to %s", current, target)
+			}
+		}
+	})
+}
+
+func TestAdvanceStatus(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*MessageStore, map[coreTypes.Status]bson.ObjectID) {
+		store := &MessageStore{dbRepo: mongotest.NewRepository(t)}
+		ids := make(map[coreTypes.Status]bson.ObjectID)
+		for _, status := range []coreTypes.Status{coreTypes.StatusSent, coreTypes.StatusDelivered, coreTypes.StatusRead} {
+			ids[status] = bson.NewObjectID()
+			_, err := db.Add(store.dbRepo, ctx, "messages", types.Message{ID: ids[status], Status: status})
+			assert.NoError(t, err)
+		}
+		return store, ids
+	}
+
+	statusOf := func(t *testing.T, store *MessageStore, id bson.ObjectID) coreTypes.Status {
+		message, err := store.GetByID(ctx, id.Hex())
+		assert.NoError(t, err)
+		return message.Status
+	}
+
+	tests := []struct {
+		name    string
+		target  coreTypes.Status
+		changed int64
+		want    map[coreTypes.Status]coreTypes.Status
+	}{
+		{
+			name:    "it should mark sent messages as delivered and leave read ones read",
+			target:  coreTypes.StatusDelivered,
+			changed: 1,
+			want: map[coreTypes.Status]coreTypes.Status{
+				coreTypes.StatusSent:      coreTypes.StatusDelivered,
+				coreTypes.StatusDelivered: coreTypes.StatusDelivered,
+				coreTypes.StatusRead:      coreTypes.StatusRead,
+			},
+		},
+		{
+			name:    "it should mark sent and delivered messages as read",
+			target:  coreTypes.StatusRead,
+			changed: 2,
+			want: map[coreTypes.Status]coreTypes.Status{
+				coreTypes.StatusSent:      coreTypes.StatusRead,
+				coreTypes.StatusDelivered: coreTypes.StatusRead,
+				coreTypes.StatusRead:      coreTypes.StatusRead,
+			},
+		},
+		{
+			name:    "it should never move a message back to sent",
+			target:  coreTypes.StatusSent,
+			changed: 0,
+			want: map[coreTypes.Status]coreTypes.Status{
+				coreTypes.StatusSent:      coreTypes.StatusSent,
+				coreTypes.StatusDelivered: coreTypes.StatusDelivered,
+				coreTypes.StatusRead:      coreTypes.StatusRead,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, ids := setup(t)
+			all := []bson.ObjectID{ids[coreTypes.StatusSent], ids[coreTypes.StatusDelivered], ids[coreTypes.StatusRead]}
+
+			changed, err := store.AdvanceStatus(ctx, all, tt.target)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.changed, changed)
+			for initial, want := range tt.want {
+				assert.Equal(t, want, statusOf(t, store, ids[initial]), "message initially %s", initial)
+			}
+		})
+	}
+}
+
+func TestDeleteForEveryone(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it should withdraw the attachments of the message", func(t *testing.T) {
+		store := &MessageStore{dbRepo: mongotest.NewRepository(t)}
+		attachment := types.Attachment{ID: bson.NewObjectID(), Filename: "plan.pdf", StorageKey: "plan"}
+		_, err := db.Add(store.dbRepo, ctx, "attachments", attachment)
+		assert.NoError(t, err)
+		message := types.Message{ID: bson.NewObjectID(), Content: "The plan", Attachments: []types.Attachment{attachment}}
+		_, err = db.Add(store.dbRepo, ctx, "messages", message)
+		assert.NoError(t, err)
+
+		deleted, err := store.DeleteForEveryone(ctx, message.ID, time.Now())
+
+		assert.NoError(t, err)
+		assert.Empty(t, deleted.Attachments)
+		_, err = db.GetByFilter[types.Attachment](store.dbRepo, ctx, "attachments", bson.M{"_id": attachment.ID, "deleted_at": nil})
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	})
+}
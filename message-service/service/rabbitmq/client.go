@@ -3,94 +3,206 @@ package rabbitmq
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
+	"github.com/hoyci/ms-chat/core/broker"
+	"github.com/hoyci/ms-chat/core/messaging"
 	coreTypes "github.com/hoyci/ms-chat/core/types"
 	"github.com/hoyci/ms-chat/message-service/config"
-	"github.com/hoyci/ms-chat/message-service/db"
-	"github.com/hoyci/ms-chat/message-service/service/message"
-	"github.com/hoyci/ms-chat/message-service/service/room"
-	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/hoyci/ms-chat/message-service/types"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
 type MessageProcessor func(ctx context.Context, body []byte) error
 
-var conn *amqp.Connection
-var channel *amqp.Channel
-var dbRepo *db.MongoRepository
+var manager *broker.Manager
 
-func Init(repo *db.MongoRepository) {
-	dbRepo = repo
+// Init connects to RabbitMQ, waiting for the broker to be up, and keeps the connection
+// open from then on: the topology is declared again and the consumers are resumed after
+// every reconnection.
+func Init() {
+	manager = broker.NewManager(config.Envs.RabbitMQURL, broker.Backoff{
+		Initial: config.Envs.RabbitMQReconnectDelay,
+		Max:     config.Envs.RabbitMQReconnectMaxDelay,
+	})
+	manager.Declare(messaging.Topology(Queues()))
 
-	var err error
-	conn, err = amqp.Dial(config.Envs.RabbitMQURL)
-	if err != nil {
+	if err := manager.Start(context.Background()); err != nil {
 		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
 	}
+}
 
-	channel, err = conn.Channel()
-	if err != nil {
-		log.Fatalf("Failed to open channel: %v", err)
+// Queues names the queues message-service consumes and publishes to. The ephemeral
+// queue is declared by ws-service too, with the same TTL.
+func Queues() messaging.Queues {
+	return messaging.Queues{
+		Persistence:        config.Envs.PersistenceQueueName,
+		Broadcast:          config.Envs.BroadcastQueueName,
+		Ephemeral:          config.Envs.EphemeralQueueName,
+		EphemeralTTL:       config.Envs.TypingTimeout,
+		DeadLetterExchange: config.Envs.DeadLetterExchange,
+	}
+}
+
+// NewBus returns the bus of the connection opened by Init.
+func NewBus() messaging.Bus {
+	return messaging.NewAMQPBus(manager)
+}
+
+// Monitor reports the state of the connection to RabbitMQ to health checks.
+type Monitor struct{}
+
+func (Monitor) Status() broker.Status {
+	return manager.Status()
+}
+
+// Processor handles the events devices send through ws-service, answering them with
+// broadcasts published on its bus.
+type Processor struct {
+	rooms       types.RoomStore
+	messages    types.MessageStore
+	attachments types.AttachmentStore
+	broadcaster Broadcaster
+}
+
+func NewProcessor(bus messaging.Bus, rooms types.RoomStore, messages types.MessageStore, attachments types.AttachmentStore) *Processor {
+	return &Processor{
+		rooms:       rooms,
+		messages:    messages,
+		attachments: attachments,
+		broadcaster: NewBroadcaster(bus),
+	}
+}
+
+// PersistenceProcessors maps the events of the persistence queue to their processor.
+func (p *Processor) PersistenceProcessors() map[string]MessageProcessor {
+	return map[string]MessageProcessor{
+		coreTypes.EventChatMessage:   p.ProcessChatMessage,
+		coreTypes.EventReceipt:       p.ProcessReceipt,
+		coreTypes.EventSync:          p.ProcessSync,
+		coreTypes.EventMessageEdit:   p.ProcessMessageEdit,
+		coreTypes.EventMessageDelete: p.ProcessMessageDelete,
+		coreTypes.EventReaction:      p.ProcessReaction,
 	}
 }
 
-func GetChannel() *amqp.Channel {
-	return channel
+// EphemeralProcessors maps the events of the ephemeral queue to their processor.
+func (p *Processor) EphemeralProcessors() map[string]MessageProcessor {
+	return map[string]MessageProcessor{
+		coreTypes.EventTyping: p.ProcessTyping,
+	}
 }
 
-func ConsumeQueue(channel *amqp.Channel, queueName string, processor MessageProcessor) {
-	msgs, err := channel.Consume(
-		queueName,
-		"",
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
+func (p *Processor) ProcessChatMessage(ctx context.Context, msgBody []byte) error {
+	var wsMessage coreTypes.Message
+	if err := json.Unmarshal(msgBody, &wsMessage); err != nil {
+		return Permanent(fmt.Errorf("invalid chat message: %w", err))
+	}
 
+	var chatRoom *types.Room
+	var err error
+	if wsMessage.RoomID != "" {
+		chatRoom, err = p.rooms.GetByID(ctx, wsMessage.RoomID)
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, bson.ErrInvalidHex) {
+			log.Printf("Dropping message %s for unknown room %s", wsMessage.ID, wsMessage.RoomID)
+			return nil
+		}
+		if err != nil {
+			log.Printf("Error with GetRoomByID: %v", err)
+			return err
+		}
+
+		if !chatRoom.IsMember(wsMessage.SenderID) {
+			log.Printf("Dropping message %s: %s is not a member of room %s", wsMessage.ID, wsMessage.SenderID, wsMessage.RoomID)
+			return nil
+		}
+	} else {
+		chatRoom, err = p.rooms.GetOrCreate(ctx, []string{wsMessage.SenderID, wsMessage.ReceiverID})
+		if err != nil {
+			log.Printf("Error with GetOrCreateRoom: %v", err)
+			return err
+		}
+	}
+
+	// ws-service hands the message ID to the devices right away, so it is kept as the
+	// identity receipts refer to.
+	id, err := bson.ObjectIDFromHex(wsMessage.ID)
 	if err != nil {
-		log.Printf("Failed to start consumption from %s: %v", queueName, err)
+		id = bson.NewObjectID()
 	}
 
-	for msg := range msgs {
-		err := processor(context.Background(), msg.Body)
+	document := map[string]any{
+		"_id":         id,
+		"room_id":     chatRoom.ID,
+		"sender_id":   wsMessage.SenderID,
+		"receiver_id": wsMessage.ReceiverID,
+		"content":     wsMessage.Content,
+		"status":      wsMessage.Status,
+		"created_at":  wsMessage.CreatedAt,
+		"updated_at":  nil,
+		"deleted_at":  nil,
+	}
+	if wsMessage.IdempotencyKey != "" {
+		document["idempotency_key"] = wsMessage.IdempotencyKey
+	}
+
+	// The reference is only kept, and completed with the thread and quote, when it
+	// points to a message of the same room.
+	replyTo := wsMessage.ReplyTo
+	wsMessage.ReplyTo, wsMessage.ThreadID, wsMessage.Quote = "", "", nil
+
+	var parent *types.Message
+	if replyTo != "" {
+		parent, err = p.findReplyParent(ctx, chatRoom, replyTo)
 		if err != nil {
-			log.Printf("Error processing message: %v", err)
-			msg.Nack(false, true)
+			return err
+		}
+		if parent == nil {
+			log.Printf("Message %s answers %s which is not in room %s, keeping it as a plain message", wsMessage.ID, replyTo, chatRoom.ID.Hex())
 		}
-		msg.Ack(false)
 	}
-}
 
-func ProcessChatMessage(ctx context.Context, msgBody []byte) error {
-	var wsMessage coreTypes.Message
-	json.Unmarshal(msgBody, &wsMessage)
+	if parent != nil {
+		threadID := parent.ID
+		if parent.ThreadID != nil {
+			threadID = *parent.ThreadID
+		}
+		quote := types.QuoteOf(*parent)
+
+		document["reply_to"] = parent.ID
+		document["thread_id"] = threadID
+		document["quote"] = quote
 
-	roomStore := room.GetRoomStore(dbRepo)
-	messageStore := message.GetMessageStore(dbRepo)
+		reply := types.Message{ReplyTo: &parent.ID, ThreadID: &threadID, Quote: quote}.ToCore()
+		wsMessage.ReplyTo, wsMessage.ThreadID, wsMessage.Quote = reply.ReplyTo, reply.ThreadID, reply.Quote
+	}
 
-	room, err := roomStore.GetOrCreate(context.Background(), []int{wsMessage.SenderID, wsMessage.ReceiverID})
+	// Attachments are only kept when the sender uploaded them to this room; the message
+	// then carries their description so it can be rendered without fetching them.
+	attachments, err := p.resolveAttachments(ctx, chatRoom, wsMessage.SenderID, wsMessage.Attachments)
 	if err != nil {
-		log.Printf("Error with GetOrCreateRoom: %v", err)
 		return err
 	}
+	if len(attachments) != len(wsMessage.Attachments) {
+		log.Printf("Message %s refers to attachments not uploaded by %s to room %s, dropping them", wsMessage.ID, wsMessage.SenderID, chatRoom.ID.Hex())
+	}
 
-	messageID, err := messageStore.Create(
-		context.Background(),
-		map[string]any{
-			"_id":         bson.NewObjectID(),
-			"room_id":     room.ID,
-			"sender_id":   wsMessage.SenderID,
-			"receiver_id": wsMessage.ReceiverID,
-			"content":     wsMessage.Content,
-			"status":      wsMessage.Status,
-			"created_at":  wsMessage.CreatedAt,
-			"updated_at":  nil,
-			"deleted_at":  nil,
-		},
-	)
+	wsMessage.Attachments = types.Message{Attachments: attachments}.ToCore().Attachments
+	if len(attachments) > 0 {
+		document["attachments"] = attachments
+	} else if wsMessage.Content == "" {
+		log.Printf("Dropping message %s without content nor attachments", wsMessage.ID)
+		return nil
+	}
+
+	messageID, err := p.messages.Create(ctx, document)
+	if mongo.IsDuplicateKeyError(err) {
+		return p.redeliverChatMessage(ctx, chatRoom, wsMessage, id)
+	}
 	if err != nil {
 		log.Printf("Error persisting message: %v", err)
 		return err
@@ -98,5 +210,144 @@ func ProcessChatMessage(ctx context.Context, msgBody []byte) error {
 
 	log.Printf("message: %s", messageID.Hex())
 
-	return nil
+	if parent != nil {
+		threadID, _ := bson.ObjectIDFromHex(wsMessage.ThreadID)
+		if err := p.messages.RecountReplies(ctx, threadID); err != nil {
+			log.Printf("Failed to count reply %s in thread %s: %v", messageID.Hex(), wsMessage.ThreadID, err)
+			return err
+		}
+	}
+
+	// Direct messages addressed by receiver_id are delivered by ws-service as soon as
+	// they arrive, unless they are replies or carry attachments: the quote and the
+	// attachment descriptions are only known here. Messages addressed to a room can only
+	// be fanned out once the room members are known.
+	if wsMessage.RoomID == "" && parent == nil && len(attachments) == 0 {
+		return nil
+	}
+
+	wsMessage.ID = messageID.Hex()
+	wsMessage.RoomID = chatRoom.ID.Hex()
+	return p.broadcaster.PublishBroadcast(ctx, coreTypes.BroadcastMessage{
+		UserIDs:         chatRoom.Users,
+		Messages:        []coreTypes.Message{wsMessage},
+		ExcludeClientID: wsMessage.ClientID,
+		Timestamp:       time.Now(),
+	})
+}
+
+// redeliverChatMessage handles a message already persisted, either redelivered by
+// RabbitMQ or sent again by its client. It is not stored twice, but broadcast again in
+// case the first attempt failed before doing so; devices ignore messages they have.
+func (p *Processor) redeliverChatMessage(ctx context.Context, chatRoom *types.Room, wsMessage coreTypes.Message, id bson.ObjectID) error {
+	var stored *types.Message
+	var err error
+	if wsMessage.IdempotencyKey != "" {
+		stored, err = p.messages.GetByIdempotencyKey(ctx, wsMessage.SenderID, wsMessage.IdempotencyKey)
+	}
+	if wsMessage.IdempotencyKey == "" || errors.Is(err, mongo.ErrNoDocuments) {
+		stored, err = p.messages.GetByID(ctx, id.Hex())
+	}
+	if err != nil {
+		log.Printf("Failed to load the stored copy of message %s: %v", wsMessage.ID, err)
+		return err
+	}
+
+	log.Printf("Message %s was already persisted as %s", wsMessage.ID, stored.ID.Hex())
+
+	// The first attempt may have failed before counting the reply.
+	if stored.ThreadID != nil {
+		if err := p.messages.RecountReplies(ctx, *stored.ThreadID); err != nil {
+			log.Printf("Failed to count reply %s in thread %s: %v", stored.ID.Hex(), stored.ThreadID.Hex(), err)
+			return err
+		}
+	}
+
+	// A retry ws-service could not recognize was acknowledged with another ID: the
+	// sending device then gets the stored message too, and matches it to the message
+	// it sent through its idempotency key.
+	excludeClientID := wsMessage.ClientID
+	if stored.ID.Hex() != wsMessage.ID {
+		excludeClientID = ""
+	} else if wsMessage.RoomID == "" && stored.ReplyTo == nil && len(stored.Attachments) == 0 {
+		return nil
+	}
+
+	return p.broadcaster.PublishBroadcast(ctx, coreTypes.BroadcastMessage{
+		UserIDs:         chatRoom.Users,
+		Messages:        []coreTypes.Message{stored.ToCore()},
+		ExcludeClientID: excludeClientID,
+		Timestamp:       time.Now(),
+	})
+}
+
+// findReplyParent returns the message a reply answers, or nil when it is not a message
+// of the room the reply is posted in.
+func (p *Processor) findReplyParent(ctx context.Context, chatRoom *types.Room, replyTo string) (*types.Message, error) {
+	parent, err := p.messages.GetByID(ctx, replyTo)
+	if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, bson.ErrInvalidHex) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if parent.RoomID != chatRoom.ID {
+		return nil, nil
+	}
+
+	return parent, nil
+}
+
+// resolveAttachments looks up the attachments a message refers to, keeping, in the order
+// given, those senderID uploaded to chatRoom.
+func (p *Processor) resolveAttachments(ctx context.Context, chatRoom *types.Room, senderID string, refs []coreTypes.Attachment) ([]types.Attachment, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	var ids []bson.ObjectID
+	for _, ref := range refs {
+		if id, err := bson.ObjectIDFromHex(ref.ID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	found, err := p.attachments.ListByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[bson.ObjectID]types.Attachment, len(found))
+	for _, a := range found {
+		if a.RoomID == chatRoom.ID && a.UploaderID == senderID {
+			byID[a.ID] = a
+		}
+	}
+
+	var attachments []types.Attachment
+	for _, id := range ids {
+		if a, ok := byID[id]; ok {
+			attachments = append(attachments, a)
+			delete(byID, id)
+		}
+	}
+	return attachments, nil
+}
+
+// Broadcaster hands broadcasts to ws-service through a bus.
+type Broadcaster struct {
+	bus messaging.Bus
+}
+
+func NewBroadcaster(bus messaging.Bus) Broadcaster {
+	return Broadcaster{bus: bus}
+}
+
+// PublishBroadcast asks ws-service to deliver messages to the devices of the given users.
+func (b Broadcaster) PublishBroadcast(ctx context.Context, broadcast coreTypes.BroadcastMessage) error {
+	return messaging.Broadcast.Publish(ctx, b.bus, broadcast)
 }
@@ -0,0 +1,76 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/mocks"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestProcessChatMessageReplies(t *testing.T) {
+	roomID := bson.NewObjectID()
+	chatRoom := &types.Room{ID: roomID, Users: []string{"user-1", "user-2"}}
+	root := &types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: "user-2", Content: "Lunch?"}
+	replyID := bson.NewObjectID()
+
+	replyBody := func() []byte {
+		body, _ := json.Marshal(coreTypes.Message{
+			ID:        replyID.Hex(),
+			RoomID:    roomID.Hex(),
+			SenderID:  "user-1",
+			Content:   "Sure",
+			ReplyTo:   root.ID.Hex(),
+			CreatedAt: time.Now(),
+		})
+		return body
+	}
+
+	setup := func() (*mocks.MockMessageStore, *Processor) {
+		rooms := new(mocks.MockRoomStore)
+		messages := new(mocks.MockMessageStore)
+		rooms.On("GetByID", mock.Anything, roomID.Hex()).Return(chatRoom, nil)
+		messages.On("GetByID", mock.Anything, root.ID.Hex()).Return(root, nil)
+		bus := messaging.NewMemoryBus(Queues())
+		return messages, NewProcessor(bus, rooms, messages, new(mocks.MockAttachmentStore))
+	}
+
+	t.Run("it should fail so the reply is retried when its thread cannot be counted", func(t *testing.T) {
+		messages, processor := setup()
+		messages.On("Create", mock.Anything, mock.Anything).Return(replyID, nil)
+		messages.On("RecountReplies", mock.Anything, root.ID).Return(errors.New("connection reset"))
+
+		err := processor.ProcessChatMessage(context.Background(), replyBody())
+
+		assert.EqualError(t, err, "connection reset")
+	})
+
+	t.Run("it should count the thread again when the reply is redelivered", func(t *testing.T) {
+		messages, processor := setup()
+		duplicate := mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}
+		messages.On("Create", mock.Anything, mock.Anything).Return(bson.ObjectID{}, duplicate)
+		messages.On("GetByID", mock.Anything, replyID.Hex()).Return(&types.Message{
+			ID:       replyID,
+			RoomID:   roomID,
+			SenderID: "user-1",
+			Content:  "Sure",
+			ReplyTo:  &root.ID,
+			ThreadID: &root.ID,
+		}, nil)
+		messages.On("RecountReplies", mock.Anything, root.ID).Return(nil)
+
+		err := processor.ProcessChatMessage(context.Background(), replyBody())
+
+		assert.NoError(t, err)
+		messages.AssertExpectations(t)
+	})
+}
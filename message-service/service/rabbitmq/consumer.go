@@ -0,0 +1,235 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	"github.com/hoyci/ms-chat/message-service/config"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers set on the deliveries the consumer republishes.
+const (
+	headerRetryCount    = "x-retry-count"
+	headerOriginalQueue = "x-original-queue"
+	headerError         = "x-error"
+	headerFailedAt      = "x-failed-at"
+)
+
+// A delivery whose processing fails is acknowledged only once it has been republished:
+// to the retry queue of its queue, which hands it back after RetryDelay, until it failed
+// MaxRetries times, and to the dead-letter queue after that. Dead letters are kept for
+// inspection and replay with cmd/deadletters.
+
+// RetryPolicy tells how the deliveries of a queue are processed. Without DeadLetter,
+// deliveries that cannot be processed are dropped.
+type RetryPolicy struct {
+	Prefetch   int
+	Timeout    time.Duration
+	MaxRetries int
+	RetryDelay time.Duration
+	DeadLetter bool
+}
+
+// DefaultRetryPolicy is the policy of queues whose deliveries must not be lost.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Prefetch:   config.Envs.ConsumerPrefetch,
+		Timeout:    config.Envs.ConsumerTimeout,
+		MaxRetries: config.Envs.ConsumerMaxRetries,
+		RetryDelay: config.Envs.ConsumerRetryDelay,
+		DeadLetter: true,
+	}
+}
+
+// permanentError marks failures retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error a processor returns for a delivery that will never be
+// processed, such as a malformed one, so it is dead-lettered without being retried.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// publisher republishes deliveries to the retry and dead-letter queues.
+type publisher interface {
+	Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+}
+
+// confirmingPublisher publishes on a channel in confirm mode and waits for the broker
+// to take each publishing in charge.
+type confirmingPublisher struct {
+	mu      sync.Mutex
+	channel *amqp.Channel
+}
+
+func newConfirmingPublisher(channel *amqp.Channel) (*confirmingPublisher, error) {
+	if err := channel.Confirm(false); err != nil {
+		return nil, err
+	}
+	return &confirmingPublisher{channel: channel}, nil
+}
+
+func (p *confirmingPublisher) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	confirmation, err := p.channel.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, msg)
+	if err != nil {
+		return err
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("broker refused the publishing to %q", key)
+	}
+	return nil
+}
+
+// consumer processes the deliveries of one queue.
+type consumer struct {
+	queueName  string
+	processors map[string]MessageProcessor
+	policy     RetryPolicy
+	publisher  publisher
+}
+
+// ConsumeQueue dispatches the deliveries of a queue to the processor registered for
+// their type, republishing the failed ones on bus to the retry and dead-letter queues
+// declared with the topology. It returns once ctx is done.
+func ConsumeQueue(ctx context.Context, bus messaging.Bus, queueName string, processors map[string]MessageProcessor, policy RetryPolicy) error {
+	deliveries, err := bus.Consume(ctx, queueName, policy.Prefetch)
+	if err != nil {
+		return err
+	}
+
+	c := &consumer{queueName: queueName, processors: processors, policy: policy, publisher: bus}
+	for delivery := range deliveries {
+		c.handle(delivery)
+	}
+
+	return ctx.Err()
+}
+
+// handle processes a delivery within the timeout of the policy and settles it.
+func (c *consumer) handle(delivery amqp.Delivery) {
+	processor, ok := c.processors[delivery.Type]
+	if !ok {
+		c.deadLetter(delivery, Permanent(fmt.Errorf("unknown delivery type %q", delivery.Type)))
+		return
+	}
+
+	// Deliveries following a newer schema wait in the dead-letter queue to be replayed
+	// once this service knows it.
+	if err := messaging.CheckVersion(delivery); err != nil {
+		c.deadLetter(delivery, Permanent(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.policy.Timeout)
+	err := processor(ctx, delivery.Body)
+	cancel()
+
+	if err == nil {
+		delivery.Ack(false)
+		return
+	}
+
+	var permanent permanentError
+	retries := retryCount(delivery.Headers)
+	if errors.As(err, &permanent) || retries >= c.policy.MaxRetries {
+		c.deadLetter(delivery, err)
+		return
+	}
+
+	c.retry(delivery, retries+1, err)
+}
+
+func (c *consumer) retry(delivery amqp.Delivery, attempt int, cause error) {
+	log.Printf("Retrying %s delivery from %s (attempt %d of %d): %v", delivery.Type, c.queueName, attempt, c.policy.MaxRetries, cause)
+
+	msg := republishing(delivery)
+	msg.Headers[headerRetryCount] = int32(attempt)
+	msg.Expiration = strconv.FormatInt(c.policy.RetryDelay.Milliseconds(), 10)
+
+	c.republish(delivery, "", messaging.RetryQueueName(c.queueName), msg)
+}
+
+func (c *consumer) deadLetter(delivery amqp.Delivery, cause error) {
+	if !c.policy.DeadLetter {
+		log.Printf("Dropping %s delivery from %s: %v", delivery.Type, c.queueName, cause)
+		delivery.Ack(false)
+		return
+	}
+
+	log.Printf("Dead-lettering %s delivery from %s: %v", delivery.Type, c.queueName, cause)
+
+	msg := republishing(delivery)
+	msg.Headers[headerOriginalQueue] = c.queueName
+	msg.Headers[headerError] = cause.Error()
+	msg.Headers[headerFailedAt] = time.Now().UTC().Format(time.RFC3339)
+
+	c.republish(delivery, config.Envs.DeadLetterExchange, c.queueName, msg)
+}
+
+// republish acknowledges a delivery once its copy is published, and requeues it when
+// the copy could not be.
+func (c *consumer) republish(delivery amqp.Delivery, exchange, key string, msg amqp.Publishing) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.policy.Timeout)
+	defer cancel()
+
+	if err := c.publisher.Publish(ctx, exchange, key, msg); err != nil {
+		log.Printf("Failed to republish %s delivery from %s, requeueing it: %v", delivery.Type, c.queueName, err)
+		delivery.Nack(false, true)
+		return
+	}
+
+	delivery.Ack(false)
+}
+
+// republishing copies a delivery into a persistent publishing.
+func republishing(delivery amqp.Delivery) amqp.Publishing {
+	headers := amqp.Table{}
+	maps.Copy(headers, delivery.Headers)
+
+	return amqp.Publishing{
+		Headers:       headers,
+		ContentType:   delivery.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: delivery.CorrelationId,
+		MessageId:     delivery.MessageId,
+		Timestamp:     delivery.Timestamp,
+		Type:          delivery.Type,
+		AppId:         delivery.AppId,
+		Body:          delivery.Body,
+	}
+}
+
+// retryCount reads how many times a delivery was retried already.
+func retryCount(headers amqp.Table) int {
+	switch count := headers[headerRetryCount].(type) {
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	case int:
+		return count
+	default:
+		return 0
+	}
+}
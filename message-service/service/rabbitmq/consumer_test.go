@@ -0,0 +1,247 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAcknowledger records how deliveries were settled.
+type fakeAcknowledger struct {
+	acked   int
+	nacked  int
+	requeue bool
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked++
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.nacked++
+	a.requeue = requeue
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+type publishing struct {
+	exchange string
+	key      string
+	msg      amqp.Publishing
+}
+
+// fakePublisher records republished deliveries, failing with err when set.
+type fakePublisher struct {
+	published []publishing
+	err       error
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.published = append(p.published, publishing{exchange: exchange, key: key, msg: msg})
+	return nil
+}
+
+func newTestConsumer(processor MessageProcessor, policy RetryPolicy) (*consumer, *fakePublisher) {
+	publisher := &fakePublisher{}
+	return &consumer{
+		queueName:  "persistence_queue",
+		processors: map[string]MessageProcessor{"chat.message": processor},
+		policy:     policy,
+		publisher:  publisher,
+	}, publisher
+}
+
+func newDelivery(ack *fakeAcknowledger, headers amqp.Table) amqp.Delivery {
+	return amqp.Delivery{
+		Acknowledger: ack,
+		Type:         "chat.message",
+		Headers:      headers,
+		ContentType:  "application/json",
+		Body:         []byte(`{"content":"Hi"}`),
+	}
+}
+
+var testPolicy = RetryPolicy{Timeout: time.Second, MaxRetries: 2, RetryDelay: 5 * time.Second, DeadLetter: true}
+
+func TestConsumerHandle(t *testing.T) {
+	t.Run("it should acknowledge deliveries processed successfully", func(t *testing.T) {
+		c, publisher := newTestConsumer(func(ctx context.Context, body []byte) error { return nil }, testPolicy)
+		ack := &fakeAcknowledger{}
+
+		c.handle(newDelivery(ack, nil))
+
+		assert.Equal(t, 1, ack.acked)
+		assert.Zero(t, ack.nacked)
+		assert.Empty(t, publisher.published)
+	})
+
+	t.Run("it should hand failed deliveries to the retry queue with their attempt", func(t *testing.T) {
+		c, publisher := newTestConsumer(func(ctx context.Context, body []byte) error { return errors.New("mongo down") }, testPolicy)
+		ack := &fakeAcknowledger{}
+
+		c.handle(newDelivery(ack, amqp.Table{"persistence": "true"}))
+
+		assert.Equal(t, 1, ack.acked)
+		assert.Len(t, publisher.published, 1)
+		retry := publisher.published[0]
+		assert.Equal(t, "", retry.exchange)
+		assert.Equal(t, "persistence_queue.retry", retry.key)
+		assert.Equal(t, "5000", retry.msg.Expiration)
+		assert.Equal(t, int32(1), retry.msg.Headers[headerRetryCount])
+		assert.Equal(t, "true", retry.msg.Headers["persistence"])
+		assert.Equal(t, "chat.message", retry.msg.Type)
+		assert.Equal(t, amqp.Persistent, retry.msg.DeliveryMode)
+	})
+
+	t.Run("it should dead-letter deliveries that failed every retry", func(t *testing.T) {
+		c, publisher := newTestConsumer(func(ctx context.Context, body []byte) error { return errors.New("mongo down") }, testPolicy)
+		ack := &fakeAcknowledger{}
+
+		c.handle(newDelivery(ack, amqp.Table{headerRetryCount: int32(2)}))
+
+		assert.Equal(t, 1, ack.acked)
+		assert.Len(t, publisher.published, 1)
+		dead := publisher.published[0]
+		assert.Equal(t, "dead_letters", dead.exchange)
+		assert.Equal(t, "persistence_queue", dead.key)
+		assert.Equal(t, "mongo down", dead.msg.Headers[headerError])
+		assert.Equal(t, "persistence_queue", dead.msg.Headers[headerOriginalQueue])
+	})
+
+	t.Run("it should dead-letter permanent failures without retrying them", func(t *testing.T) {
+		c, publisher := newTestConsumer(func(ctx context.Context, body []byte) error {
+			return Permanent(errors.New("invalid chat message"))
+		}, testPolicy)
+		ack := &fakeAcknowledger{}
+
+		c.handle(newDelivery(ack, nil))
+
+		assert.Len(t, publisher.published, 1)
+		assert.Equal(t, "dead_letters", publisher.published[0].exchange)
+	})
+
+	t.Run("it should dead-letter deliveries of an unknown type", func(t *testing.T) {
+		c, publisher := newTestConsumer(func(ctx context.Context, body []byte) error { return nil }, testPolicy)
+		ack := &fakeAcknowledger{}
+		delivery := newDelivery(ack, nil)
+		delivery.Type = "unknown"
+
+		c.handle(delivery)
+
+		assert.Len(t, publisher.published, 1)
+		assert.Equal(t, `unknown delivery type "unknown"`, publisher.published[0].msg.Headers[headerError])
+	})
+
+	t.Run("it should dead-letter deliveries following a newer schema without processing them", func(t *testing.T) {
+		processed := false
+		c, publisher := newTestConsumer(func(ctx context.Context, body []byte) error {
+			processed = true
+			return nil
+		}, testPolicy)
+		ack := &fakeAcknowledger{}
+
+		c.handle(newDelivery(ack, amqp.Table{messaging.SchemaVersionHeader: int32(messaging.ChatMessage.Version + 1)}))
+
+		assert.False(t, processed)
+		assert.Len(t, publisher.published, 1)
+		assert.Equal(t, "dead_letters", publisher.published[0].exchange)
+		assert.Equal(t, 1, ack.acked)
+	})
+
+	t.Run("it should process deliveries of the current schema", func(t *testing.T) {
+		processed := false
+		c, publisher := newTestConsumer(func(ctx context.Context, body []byte) error {
+			processed = true
+			return nil
+		}, testPolicy)
+		ack := &fakeAcknowledger{}
+
+		c.handle(newDelivery(ack, amqp.Table{messaging.SchemaVersionHeader: int32(messaging.ChatMessage.Version)}))
+
+		assert.True(t, processed)
+		assert.Empty(t, publisher.published)
+		assert.Equal(t, 1, ack.acked)
+	})
+
+	t.Run("it should bound processing with the timeout of the policy", func(t *testing.T) {
+		policy := testPolicy
+		policy.Timeout = 10 * time.Millisecond
+		c, publisher := newTestConsumer(func(ctx context.Context, body []byte) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, policy)
+		ack := &fakeAcknowledger{}
+
+		c.handle(newDelivery(ack, nil))
+
+		assert.Len(t, publisher.published, 1)
+		assert.Equal(t, "persistence_queue.retry", publisher.published[0].key)
+	})
+
+	t.Run("it should requeue deliveries it could not republish", func(t *testing.T) {
+		c, publisher := newTestConsumer(func(ctx context.Context, body []byte) error { return errors.New("mongo down") }, testPolicy)
+		publisher.err = errors.New("channel closed")
+		ack := &fakeAcknowledger{}
+
+		c.handle(newDelivery(ack, nil))
+
+		assert.Zero(t, ack.acked)
+		assert.Equal(t, 1, ack.nacked)
+		assert.True(t, ack.requeue)
+	})
+
+	t.Run("it should drop failed deliveries when the policy keeps no dead letters", func(t *testing.T) {
+		c, publisher := newTestConsumer(func(ctx context.Context, body []byte) error { return errors.New("stale") }, RetryPolicy{Timeout: time.Second})
+		ack := &fakeAcknowledger{}
+
+		c.handle(newDelivery(ack, nil))
+
+		assert.Equal(t, 1, ack.acked)
+		assert.Empty(t, publisher.published)
+	})
+}
+
+func TestReplayPublishing(t *testing.T) {
+	t.Run("it should send dead letters back to their queue without their failure headers", func(t *testing.T) {
+		delivery := newDelivery(&fakeAcknowledger{}, amqp.Table{
+			"persistence":       "true",
+			headerRetryCount:    int32(5),
+			headerOriginalQueue: "persistence_queue",
+			headerError:         "mongo down",
+			headerFailedAt:      "2024-05-01T12:00:00Z",
+		})
+
+		key, msg := replayPublishing(delivery, "other_queue")
+
+		assert.Equal(t, "persistence_queue", key)
+		assert.Equal(t, amqp.Table{"persistence": "true"}, msg.Headers)
+		assert.Equal(t, delivery.Body, msg.Body)
+		assert.Equal(t, "chat.message", msg.Type)
+	})
+
+	t.Run("it should describe dead letters with their failure", func(t *testing.T) {
+		delivery := newDelivery(&fakeAcknowledger{}, amqp.Table{
+			headerRetryCount:    int32(5),
+			headerOriginalQueue: "persistence_queue",
+			headerError:         "mongo down",
+		})
+
+		deadLetter := deadLetterOf(delivery)
+
+		assert.Equal(t, 5, deadLetter.Retries)
+		assert.Equal(t, "mongo down", deadLetter.Error)
+		assert.JSONEq(t, `{"content":"Hi"}`, string(deadLetter.Body))
+	})
+}
@@ -0,0 +1,117 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DeadLetter is a delivery kept in a dead-letter queue after its processing failed.
+type DeadLetter struct {
+	Type          string          `json:"type"`
+	OriginalQueue string          `json:"original_queue"`
+	Error         string          `json:"error"`
+	FailedAt      string          `json:"failed_at"`
+	Retries       int             `json:"retries"`
+	Body          json.RawMessage `json:"body"`
+}
+
+func deadLetterOf(delivery amqp.Delivery) DeadLetter {
+	deadLetter := DeadLetter{
+		Type:    delivery.Type,
+		Retries: retryCount(delivery.Headers),
+		Body:    delivery.Body,
+	}
+	deadLetter.OriginalQueue, _ = delivery.Headers[headerOriginalQueue].(string)
+	deadLetter.Error, _ = delivery.Headers[headerError].(string)
+	deadLetter.FailedAt, _ = delivery.Headers[headerFailedAt].(string)
+
+	if !json.Valid(delivery.Body) {
+		deadLetter.Body, _ = json.Marshal(string(delivery.Body))
+	}
+	return deadLetter
+}
+
+// InspectDeadLetters returns up to limit dead letters of a queue, oldest first, and
+// leaves them in the dead-letter queue.
+func InspectDeadLetters(channel *amqp.Channel, queueName string, limit int) ([]DeadLetter, error) {
+	var deadLetters []DeadLetter
+	var last *amqp.Delivery
+
+	// Deliveries fetched are held until the end, so each get returns the next one.
+	defer func() {
+		if last != nil {
+			last.Nack(true, true)
+		}
+	}()
+
+	for len(deadLetters) < limit {
+		delivery, ok, err := channel.Get(messaging.DeadLetterQueueName(queueName), false)
+		if err != nil {
+			return deadLetters, err
+		}
+		if !ok {
+			break
+		}
+
+		last = &delivery
+		deadLetters = append(deadLetters, deadLetterOf(delivery))
+	}
+
+	return deadLetters, nil
+}
+
+// ReplayDeadLetters hands up to limit dead letters of a queue back to the queue they
+// failed in, with their retry count reset, and returns how many were replayed.
+func ReplayDeadLetters(channel *amqp.Channel, queueName string, limit int) (int, error) {
+	publisher, err := newConfirmingPublisher(channel)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for replayed < limit {
+		delivery, ok, err := channel.Get(messaging.DeadLetterQueueName(queueName), false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+
+		key, msg := replayPublishing(delivery, queueName)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = publisher.Publish(ctx, "", key, msg)
+		cancel()
+		if err != nil {
+			delivery.Nack(false, true)
+			return replayed, err
+		}
+
+		delivery.Ack(false)
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+// replayPublishing copies a dead letter into a publishing for the queue it failed in,
+// without the headers recording its failure.
+func replayPublishing(delivery amqp.Delivery, queueName string) (string, amqp.Publishing) {
+	msg := republishing(delivery)
+
+	key := queueName
+	if originalQueue, ok := msg.Headers[headerOriginalQueue].(string); ok && originalQueue != "" {
+		key = originalQueue
+	}
+
+	for _, header := range []string{headerRetryCount, headerOriginalQueue, headerError, headerFailedAt} {
+		delete(msg.Headers, header)
+	}
+
+	return key, msg
+}
@@ -0,0 +1,84 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/service/message"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// ProcessMessageEdit applies an edit sent over WebSocket. The room members learn about it
+// through a message.updated event; a refused edit is answered to the requesting device.
+func (p *Processor) ProcessMessageEdit(ctx context.Context, msgBody []byte) error {
+	var edit coreTypes.MessageEdit
+	if err := json.Unmarshal(msgBody, &edit); err != nil {
+		return Permanent(fmt.Errorf("invalid message edit: %w", err))
+	}
+
+	_, err := p.newEditor().Edit(ctx, edit.UserID, edit.MessageID, edit.Content)
+	return p.rejectOrRetry(ctx, err, edit.UserID, edit.ClientID, edit.RequestID)
+}
+
+// ProcessMessageDelete applies a deletion sent over WebSocket.
+func (p *Processor) ProcessMessageDelete(ctx context.Context, msgBody []byte) error {
+	var deletion coreTypes.MessageDelete
+	if err := json.Unmarshal(msgBody, &deletion); err != nil {
+		return Permanent(fmt.Errorf("invalid message deletion: %w", err))
+	}
+
+	err := p.newEditor().Delete(ctx, deletion.UserID, deletion.MessageID, deletion.Scope)
+	return p.rejectOrRetry(ctx, err, deletion.UserID, deletion.ClientID, deletion.RequestID)
+}
+
+func (p *Processor) newEditor() *message.Editor {
+	return message.NewEditor(p.messages, p.rooms, p.broadcaster)
+}
+
+// rejectOrRetry answers the device when the Editor refused its request and returns the
+// errors worth retrying.
+func (p *Processor) rejectOrRetry(ctx context.Context, err error, userID, clientID, requestID string) error {
+	if err == nil {
+		return nil
+	}
+
+	code, reason := rejectionFor(err)
+	if code == "" {
+		return err
+	}
+
+	log.Printf("Rejecting request %s of %s: %v", requestID, userID, err)
+	return p.broadcaster.PublishBroadcast(ctx, coreTypes.BroadcastMessage{
+		UserIDs:    []string{userID},
+		ClientID:   clientID,
+		Rejections: []coreTypes.Rejection{{RequestID: requestID, Code: code, Message: reason}},
+		Timestamp:  time.Now(),
+	})
+}
+
+func rejectionFor(err error) (string, string) {
+	switch {
+	case errors.Is(err, bson.ErrInvalidHex), errors.Is(err, mongo.ErrNoDocuments):
+		return "not_found", "Message not found"
+	case errors.Is(err, message.ErrNotMember):
+		return "forbidden", "You are not a member of this room"
+	case errors.Is(err, message.ErrNotAuthor):
+		return "forbidden", "Only the author can change this message"
+	case errors.Is(err, message.ErrWindowExpired):
+		return "window_expired", "This message is too old to be changed"
+	case errors.Is(err, message.ErrMessageDeleted):
+		return "message_deleted", "This message was deleted"
+	case errors.Is(err, message.ErrInvalidEmoji):
+		return "invalid_emoji", "Reactions must be a single emoji"
+	case errors.Is(err, message.ErrEditConflict):
+		return "conflict", "This message was changed meanwhile, try again"
+	default:
+		return "", ""
+	}
+}
@@ -0,0 +1,117 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/config"
+	"github.com/hoyci/ms-chat/message-service/mocks"
+	"github.com/hoyci/ms-chat/message-service/types"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// receive waits for the next delivery of a queue and acknowledges it.
+func receive(t *testing.T, bus messaging.Bus, queueName string) amqp.Delivery {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deliveries, err := bus.Consume(ctx, queueName, 1)
+	if err != nil {
+		t.Fatalf("failed to consume %s: %v", queueName, err)
+	}
+
+	select {
+	case delivery := <-deliveries:
+		delivery.Ack(false)
+		return delivery
+	case <-time.After(2 * time.Second):
+		t.Fatalf("nothing was delivered to %s", queueName)
+		return amqp.Delivery{}
+	}
+}
+
+func TestProcessorFlow(t *testing.T) {
+	roomID := bson.NewObjectID()
+	messageID := bson.NewObjectID()
+	chatRoom := &types.Room{ID: roomID, Users: []string{"user-1", "user-2"}}
+
+	consume := func(t *testing.T, bus messaging.Bus, processor *Processor, policy RetryPolicy) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go ConsumeQueue(ctx, bus, config.Envs.PersistenceQueueName, processor.PersistenceProcessors(), policy)
+	}
+
+	t.Run("it should persist a room message and broadcast it to the room members", func(t *testing.T) {
+		bus := messaging.NewMemoryBus(Queues())
+		mockRoomStore := new(mocks.MockRoomStore)
+		mockMessageStore := new(mocks.MockMessageStore)
+		processor := NewProcessor(bus, mockRoomStore, mockMessageStore, new(mocks.MockAttachmentStore))
+
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return(chatRoom, nil)
+		mockMessageStore.On("Create", mock.Anything, mock.MatchedBy(func(document map[string]any) bool {
+			return document["_id"] == messageID && document["room_id"] == roomID && document["content"] == "hello"
+		})).Return(messageID, nil)
+
+		consume(t, bus, processor, DefaultRetryPolicy())
+
+		err := messaging.ChatMessage.Publish(context.Background(), bus, coreTypes.Message{
+			ID:        messageID.Hex(),
+			RoomID:    roomID.Hex(),
+			SenderID:  "user-1",
+			ClientID:  "device-1",
+			Content:   "hello",
+			Status:    coreTypes.StatusSent,
+			CreatedAt: time.Now(),
+		})
+		assert.NoError(t, err)
+
+		broadcast, err := messaging.Broadcast.Decode(receive(t, bus, config.Envs.BroadcastQueueName))
+		assert.NoError(t, err)
+		assert.Equal(t, chatRoom.Users, broadcast.UserIDs)
+		assert.Equal(t, "device-1", broadcast.ExcludeClientID)
+		if assert.Len(t, broadcast.Messages, 1) {
+			assert.Equal(t, messageID.Hex(), broadcast.Messages[0].ID)
+			assert.Equal(t, "hello", broadcast.Messages[0].Content)
+		}
+		mockRoomStore.AssertExpectations(t)
+		mockMessageStore.AssertExpectations(t)
+	})
+
+	t.Run("it should retry a failing message then dead-letter it", func(t *testing.T) {
+		bus := messaging.NewMemoryBus(Queues())
+		mockRoomStore := new(mocks.MockRoomStore)
+		processor := NewProcessor(bus, mockRoomStore, new(mocks.MockMessageStore), new(mocks.MockAttachmentStore))
+
+		mockRoomStore.On("GetByID", mock.Anything, roomID.Hex()).Return((*types.Room)(nil), errors.New("database unavailable"))
+
+		consume(t, bus, processor, RetryPolicy{
+			Prefetch:   1,
+			Timeout:    time.Second,
+			MaxRetries: 2,
+			RetryDelay: 10 * time.Millisecond,
+			DeadLetter: true,
+		})
+
+		err := messaging.ChatMessage.Publish(context.Background(), bus, coreTypes.Message{
+			ID:       messageID.Hex(),
+			RoomID:   roomID.Hex(),
+			SenderID: "user-1",
+			Content:  "hello",
+		})
+		assert.NoError(t, err)
+
+		deadLetter := receive(t, bus, messaging.DeadLetterQueueName(config.Envs.PersistenceQueueName))
+		assert.Equal(t, 2, retryCount(deadLetter.Headers))
+		assert.Equal(t, "database unavailable", deadLetter.Headers[headerError])
+		mockRoomStore.AssertNumberOfCalls(t, "GetByID", 3)
+	})
+}
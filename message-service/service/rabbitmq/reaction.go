@@ -0,0 +1,22 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/service/message"
+)
+
+// ProcessReaction adds or removes a reaction sent over WebSocket.
+func (p *Processor) ProcessReaction(ctx context.Context, msgBody []byte) error {
+	var change coreTypes.ReactionChange
+	if err := json.Unmarshal(msgBody, &change); err != nil {
+		return Permanent(fmt.Errorf("invalid reaction: %w", err))
+	}
+
+	reactor := message.NewReactor(p.messages, p.rooms, p.broadcaster)
+	err := reactor.React(ctx, change.UserID, change.MessageID, change.Emoji, change.Action)
+	return p.rejectOrRetry(ctx, err, change.UserID, change.ClientID, change.RequestID)
+}
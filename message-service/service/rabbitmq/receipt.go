@@ -0,0 +1,194 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// ProcessReceipt persists the delivered and read receipts sent by devices and forwards
+// them to every device of the senders. A message status is the furthest state any of
+// its recipients reached, while the forwarded receipts name the recipient so group
+// senders can tell members apart.
+func (p *Processor) ProcessReceipt(ctx context.Context, msgBody []byte) error {
+	var receipt coreTypes.Receipt
+	if err := json.Unmarshal(msgBody, &receipt); err != nil {
+		return Permanent(fmt.Errorf("invalid receipt: %w", err))
+	}
+
+	switch receipt.Status {
+	case coreTypes.StatusDelivered:
+		return p.processDelivered(ctx, receipt)
+	case coreTypes.StatusRead:
+		return p.processRead(ctx, receipt)
+	default:
+		log.Printf("Dropping receipt of %s with status %q", receipt.UserID, receipt.Status)
+		return nil
+	}
+}
+
+func (p *Processor) processDelivered(ctx context.Context, receipt coreTypes.Receipt) error {
+	ids := make([]bson.ObjectID, 0, len(receipt.MessageIDs))
+	for _, rawID := range receipt.MessageIDs {
+		id, err := bson.ObjectIDFromHex(rawID)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	messages, err := p.messages.ListByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	rooms := make(map[bson.ObjectID]*types.Room)
+	delivered := make([]types.Message, 0, len(messages))
+	for _, msg := range messages {
+		if msg.SenderID == receipt.UserID {
+			continue
+		}
+
+		chatRoom, ok := rooms[msg.RoomID]
+		if !ok {
+			chatRoom, err = p.rooms.GetByID(ctx, msg.RoomID.Hex())
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				return err
+			}
+			rooms[msg.RoomID] = chatRoom
+		}
+
+		if chatRoom == nil || !chatRoom.IsMember(receipt.UserID) {
+			continue
+		}
+		delivered = append(delivered, msg)
+	}
+
+	return p.recordDelivered(ctx, receipt, delivered)
+}
+
+// recordDelivered marks messages as received by the user of receipt, advances their
+// status and tells their senders.
+func (p *Processor) recordDelivered(ctx context.Context, receipt coreTypes.Receipt, messages []types.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := messageIDs(messages)
+	if err := p.messages.MarkDelivered(ctx, ids, receipt.UserID); err != nil {
+		return err
+	}
+	if _, err := p.messages.AdvanceStatus(ctx, ids, coreTypes.StatusDelivered); err != nil {
+		return err
+	}
+
+	return p.notifySenders(ctx, receipt, messages)
+}
+
+func (p *Processor) processRead(ctx context.Context, receipt coreTypes.Receipt) error {
+	chatRoom, err := p.rooms.GetByID(ctx, receipt.RoomID)
+	if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, bson.ErrInvalidHex) {
+		log.Printf("Dropping read receipt of %s for unknown room %s", receipt.UserID, receipt.RoomID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !chatRoom.IsMember(receipt.UserID) {
+		log.Printf("Dropping read receipt: %s is not a member of room %s", receipt.UserID, receipt.RoomID)
+		return nil
+	}
+
+	upToID, err := bson.ObjectIDFromHex(receipt.UpToID)
+	if err != nil {
+		log.Printf("Dropping read receipt of %s with invalid message %s", receipt.UserID, receipt.UpToID)
+		return nil
+	}
+
+	markers, err := p.messages.ListByIDs(ctx, []bson.ObjectID{upToID})
+	if err != nil {
+		return err
+	}
+	if len(markers) == 0 || markers[0].RoomID != chatRoom.ID {
+		log.Printf("Dropping read receipt of %s: message %s is not in room %s", receipt.UserID, receipt.UpToID, receipt.RoomID)
+		return nil
+	}
+	upTo := markers[0].CreatedAt
+
+	read, err := p.messages.ListReceivedBetween(ctx, chatRoom.ID, receipt.UserID, chatRoom.LastReadAt[receipt.UserID], upTo)
+	if err != nil {
+		return err
+	}
+
+	if len(read) > 0 {
+		// Reading a message implies receiving it, even when its delivered receipt was lost.
+		if err := p.messages.MarkDelivered(ctx, messageIDs(read), receipt.UserID); err != nil {
+			return err
+		}
+		if _, err := p.messages.AdvanceStatus(ctx, messageIDs(read), coreTypes.StatusRead); err != nil {
+			return err
+		}
+		if err := p.notifySenders(ctx, receipt, read); err != nil {
+			return err
+		}
+	}
+
+	// The marker moves last: a receipt retried after a failure above lists the same
+	// messages again instead of finding them already behind the marker.
+	return p.rooms.MarkRead(ctx, chatRoom.ID, receipt.UserID, upTo)
+}
+
+// notifySenders pushes one receipt per sender and room listing the messages receipt
+// applies to.
+func (p *Processor) notifySenders(ctx context.Context, receipt coreTypes.Receipt, messages []types.Message) error {
+	type key struct {
+		senderID string
+		roomID   bson.ObjectID
+	}
+
+	grouped := make(map[key][]string)
+	var order []key
+	for _, msg := range messages {
+		k := key{senderID: msg.SenderID, roomID: msg.RoomID}
+		if _, ok := grouped[k]; !ok {
+			order = append(order, k)
+		}
+		grouped[k] = append(grouped[k], msg.ID.Hex())
+	}
+
+	for _, k := range order {
+		err := p.broadcaster.PublishBroadcast(ctx, coreTypes.BroadcastMessage{
+			UserIDs: []string{k.senderID},
+			Receipts: []coreTypes.Receipt{{
+				UserID:     receipt.UserID,
+				RoomID:     k.roomID.Hex(),
+				MessageIDs: grouped[k],
+				Status:     receipt.Status,
+				Timestamp:  receipt.Timestamp,
+			}},
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func messageIDs(messages []types.Message) []bson.ObjectID {
+	ids := make([]bson.ObjectID, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	return ids
+}
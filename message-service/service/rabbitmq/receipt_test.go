@@ -0,0 +1,204 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/config"
+	"github.com/hoyci/ms-chat/message-service/mocks"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestProcessReceipt(t *testing.T) {
+	roomID := bson.NewObjectID()
+	chatRoom := &types.Room{ID: roomID, Users: []string{"user-1", "user-2"}}
+	readUpTo := time.Now()
+
+	fromSender := types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: "user-1", CreatedAt: readUpTo.Add(-time.Minute)}
+	fromReceiver := types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: "user-2", CreatedAt: readUpTo.Add(-time.Minute)}
+	marker := types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: "user-1", CreatedAt: readUpTo}
+
+	receiptBody := func(receipt coreTypes.Receipt) []byte {
+		receipt.UserID = "user-2"
+		receipt.Timestamp = time.Now()
+		body, _ := json.Marshal(receipt)
+		return body
+	}
+
+	setup := func() (*messaging.MemoryBus, *mocks.MockRoomStore, *mocks.MockMessageStore, *Processor) {
+		bus := messaging.NewMemoryBus(Queues())
+		rooms := new(mocks.MockRoomStore)
+		messages := new(mocks.MockMessageStore)
+		rooms.On("GetByID", mock.Anything, roomID.Hex()).Return(chatRoom, nil)
+		return bus, rooms, messages, NewProcessor(bus, rooms, messages, new(mocks.MockAttachmentStore))
+	}
+
+	t.Run("it should record the delivery of the messages others sent and tell their sender", func(t *testing.T) {
+		bus, _, messages, processor := setup()
+		ids := []bson.ObjectID{fromSender.ID, fromReceiver.ID}
+		messages.On("ListByIDs", mock.Anything, ids).Return([]types.Message{fromSender, fromReceiver}, nil)
+		messages.On("MarkDelivered", mock.Anything, []bson.ObjectID{fromSender.ID}, "user-2").Return(nil)
+		messages.On("AdvanceStatus", mock.Anything, []bson.ObjectID{fromSender.ID}, coreTypes.StatusDelivered).Return(int64(1), nil)
+
+		err := processor.ProcessReceipt(context.Background(), receiptBody(coreTypes.Receipt{
+			MessageIDs: []string{fromSender.ID.Hex(), fromReceiver.ID.Hex(), "not-an-id"},
+			Status:     coreTypes.StatusDelivered,
+		}))
+		assert.NoError(t, err)
+
+		broadcast, err := messaging.Broadcast.Decode(receive(t, bus, config.Envs.BroadcastQueueName))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"user-1"}, broadcast.UserIDs)
+		if assert.Len(t, broadcast.Receipts, 1) {
+			assert.Equal(t, "user-2", broadcast.Receipts[0].UserID)
+			assert.Equal(t, roomID.Hex(), broadcast.Receipts[0].RoomID)
+			assert.Equal(t, []string{fromSender.ID.Hex()}, broadcast.Receipts[0].MessageIDs)
+			assert.Equal(t, coreTypes.StatusDelivered, broadcast.Receipts[0].Status)
+		}
+		messages.AssertExpectations(t)
+	})
+
+	t.Run("it should ignore delivered receipts for rooms the user left", func(t *testing.T) {
+		_, rooms, messages, processor := setup()
+		otherRoom := &types.Room{ID: bson.NewObjectID(), Users: []string{"user-1", "user-3"}}
+		elsewhere := types.Message{ID: bson.NewObjectID(), RoomID: otherRoom.ID, SenderID: "user-1"}
+		rooms.On("GetByID", mock.Anything, otherRoom.ID.Hex()).Return(otherRoom, nil)
+		messages.On("ListByIDs", mock.Anything, []bson.ObjectID{elsewhere.ID}).Return([]types.Message{elsewhere}, nil)
+
+		err := processor.ProcessReceipt(context.Background(), receiptBody(coreTypes.Receipt{
+			MessageIDs: []string{elsewhere.ID.Hex()},
+			Status:     coreTypes.StatusDelivered,
+		}))
+
+		assert.NoError(t, err)
+		messages.AssertNotCalled(t, "MarkDelivered", mock.Anything, mock.Anything, mock.Anything)
+		messages.AssertNotCalled(t, "AdvanceStatus", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should move the read marker and mark the messages read up to it", func(t *testing.T) {
+		bus, rooms, messages, processor := setup()
+		messages.On("ListByIDs", mock.Anything, []bson.ObjectID{marker.ID}).Return([]types.Message{marker}, nil)
+		messages.On("ListReceivedBetween", mock.Anything, roomID, "user-2", time.Time{}, marker.CreatedAt).Return([]types.Message{fromSender, marker}, nil)
+		rooms.On("MarkRead", mock.Anything, roomID, "user-2", marker.CreatedAt).Return(nil)
+		read := []bson.ObjectID{fromSender.ID, marker.ID}
+		messages.On("MarkDelivered", mock.Anything, read, "user-2").Return(nil)
+		messages.On("AdvanceStatus", mock.Anything, read, coreTypes.StatusRead).Return(int64(2), nil)
+
+		err := processor.ProcessReceipt(context.Background(), receiptBody(coreTypes.Receipt{
+			RoomID: roomID.Hex(),
+			UpToID: marker.ID.Hex(),
+			Status: coreTypes.StatusRead,
+		}))
+		assert.NoError(t, err)
+
+		broadcast, err := messaging.Broadcast.Decode(receive(t, bus, config.Envs.BroadcastQueueName))
+		assert.NoError(t, err)
+		if assert.Len(t, broadcast.Receipts, 1) {
+			assert.Equal(t, []string{fromSender.ID.Hex(), marker.ID.Hex()}, broadcast.Receipts[0].MessageIDs)
+			assert.Equal(t, coreTypes.StatusRead, broadcast.Receipts[0].Status)
+		}
+		rooms.AssertExpectations(t)
+		messages.AssertExpectations(t)
+	})
+
+	t.Run("it should keep the read marker in place until a failed read receipt is retried", func(t *testing.T) {
+		bus, rooms, messages, processor := setup()
+		messages.On("ListByIDs", mock.Anything, []bson.ObjectID{marker.ID}).Return([]types.Message{marker}, nil)
+		messages.On("ListReceivedBetween", mock.Anything, roomID, "user-2", time.Time{}, marker.CreatedAt).Return([]types.Message{fromSender, marker}, nil)
+		read := []bson.ObjectID{fromSender.ID, marker.ID}
+		messages.On("MarkDelivered", mock.Anything, read, "user-2").Return(nil)
+		messages.On("AdvanceStatus", mock.Anything, read, coreTypes.StatusRead).Return(int64(0), errors.New("connection reset")).Once()
+		messages.On("AdvanceStatus", mock.Anything, read, coreTypes.StatusRead).Return(int64(2), nil).Once()
+		rooms.On("MarkRead", mock.Anything, roomID, "user-2", marker.CreatedAt).Return(nil)
+		body := receiptBody(coreTypes.Receipt{RoomID: roomID.Hex(), UpToID: marker.ID.Hex(), Status: coreTypes.StatusRead})
+
+		assert.EqualError(t, processor.ProcessReceipt(context.Background(), body), "connection reset")
+		rooms.AssertNotCalled(t, "MarkRead", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+		assert.NoError(t, processor.ProcessReceipt(context.Background(), body))
+
+		broadcast, err := messaging.Broadcast.Decode(receive(t, bus, config.Envs.BroadcastQueueName))
+		assert.NoError(t, err)
+		if assert.Len(t, broadcast.Receipts, 1) {
+			assert.Equal(t, []string{fromSender.ID.Hex(), marker.ID.Hex()}, broadcast.Receipts[0].MessageIDs)
+		}
+		rooms.AssertExpectations(t)
+		messages.AssertExpectations(t)
+	})
+
+	t.Run("it should keep the read marker in place when the senders cannot be told", func(t *testing.T) {
+		bus, rooms, messages, _ := setup()
+		messages.On("ListByIDs", mock.Anything, []bson.ObjectID{marker.ID}).Return([]types.Message{marker}, nil)
+		messages.On("ListReceivedBetween", mock.Anything, roomID, "user-2", time.Time{}, marker.CreatedAt).Return([]types.Message{fromSender}, nil)
+		messages.On("MarkDelivered", mock.Anything, []bson.ObjectID{fromSender.ID}, "user-2").Return(nil)
+		messages.On("AdvanceStatus", mock.Anything, []bson.ObjectID{fromSender.ID}, coreTypes.StatusRead).Return(int64(1), nil)
+		rooms.On("MarkRead", mock.Anything, roomID, "user-2", marker.CreatedAt).Return(nil)
+		body := receiptBody(coreTypes.Receipt{RoomID: roomID.Hex(), UpToID: marker.ID.Hex(), Status: coreTypes.StatusRead})
+
+		unavailable := NewProcessor(unavailableBus{bus}, rooms, messages, new(mocks.MockAttachmentStore))
+		assert.Error(t, unavailable.ProcessReceipt(context.Background(), body))
+		rooms.AssertNotCalled(t, "MarkRead", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+		retried := NewProcessor(bus, rooms, messages, new(mocks.MockAttachmentStore))
+		assert.NoError(t, retried.ProcessReceipt(context.Background(), body))
+
+		broadcast, err := messaging.Broadcast.Decode(receive(t, bus, config.Envs.BroadcastQueueName))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"user-1"}, broadcast.UserIDs)
+		rooms.AssertExpectations(t)
+	})
+
+	t.Run("it should drop read receipts whose marker is not in the room", func(t *testing.T) {
+		_, rooms, messages, processor := setup()
+		elsewhere := types.Message{ID: bson.NewObjectID(), RoomID: bson.NewObjectID(), SenderID: "user-1"}
+		messages.On("ListByIDs", mock.Anything, []bson.ObjectID{elsewhere.ID}).Return([]types.Message{elsewhere}, nil)
+
+		err := processor.ProcessReceipt(context.Background(), receiptBody(coreTypes.Receipt{
+			RoomID: roomID.Hex(),
+			UpToID: elsewhere.ID.Hex(),
+			Status: coreTypes.StatusRead,
+		}))
+
+		assert.NoError(t, err)
+		rooms.AssertNotCalled(t, "MarkRead", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should drop read receipts for unknown rooms", func(t *testing.T) {
+		_, rooms, _, processor := setup()
+		unknownID := bson.NewObjectID()
+		rooms.On("GetByID", mock.Anything, unknownID.Hex()).Return((*types.Room)(nil), mongo.ErrNoDocuments)
+
+		err := processor.ProcessReceipt(context.Background(), receiptBody(coreTypes.Receipt{
+			RoomID: unknownID.Hex(),
+			UpToID: marker.ID.Hex(),
+			Status: coreTypes.StatusRead,
+		}))
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("it should only advance the read marker when nothing new was read", func(t *testing.T) {
+		_, rooms, messages, processor := setup()
+		messages.On("ListByIDs", mock.Anything, []bson.ObjectID{marker.ID}).Return([]types.Message{marker}, nil)
+		messages.On("ListReceivedBetween", mock.Anything, roomID, "user-2", time.Time{}, marker.CreatedAt).Return([]types.Message{}, nil)
+		rooms.On("MarkRead", mock.Anything, roomID, "user-2", marker.CreatedAt).Return(nil)
+
+		err := processor.ProcessReceipt(context.Background(), receiptBody(coreTypes.Receipt{
+			RoomID: roomID.Hex(),
+			UpToID: marker.ID.Hex(),
+			Status: coreTypes.StatusRead,
+		}))
+
+		assert.NoError(t, err)
+		messages.AssertNotCalled(t, "AdvanceStatus", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,107 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// maxSyncMessages bounds a replay; devices that missed more page through the history
+// endpoint.
+const maxSyncMessages = 500
+
+// ProcessSync replays to a device that just connected the messages it missed. Once the
+// replay is handed to ws-service, the messages the user had not received yet are
+// recorded as delivered to them, so the other devices of the user and later syncs do
+// not replay them again.
+func (p *Processor) ProcessSync(ctx context.Context, msgBody []byte) error {
+	var request coreTypes.SyncRequest
+	if err := json.Unmarshal(msgBody, &request); err != nil {
+		return Permanent(fmt.Errorf("invalid sync request: %w", err))
+	}
+
+	roomIDs, err := p.rooms.ListIDsByUser(ctx, request.UserID)
+	if err != nil {
+		return err
+	}
+	if len(roomIDs) == 0 {
+		return nil
+	}
+
+	var messages []types.Message
+	lastSeen, ok, err := p.findLastSeen(ctx, roomIDs, request.LastSeenID)
+	if err != nil {
+		return err
+	}
+	if ok {
+		messages, err = p.messages.ListAfter(ctx, roomIDs, request.UserID, lastSeen, maxSyncMessages)
+	} else {
+		messages, err = p.messages.ListPending(ctx, roomIDs, request.UserID, maxSyncMessages)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+	log.Printf("Replaying %d messages to %s", len(messages), request.ClientID)
+
+	replay := make([]coreTypes.Message, len(messages))
+	for i, msg := range messages {
+		replay[i] = msg.ToCore()
+	}
+
+	err = p.broadcaster.PublishBroadcast(ctx, coreTypes.BroadcastMessage{
+		UserIDs:   []string{request.UserID},
+		Messages:  replay,
+		ClientID:  request.ClientID,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	received := make([]types.Message, 0, len(messages))
+	for _, msg := range messages {
+		if msg.SenderID != request.UserID && !slices.Contains(msg.DeliveredTo, request.UserID) {
+			received = append(received, msg)
+		}
+	}
+
+	return p.recordDelivered(ctx, coreTypes.Receipt{
+		UserID:    request.UserID,
+		Status:    coreTypes.StatusDelivered,
+		Timestamp: time.Now(),
+	}, received)
+}
+
+// findLastSeen resolves the message a device last stored. Unknown IDs and messages
+// outside the user rooms are ignored so the device falls back to pending messages.
+func (p *Processor) findLastSeen(ctx context.Context, roomIDs []bson.ObjectID, lastSeenID string) (types.Message, bool, error) {
+	if lastSeenID == "" {
+		return types.Message{}, false, nil
+	}
+
+	id, err := bson.ObjectIDFromHex(lastSeenID)
+	if err != nil {
+		return types.Message{}, false, nil
+	}
+
+	messages, err := p.messages.ListByIDs(ctx, []bson.ObjectID{id})
+	if err != nil {
+		return types.Message{}, false, err
+	}
+	if len(messages) == 0 || !slices.Contains(roomIDs, messages[0].RoomID) {
+		return types.Message{}, false, nil
+	}
+
+	return messages[0], true, nil
+}
@@ -0,0 +1,140 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/message-service/config"
+	"github.com/hoyci/ms-chat/message-service/mocks"
+	"github.com/hoyci/ms-chat/message-service/types"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// unavailableBus accepts consumers but refuses every publish.
+type unavailableBus struct {
+	*messaging.MemoryBus
+}
+
+func (b unavailableBus) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	return errors.New("broker unavailable")
+}
+
+func TestProcessSync(t *testing.T) {
+	roomID := bson.NewObjectID()
+	syncBody := func(lastSeenID string) []byte {
+		body, _ := json.Marshal(coreTypes.SyncRequest{UserID: "user-2", ClientID: "device-2", LastSeenID: lastSeenID})
+		return body
+	}
+
+	setup := func() (*messaging.MemoryBus, *mocks.MockRoomStore, *mocks.MockMessageStore, *Processor) {
+		bus := messaging.NewMemoryBus(Queues())
+		rooms := new(mocks.MockRoomStore)
+		messages := new(mocks.MockMessageStore)
+		rooms.On("ListIDsByUser", mock.Anything, "user-2").Return([]bson.ObjectID{roomID}, nil)
+		return bus, rooms, messages, NewProcessor(bus, rooms, messages, new(mocks.MockAttachmentStore))
+	}
+
+	t.Run("it should replay the messages the user did not receive and record their delivery", func(t *testing.T) {
+		bus, _, messages, processor := setup()
+		pending := types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: "user-1", Content: "hello", CreatedAt: time.Now()}
+		messages.On("ListPending", mock.Anything, []bson.ObjectID{roomID}, "user-2", maxSyncMessages).Return([]types.Message{pending}, nil)
+		messages.On("MarkDelivered", mock.Anything, []bson.ObjectID{pending.ID}, "user-2").Return(nil)
+		messages.On("AdvanceStatus", mock.Anything, []bson.ObjectID{pending.ID}, coreTypes.StatusDelivered).Return(int64(1), nil)
+
+		assert.NoError(t, processor.ProcessSync(context.Background(), syncBody("")))
+
+		replay, err := messaging.Broadcast.Decode(receive(t, bus, config.Envs.BroadcastQueueName))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"user-2"}, replay.UserIDs)
+		assert.Equal(t, "device-2", replay.ClientID)
+		if assert.Len(t, replay.Messages, 1) {
+			assert.Equal(t, pending.ID.Hex(), replay.Messages[0].ID)
+		}
+
+		receipt, err := messaging.Broadcast.Decode(receive(t, bus, config.Envs.BroadcastQueueName))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"user-1"}, receipt.UserIDs)
+		if assert.Len(t, receipt.Receipts, 1) {
+			assert.Equal(t, "user-2", receipt.Receipts[0].UserID)
+			assert.Equal(t, coreTypes.StatusDelivered, receipt.Receipts[0].Status)
+			assert.Equal(t, []string{pending.ID.Hex()}, receipt.Receipts[0].MessageIDs)
+		}
+		messages.AssertExpectations(t)
+	})
+
+	t.Run("it should replay the attachments of the messages", func(t *testing.T) {
+		bus, _, messages, processor := setup()
+		attachment := types.Attachment{
+			ID:           bson.NewObjectID(),
+			RoomID:       roomID,
+			UploaderID:   "user-1",
+			Filename:     "lunch.png",
+			ContentType:  "image/png",
+			Size:         2048,
+			Width:        640,
+			Height:       480,
+			StorageKey:   "rooms/lunch.png",
+			ThumbnailKey: "rooms/lunch.thumb.png",
+		}
+		pending := types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: "user-1", Attachments: []types.Attachment{attachment}, CreatedAt: time.Now()}
+		messages.On("ListPending", mock.Anything, []bson.ObjectID{roomID}, "user-2", maxSyncMessages).Return([]types.Message{pending}, nil)
+		messages.On("MarkDelivered", mock.Anything, []bson.ObjectID{pending.ID}, "user-2").Return(nil)
+		messages.On("AdvanceStatus", mock.Anything, []bson.ObjectID{pending.ID}, coreTypes.StatusDelivered).Return(int64(1), nil)
+
+		assert.NoError(t, processor.ProcessSync(context.Background(), syncBody("")))
+
+		replay, err := messaging.Broadcast.Decode(receive(t, bus, config.Envs.BroadcastQueueName))
+		assert.NoError(t, err)
+		if assert.Len(t, replay.Messages, 1) {
+			assert.Equal(t, []coreTypes.Attachment{attachment.ToCore()}, replay.Messages[0].Attachments)
+			assert.True(t, replay.Messages[0].Attachments[0].HasThumbnail)
+		}
+	})
+
+	t.Run("it should only record the delivery of replayed messages other users sent the user did not receive", func(t *testing.T) {
+		_, _, messages, processor := setup()
+		lastSeen := types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: "user-1", CreatedAt: time.Now()}
+		own := types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: "user-2", CreatedAt: time.Now()}
+		received := types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: "user-1", DeliveredTo: []string{"user-2"}, CreatedAt: time.Now()}
+		missed := types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: "user-3", DeliveredTo: []string{"user-1"}, CreatedAt: time.Now()}
+		messages.On("ListByIDs", mock.Anything, []bson.ObjectID{lastSeen.ID}).Return([]types.Message{lastSeen}, nil)
+		messages.On("ListAfter", mock.Anything, []bson.ObjectID{roomID}, "user-2", lastSeen, maxSyncMessages).Return([]types.Message{own, received, missed}, nil)
+		messages.On("MarkDelivered", mock.Anything, []bson.ObjectID{missed.ID}, "user-2").Return(nil)
+		messages.On("AdvanceStatus", mock.Anything, []bson.ObjectID{missed.ID}, coreTypes.StatusDelivered).Return(int64(1), nil)
+
+		assert.NoError(t, processor.ProcessSync(context.Background(), syncBody(lastSeen.ID.Hex())))
+
+		messages.AssertExpectations(t)
+	})
+
+	t.Run("it should fall back to the pending messages when the last seen message is unknown", func(t *testing.T) {
+		_, _, messages, processor := setup()
+		unknownID := bson.NewObjectID()
+		messages.On("ListByIDs", mock.Anything, []bson.ObjectID{unknownID}).Return([]types.Message{}, nil)
+		messages.On("ListPending", mock.Anything, []bson.ObjectID{roomID}, "user-2", maxSyncMessages).Return([]types.Message{}, nil)
+
+		assert.NoError(t, processor.ProcessSync(context.Background(), syncBody(unknownID.Hex())))
+
+		messages.AssertExpectations(t)
+		messages.AssertNotCalled(t, "ListAfter", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should fail without recording the delivery when the replay cannot be published", func(t *testing.T) {
+		bus, rooms, messages, _ := setup()
+		processor := NewProcessor(unavailableBus{bus}, rooms, messages, new(mocks.MockAttachmentStore))
+		pending := types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: "user-1", CreatedAt: time.Now()}
+		messages.On("ListPending", mock.Anything, []bson.ObjectID{roomID}, "user-2", maxSyncMessages).Return([]types.Message{pending}, nil)
+
+		assert.Error(t, processor.ProcessSync(context.Background(), syncBody("")))
+
+		messages.AssertNotCalled(t, "MarkDelivered", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
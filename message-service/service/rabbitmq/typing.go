@@ -0,0 +1,54 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+)
+
+// ProcessTyping fans a typing indicator out to the other members of its room. Indicators
+// are never retried: a failed one is dropped and superseded by the next.
+func (p *Processor) ProcessTyping(ctx context.Context, msgBody []byte) error {
+	var event coreTypes.TypingEvent
+	if err := json.Unmarshal(msgBody, &event); err != nil {
+		return Permanent(fmt.Errorf("invalid typing event: %w", err))
+	}
+
+	if event.ExpiresAt != nil && event.ExpiresAt.Before(time.Now()) {
+		return nil
+	}
+
+	chatRoom, err := p.rooms.GetByID(ctx, event.RoomID)
+	if err != nil {
+		log.Printf("Dropping typing event of %s in room %s: %v", event.UserID, event.RoomID, err)
+		return nil
+	}
+
+	if !chatRoom.IsMember(event.UserID) {
+		log.Printf("Dropping typing event: %s is not a member of room %s", event.UserID, event.RoomID)
+		return nil
+	}
+
+	recipients := make([]string, 0, len(chatRoom.Users))
+	for _, userID := range chatRoom.Users {
+		if userID != event.UserID {
+			recipients = append(recipients, userID)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	if err := p.broadcaster.PublishBroadcast(ctx, coreTypes.BroadcastMessage{
+		UserIDs:   recipients,
+		Typing:    []coreTypes.TypingEvent{event},
+		Timestamp: time.Now(),
+	}); err != nil {
+		log.Printf("Dropping typing event of %s in room %s: %v", event.UserID, event.RoomID, err)
+	}
+	return nil
+}
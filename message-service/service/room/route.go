@@ -1,19 +1,32 @@
 package room
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	coreMiddlewares "github.com/hoyci/ms-chat/core/middlewares"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	coreUtils "github.com/hoyci/ms-chat/core/utils"
+	"github.com/hoyci/ms-chat/message-service/keys"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
 var validate = validator.New()
 
 type RoomHandler struct {
-	roomStore *RoomStore
+	roomStore types.RoomStore
 }
 
-func NewRoomHandler(roomStore *RoomStore) *RoomHandler {
+func NewRoomHandler(roomStore types.RoomStore) *RoomHandler {
 	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
 		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
 
@@ -26,53 +39,520 @@ func NewRoomHandler(roomStore *RoomStore) *RoomHandler {
 	return &RoomHandler{roomStore: roomStore}
 }
 
-// func (h *RoomHandler) HandleCreateRoom(w http.ResponseWriter, r *http.Request) {
-// 	var requestPayload types.CreateRoomPayload
-// 	if err := coreUtils.ParseJSON(r, &requestPayload); err != nil {
-// 		coreUtils.WriteError(w, http.StatusBadRequest, err, "HandleCreateRoom", coreTypes.BadRequestResponse{Error: "Body is not a valid json"})
-// 		return
-// 	}
-
-// 	if err := validate.Struct(requestPayload); err != nil {
-// 		var errorMessages []string
-// 		for _, e := range err.(validator.ValidationErrors) {
-// 			errorMessages = append(errorMessages, fmt.Sprintf("Field '%s' is invalid: %s", e.Field(), e.Tag()))
-// 		}
-
-// 		coreUtils.WriteError(w, http.StatusBadRequest, err, "HandleCreateRoom", coreTypes.BadRequestStructResponse{Error: errorMessages})
-// 		return
-// 	}
-
-// 	roomID, err := h.roomStore.Create(r.Context(), requestPayload)
-// 	if err != nil {
-// 		if errors.Is(err, context.Canceled) {
-// 			coreUtils.WriteError(w, http.StatusServiceUnavailable, err, "HandleCreateRoom", coreTypes.ContextCanceledResponse{Error: "Request canceled"})
-// 			return
-// 		}
-
-// 		coreUtils.WriteError(w, http.StatusInternalServerError, err, "HandleCreateRoom", coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"})
-// 		return
-// 	}
-
-// 	coreUtils.WriteJSON(w, http.StatusCreated, types.CreateRoomResponse{Message: "Room successfully created", RoomID: roomID.Hex()})
-// }
-
-// func (h *RoomHandler) HandleGetRoomByID(w http.ResponseWriter, r *http.Request) {
-// 	roomID := r.URL.Query().Get("room_id")
-// 	if roomID == "" {
-// 		coreUtils.WriteError(w, http.StatusBadRequest, fmt.Errorf("missing room_id on query params"), "HandleCreateRoom", coreTypes.BadRequestResponse{Error: "Missing room_id on query params"})
-// 	}
-
-// 	room, err := h.roomStore.GetByID(r.Context(), roomID)
-// 	if err != nil {
-// 		if errors.Is(err, context.Canceled) {
-// 			coreUtils.WriteError(w, http.StatusServiceUnavailable, err, "HandleCreateRoom", coreTypes.ContextCanceledResponse{Error: "Request canceled"})
-// 			return
-// 		}
-
-// 		coreUtils.WriteError(w, http.StatusInternalServerError, err, "HandleCreateRoom", coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"})
-// 		return
-// 	}
-
-// 	coreUtils.WriteJSON(w, http.StatusCreated, room)
-// }
+// RegisterRoutes adds the endpoints of the handler to the versioned API router.
+func (h *RoomHandler) RegisterRoutes(router *mux.Router) {
+	router.Handle(
+		"/rooms", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleListRooms),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodGet)
+	router.Handle(
+		"/rooms", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleCreateRoom),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodPost)
+	router.Handle(
+		"/rooms/{room_id}", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleGetRoom),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodGet)
+	router.Handle(
+		"/rooms/{room_id}", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleUpdateRoom),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodPatch)
+	router.Handle(
+		"/rooms/{room_id}/members", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleAddMembers),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodPost)
+	router.Handle(
+		"/rooms/{room_id}/members/{user_id}", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleRemoveMember),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodDelete)
+	router.Handle(
+		"/rooms/{room_id}/members/{user_id}", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleUpdateMemberRole),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodPatch)
+	router.Handle(
+		"/rooms/{room_id}/leave", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleLeaveRoom),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodPost)
+}
+
+// HandleListRooms
+// @Summary List the rooms of the authenticated user
+// @Tags Rooms
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} types.ListRoomsResponse "Rooms ordered by last activity"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /rooms [get]
+func (h *RoomHandler) HandleListRooms(w http.ResponseWriter, r *http.Request) {
+	userID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			"HandleListRooms", coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	rooms, err := h.roomStore.ListByUser(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, err, "HandleListRooms")
+		return
+	}
+
+	if rooms == nil {
+		rooms = []types.RoomSummary{}
+	}
+
+	_ = coreUtils.WriteJSON(w, http.StatusOK, types.ListRoomsResponse{Rooms: rooms})
+}
+
+// HandleCreateRoom
+// @Summary Create a group room owned by the authenticated user
+// @Tags Rooms
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body types.CreateRoomPayload true "Group name, avatar and initial members"
+// @Success 201 {object} types.RoomResponse "Created room"
+// @Failure 400 {object} coreTypes.BadRequestStructResponse "Validation errors for payload"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /rooms [post]
+func (h *RoomHandler) HandleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	userID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			"HandleCreateRoom", coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	var requestPayload types.CreateRoomPayload
+	if !parseAndValidate(w, r, &requestPayload, "HandleCreateRoom") {
+		return
+	}
+
+	now := time.Now()
+	room := types.Room{
+		ID:        bson.NewObjectID(),
+		Type:      types.RoomTypeGroup,
+		Name:      requestPayload.Name,
+		AvatarURL: requestPayload.AvatarURL,
+		OwnerID:   userID,
+		Users:     []string{userID},
+		Members:   []types.RoomMember{{UserID: userID, Role: types.RoleOwner, JoinedAt: now}},
+		CreatedAt: now,
+	}
+	for _, memberID := range requestPayload.Members {
+		if room.IsMember(memberID) {
+			continue
+		}
+		room.Users = append(room.Users, memberID)
+		room.Members = append(room.Members, types.RoomMember{UserID: memberID, Role: types.RoleMember, JoinedAt: now})
+	}
+
+	if _, err := h.roomStore.Create(r.Context(), room); err != nil {
+		writeStoreError(w, err, "HandleCreateRoom")
+		return
+	}
+
+	_ = coreUtils.WriteJSON(w, http.StatusCreated, types.RoomResponse{Room: &room})
+}
+
+// HandleGetRoom
+// @Summary Get a room the authenticated user belongs to
+// @Tags Rooms
+// @Produce json
+// @Security BearerAuth
+// @Param room_id path string true "Room ID"
+// @Success 200 {object} types.RoomResponse "Room"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not a member of the room"
+// @Failure 404 {object} coreTypes.NotFoundResponse "Room not found"
+// @Router /rooms/{room_id} [get]
+func (h *RoomHandler) HandleGetRoom(w http.ResponseWriter, r *http.Request) {
+	room, _, ok := h.loadRoom(w, r, "HandleGetRoom")
+	if !ok {
+		return
+	}
+
+	_ = coreUtils.WriteJSON(w, http.StatusOK, types.RoomResponse{Room: room})
+}
+
+// HandleUpdateRoom
+// @Summary Rename a group or change its avatar
+// @Tags Rooms
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param room_id path string true "Room ID"
+// @Param request body types.UpdateRoomPayload true "New name and/or avatar"
+// @Success 200 {object} types.RoomResponse "Updated room"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "Only admins can update the group"
+// @Router /rooms/{room_id} [patch]
+func (h *RoomHandler) HandleUpdateRoom(w http.ResponseWriter, r *http.Request) {
+	room, _, ok := h.loadGroup(w, r, types.RoleAdmin, "HandleUpdateRoom")
+	if !ok {
+		return
+	}
+
+	var requestPayload types.UpdateRoomPayload
+	if !parseAndValidate(w, r, &requestPayload, "HandleUpdateRoom") {
+		return
+	}
+
+	updated, err := h.roomStore.UpdateDetails(r.Context(), room.ID, requestPayload)
+	if err != nil {
+		writeStoreError(w, err, "HandleUpdateRoom")
+		return
+	}
+
+	_ = coreUtils.WriteJSON(w, http.StatusOK, types.RoomResponse{Room: updated})
+}
+
+// HandleAddMembers
+// @Summary Add members to a group
+// @Tags Rooms
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param room_id path string true "Room ID"
+// @Param request body types.AddMembersPayload true "Users to add"
+// @Success 200 {object} types.RoomResponse "Updated room"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "Only admins can add members"
+// @Failure 409 {object} coreTypes.ConflictResponse "Some users are already members"
+// @Router /rooms/{room_id}/members [post]
+func (h *RoomHandler) HandleAddMembers(w http.ResponseWriter, r *http.Request) {
+	room, _, ok := h.loadGroup(w, r, types.RoleAdmin, "HandleAddMembers")
+	if !ok {
+		return
+	}
+
+	var requestPayload types.AddMembersPayload
+	if !parseAndValidate(w, r, &requestPayload, "HandleAddMembers") {
+		return
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool, len(requestPayload.Members))
+	members := make([]types.RoomMember, 0, len(requestPayload.Members))
+	for _, memberID := range requestPayload.Members {
+		if seen[memberID] {
+			continue
+		}
+		seen[memberID] = true
+
+		if room.IsMember(memberID) {
+			coreUtils.WriteError(
+				w, http.StatusConflict, fmt.Errorf("user %s is already a member of room %s", memberID, room.ID.Hex()),
+				"HandleAddMembers", coreTypes.ConflictResponse{Error: fmt.Sprintf("User %s is already a member", memberID)},
+			)
+			return
+		}
+		members = append(members, types.RoomMember{UserID: memberID, Role: types.RoleMember, JoinedAt: now})
+	}
+
+	updated, err := h.roomStore.AddMembers(r.Context(), room.ID, members)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			coreUtils.WriteError(
+				w, http.StatusConflict, err, "HandleAddMembers",
+				coreTypes.ConflictResponse{Error: "Some users are already members"},
+			)
+			return
+		}
+
+		writeStoreError(w, err, "HandleAddMembers")
+		return
+	}
+
+	_ = coreUtils.WriteJSON(w, http.StatusOK, types.RoomResponse{Room: updated})
+}
+
+// HandleRemoveMember
+// @Summary Remove a member from a group
+// @Tags Rooms
+// @Produce json
+// @Security BearerAuth
+// @Param room_id path string true "Room ID"
+// @Param user_id path string true "Member to remove"
+// @Success 200 {object} types.RoomResponse "Updated room"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "Not allowed to remove this member"
+// @Failure 404 {object} coreTypes.NotFoundResponse "User is not a member"
+// @Router /rooms/{room_id}/members/{user_id} [delete]
+func (h *RoomHandler) HandleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	room, caller, ok := h.loadGroup(w, r, types.RoleAdmin, "HandleRemoveMember")
+	if !ok {
+		return
+	}
+
+	target, ok := h.loadTargetMember(w, r, room, caller, "HandleRemoveMember")
+	if !ok {
+		return
+	}
+
+	if !caller.Role.AtLeast(types.RoleOwner) && target.Role.AtLeast(types.RoleAdmin) {
+		coreUtils.WriteError(
+			w, http.StatusForbidden, fmt.Errorf("user %s cannot remove admin %s", caller.UserID, target.UserID),
+			"HandleRemoveMember", coreTypes.ForbiddenResponse{Error: "Only the owner can remove admins"},
+		)
+		return
+	}
+
+	updated, err := h.roomStore.RemoveMember(r.Context(), room.ID, target.UserID)
+	if err != nil {
+		writeStoreError(w, err, "HandleRemoveMember")
+		return
+	}
+
+	_ = coreUtils.WriteJSON(w, http.StatusOK, types.RoomResponse{Room: updated})
+}
+
+// HandleUpdateMemberRole
+// @Summary Promote a member to admin or demote an admin
+// @Tags Rooms
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param room_id path string true "Room ID"
+// @Param user_id path string true "Member to update"
+// @Param request body types.UpdateMemberRolePayload true "New role"
+// @Success 200 {object} types.RoomResponse "Updated room"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "Only the owner can change roles"
+// @Router /rooms/{room_id}/members/{user_id} [patch]
+func (h *RoomHandler) HandleUpdateMemberRole(w http.ResponseWriter, r *http.Request) {
+	room, caller, ok := h.loadGroup(w, r, types.RoleOwner, "HandleUpdateMemberRole")
+	if !ok {
+		return
+	}
+
+	target, ok := h.loadTargetMember(w, r, room, caller, "HandleUpdateMemberRole")
+	if !ok {
+		return
+	}
+
+	var requestPayload types.UpdateMemberRolePayload
+	if !parseAndValidate(w, r, &requestPayload, "HandleUpdateMemberRole") {
+		return
+	}
+
+	updated, err := h.roomStore.SetMemberRole(r.Context(), room.ID, target.UserID, requestPayload.Role)
+	if err != nil {
+		writeStoreError(w, err, "HandleUpdateMemberRole")
+		return
+	}
+
+	_ = coreUtils.WriteJSON(w, http.StatusOK, types.RoomResponse{Room: updated})
+}
+
+// HandleLeaveRoom
+// @Summary Leave a group
+// @Description When the owner leaves, ownership goes to the longest-standing admin (or member). The group is deleted when its last member leaves.
+// @Tags Rooms
+// @Security BearerAuth
+// @Param room_id path string true "Room ID"
+// @Success 204 "Left the group"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not a member of the room"
+// @Failure 409 {object} coreTypes.ConflictResponse "The group changed while the owner was leaving"
+// @Router /rooms/{room_id}/leave [post]
+func (h *RoomHandler) HandleLeaveRoom(w http.ResponseWriter, r *http.Request) {
+	room, caller, ok := h.loadGroup(w, r, types.RoleMember, "HandleLeaveRoom")
+	if !ok {
+		return
+	}
+
+	if caller.Role == types.RoleOwner {
+		successor, found := room.Successor()
+		if !found {
+			if err := h.roomStore.Delete(r.Context(), room.ID); err != nil {
+				writeStoreError(w, err, "HandleLeaveRoom")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		_, err := h.roomStore.TransferOwnershipAndLeave(r.Context(), room.ID, caller.UserID, successor.UserID)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			coreUtils.WriteError(
+				w, http.StatusConflict, err, "HandleLeaveRoom",
+				coreTypes.ConflictResponse{Error: "The group changed meanwhile, try again"},
+			)
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err, "HandleLeaveRoom")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if _, err := h.roomStore.RemoveMember(r.Context(), room.ID, caller.UserID); err != nil {
+		writeStoreError(w, err, "HandleLeaveRoom")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadRoom fetches the room of the request and ensures the caller belongs to it.
+func (h *RoomHandler) loadRoom(w http.ResponseWriter, r *http.Request, handlerName string) (*types.Room, string, bool) {
+	userID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			handlerName, coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return nil, "", false
+	}
+
+	roomID := mux.Vars(r)["room_id"]
+	room, err := h.roomStore.GetByID(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, bson.ErrInvalidHex) {
+			coreUtils.WriteError(
+				w, http.StatusBadRequest, err, handlerName,
+				coreTypes.BadRequestResponse{Error: "Invalid room_id"},
+			)
+			return nil, "", false
+		}
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			coreUtils.WriteError(
+				w, http.StatusNotFound, err, handlerName,
+				coreTypes.NotFoundResponse{Error: "Room not found"},
+			)
+			return nil, "", false
+		}
+
+		writeStoreError(w, err, handlerName)
+		return nil, "", false
+	}
+
+	if !room.IsMember(userID) {
+		coreUtils.WriteError(
+			w, http.StatusForbidden, fmt.Errorf("user %s is not a member of room %s", userID, roomID),
+			handlerName, coreTypes.ForbiddenResponse{Error: "You are not a member of this room"},
+		)
+		return nil, "", false
+	}
+
+	return room, userID, true
+}
+
+// loadGroup fetches the group of the request and ensures the caller holds at least
+// minRole in it.
+func (h *RoomHandler) loadGroup(w http.ResponseWriter, r *http.Request, minRole types.MemberRole, handlerName string) (*types.Room, types.RoomMember, bool) {
+	room, userID, ok := h.loadRoom(w, r, handlerName)
+	if !ok {
+		return nil, types.RoomMember{}, false
+	}
+
+	if room.Type != types.RoomTypeGroup {
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, fmt.Errorf("room %s is not a group", room.ID.Hex()),
+			handlerName, coreTypes.BadRequestResponse{Error: "This operation is only available for groups"},
+		)
+		return nil, types.RoomMember{}, false
+	}
+
+	caller, _ := room.Member(userID)
+	if !caller.Role.AtLeast(minRole) {
+		coreUtils.WriteError(
+			w, http.StatusForbidden, fmt.Errorf("user %s is %s in room %s, %s required", userID, caller.Role, room.ID.Hex(), minRole),
+			handlerName, coreTypes.ForbiddenResponse{Error: fmt.Sprintf("Only a group %s can do this", minRole)},
+		)
+		return nil, types.RoomMember{}, false
+	}
+
+	return room, caller, true
+}
+
+// loadTargetMember resolves the {user_id} member the caller is acting on. Callers act
+// on themselves through the leave endpoint and nobody can act on the owner.
+func (h *RoomHandler) loadTargetMember(w http.ResponseWriter, r *http.Request, room *types.Room, caller types.RoomMember, handlerName string) (types.RoomMember, bool) {
+	targetID := mux.Vars(r)["user_id"]
+	target, ok := room.Member(targetID)
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusNotFound, fmt.Errorf("user %s is not a member of room %s", targetID, room.ID.Hex()),
+			handlerName, coreTypes.NotFoundResponse{Error: "User is not a member of this room"},
+		)
+		return types.RoomMember{}, false
+	}
+
+	if target.UserID == caller.UserID {
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, fmt.Errorf("user %s tried to act on themselves", caller.UserID),
+			handlerName, coreTypes.BadRequestResponse{Error: "Use the leave endpoint to leave the group"},
+		)
+		return types.RoomMember{}, false
+	}
+
+	if target.Role == types.RoleOwner {
+		coreUtils.WriteError(
+			w, http.StatusForbidden, fmt.Errorf("user %s tried to act on the owner", caller.UserID),
+			handlerName, coreTypes.ForbiddenResponse{Error: "The owner cannot be changed or removed"},
+		)
+		return types.RoomMember{}, false
+	}
+
+	return target, true
+}
+
+func parseAndValidate(w http.ResponseWriter, r *http.Request, payload any, handlerName string) bool {
+	if err := coreUtils.ParseJSON(r, payload); err != nil {
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, err, handlerName,
+			coreTypes.BadRequestResponse{Error: "Body is not a valid json"},
+		)
+		return false
+	}
+
+	if err := validate.Struct(payload); err != nil {
+		var errorMessages []string
+		for _, e := range err.(validator.ValidationErrors) {
+			errorMessages = append(errorMessages, fmt.Sprintf("Field '%s' is invalid: %s", e.Field(), e.Tag()))
+		}
+
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, err, handlerName,
+			coreTypes.BadRequestStructResponse{Error: errorMessages},
+		)
+		return false
+	}
+
+	return true
+}
+
+func writeStoreError(w http.ResponseWriter, err error, handlerName string) {
+	if errors.Is(err, context.Canceled) {
+		coreUtils.WriteError(
+			w, http.StatusServiceUnavailable, err, handlerName,
+			coreTypes.ContextCanceledResponse{Error: "Request canceled"},
+		)
+		return
+	}
+
+	coreUtils.WriteError(
+		w, http.StatusInternalServerError, err, handlerName,
+		coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+	)
+}
@@ -0,0 +1,279 @@
+package room_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hoyci/ms-chat/message-service/mocks"
+	"github.com/hoyci/ms-chat/message-service/service/room"
+	"github.com/hoyci/ms-chat/message-service/testutils"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func setupTestServer() (*mocks.MockRoomStore, *mux.Router) {
+	mockRoomStore := new(mocks.MockRoomStore)
+	roomHandler := room.NewRoomHandler(mockRoomStore)
+	router := testutils.NewRouter(roomHandler)
+	return mockRoomStore, router
+}
+
+func TestHandleListRooms(t *testing.T) {
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+
+	t.Run("it should return the rooms of the user with their unread counts", func(t *testing.T) {
+		mockRoomStore, router := setupTestServer()
+		roomID := bson.NewObjectID()
+		lastMessage := &types.Message{ID: bson.NewObjectID(), RoomID: roomID, Content: "Hi", CreatedAt: time.Now()}
+		mockRoomStore.On("ListByUser", mock.Anything, userID).Return([]types.RoomSummary{
+			{ID: roomID, Users: []string{userID}, LastMessage: lastMessage, UnreadCount: 3, LastActivityAt: lastMessage.CreatedAt},
+		}, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/rooms", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response types.ListRoomsResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Len(t, response.Rooms, 1)
+		assert.Equal(t, 3, response.Rooms[0].UnreadCount)
+		assert.Equal(t, "Hi", response.Rooms[0].LastMessage.Content)
+	})
+
+	t.Run("it should return an empty list when the user has no rooms", func(t *testing.T) {
+		mockRoomStore, router := setupTestServer()
+		mockRoomStore.On("ListByUser", mock.Anything, userID).Return([]types.RoomSummary(nil), nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/rooms", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"rooms":[]}`, w.Body.String())
+	})
+
+	t.Run("it should return internal server error when the store fails", func(t *testing.T) {
+		mockRoomStore, router := setupTestServer()
+		mockRoomStore.On("ListByUser", mock.Anything, userID).Return([]types.RoomSummary(nil), errors.New("boom"))
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/rooms", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.JSONEq(t, `{"error":"An unexpected error occurred"}`, w.Body.String())
+	})
+}
+
+func newGroup(ownerID, adminID, memberID string) *types.Room {
+	now := time.Now()
+	return &types.Room{
+		ID:      bson.NewObjectID(),
+		Type:    types.RoomTypeGroup,
+		Name:    "Team",
+		OwnerID: ownerID,
+		Users:   []string{ownerID, adminID, memberID},
+		Members: []types.RoomMember{
+			{UserID: ownerID, Role: types.RoleOwner, JoinedAt: now},
+			{UserID: adminID, Role: types.RoleAdmin, JoinedAt: now.Add(time.Minute)},
+			{UserID: memberID, Role: types.RoleMember, JoinedAt: now.Add(2 * time.Minute)},
+		},
+	}
+}
+
+func TestHandleCreateRoom(t *testing.T) {
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	memberID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+
+	t.Run("it should create a group owned by the caller", func(t *testing.T) {
+		mockRoomStore, router := setupTestServer()
+		mockRoomStore.On("Create", mock.Anything, mock.MatchedBy(func(room types.Room) bool {
+			owner, _ := room.Member(userID)
+			return room.Type == types.RoomTypeGroup && room.OwnerID == userID && owner.Role == types.RoleOwner &&
+				len(room.Users) == 2
+		})).Return(bson.NewObjectID(), nil)
+
+		req := testutils.NewAuthenticatedRequestWithBody(
+			http.MethodPost, "/api/v1/rooms", userID,
+			`{"name":"Team","members":["`+memberID+`","`+memberID+`","`+userID+`"]}`,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var response types.RoomResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, []string{userID, memberID}, response.Room.Users)
+		mockRoomStore.AssertExpectations(t)
+	})
+
+	t.Run("it should return bad request when the name is missing", func(t *testing.T) {
+		_, router := setupTestServer()
+
+		req := testutils.NewAuthenticatedRequestWithBody(
+			http.MethodPost, "/api/v1/rooms", userID, `{"members":["`+memberID+`"]}`,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestHandleAddMembers(t *testing.T) {
+	ownerID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	adminID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+	memberID := "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"
+
+	t.Run("it should return conflict when the user is already a member", func(t *testing.T) {
+		mockRoomStore, router := setupTestServer()
+		group := newGroup(ownerID, adminID, memberID)
+		mockRoomStore.On("GetByID", mock.Anything, group.ID.Hex()).Return(group, nil)
+
+		req := testutils.NewAuthenticatedRequestWithBody(
+			http.MethodPost, "/api/v1/rooms/"+group.ID.Hex()+"/members", adminID, `{"members":["`+memberID+`"]}`,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		mockRoomStore.AssertNotCalled(t, "AddMembers", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should reject plain members", func(t *testing.T) {
+		mockRoomStore, router := setupTestServer()
+		group := newGroup(ownerID, adminID, memberID)
+		mockRoomStore.On("GetByID", mock.Anything, group.ID.Hex()).Return(group, nil)
+
+		req := testutils.NewAuthenticatedRequestWithBody(
+			http.MethodPost, "/api/v1/rooms/"+group.ID.Hex()+"/members", memberID,
+			`{"members":["1b2c3d4e-5f6a-4b7c-8d9e-0f1a2b3c4d5e"]}`,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestHandleRemoveMember(t *testing.T) {
+	ownerID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	adminID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+	memberID := "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"
+
+	t.Run("it should let admins remove members", func(t *testing.T) {
+		mockRoomStore, router := setupTestServer()
+		group := newGroup(ownerID, adminID, memberID)
+		mockRoomStore.On("GetByID", mock.Anything, group.ID.Hex()).Return(group, nil)
+		mockRoomStore.On("RemoveMember", mock.Anything, group.ID, memberID).Return(group, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodDelete, "/api/v1/rooms/"+group.ID.Hex()+"/members/"+memberID, adminID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockRoomStore.AssertExpectations(t)
+	})
+
+	t.Run("it should not let admins remove other admins", func(t *testing.T) {
+		mockRoomStore, router := setupTestServer()
+		group := newGroup(ownerID, adminID, memberID)
+		group.Members[2].Role = types.RoleAdmin
+		mockRoomStore.On("GetByID", mock.Anything, group.ID.Hex()).Return(group, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodDelete, "/api/v1/rooms/"+group.ID.Hex()+"/members/"+memberID, adminID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.JSONEq(t, `{"error":"Only the owner can remove admins"}`, w.Body.String())
+	})
+}
+
+func TestHandleLeaveRoom(t *testing.T) {
+	ownerID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	adminID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+	memberID := "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"
+
+	t.Run("it should hand ownership to the oldest admin when the owner leaves", func(t *testing.T) {
+		mockRoomStore, router := setupTestServer()
+		group := newGroup(ownerID, adminID, memberID)
+		mockRoomStore.On("GetByID", mock.Anything, group.ID.Hex()).Return(group, nil)
+		mockRoomStore.On("TransferOwnershipAndLeave", mock.Anything, group.ID, ownerID, adminID).Return(group, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodPost, "/api/v1/rooms/"+group.ID.Hex()+"/leave", ownerID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockRoomStore.AssertExpectations(t)
+		mockRoomStore.AssertNotCalled(t, "RemoveMember", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should keep the owner in the group when the hand-over fails", func(t *testing.T) {
+		mockRoomStore, router := setupTestServer()
+		group := newGroup(ownerID, adminID, memberID)
+		mockRoomStore.On("GetByID", mock.Anything, group.ID.Hex()).Return(group, nil)
+		mockRoomStore.On("TransferOwnershipAndLeave", mock.Anything, group.ID, ownerID, adminID).Return((*types.Room)(nil), errors.New("connection reset"))
+
+		req := testutils.NewAuthenticatedRequest(http.MethodPost, "/api/v1/rooms/"+group.ID.Hex()+"/leave", ownerID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		mockRoomStore.AssertNotCalled(t, "RemoveMember", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should answer with a conflict when the group changed during the hand-over", func(t *testing.T) {
+		mockRoomStore, router := setupTestServer()
+		group := newGroup(ownerID, adminID, memberID)
+		mockRoomStore.On("GetByID", mock.Anything, group.ID.Hex()).Return(group, nil)
+		mockRoomStore.On("TransferOwnershipAndLeave", mock.Anything, group.ID, ownerID, adminID).Return((*types.Room)(nil), mongo.ErrNoDocuments)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodPost, "/api/v1/rooms/"+group.ID.Hex()+"/leave", ownerID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.JSONEq(t, `{"error":"The group changed meanwhile, try again"}`, w.Body.String())
+	})
+
+	t.Run("it should remove a member leaving the group", func(t *testing.T) {
+		mockRoomStore, router := setupTestServer()
+		group := newGroup(ownerID, adminID, memberID)
+		mockRoomStore.On("GetByID", mock.Anything, group.ID.Hex()).Return(group, nil)
+		mockRoomStore.On("RemoveMember", mock.Anything, group.ID, memberID).Return(group, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodPost, "/api/v1/rooms/"+group.ID.Hex()+"/leave", memberID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockRoomStore.AssertExpectations(t)
+	})
+
+	t.Run("it should delete the group when its last member leaves", func(t *testing.T) {
+		mockRoomStore, router := setupTestServer()
+		group := newGroup(ownerID, adminID, memberID)
+		group.Users = group.Users[:1]
+		group.Members = group.Members[:1]
+		mockRoomStore.On("GetByID", mock.Anything, group.ID.Hex()).Return(group, nil)
+		mockRoomStore.On("Delete", mock.Anything, group.ID).Return(nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodPost, "/api/v1/rooms/"+group.ID.Hex()+"/leave", ownerID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockRoomStore.AssertExpectations(t)
+	})
+}
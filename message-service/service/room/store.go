@@ -2,12 +2,15 @@ package room
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/hoyci/ms-chat/message-service/db"
 	"github.com/hoyci/ms-chat/message-service/types"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 var (
@@ -26,6 +29,18 @@ func GetRoomStore(dbRepo *db.MongoRepository) *RoomStore {
 	return instance
 }
 
+func (s *RoomStore) EnsureIndexes(ctx context.Context) error {
+	return db.CreateIndexes(s.dbRepo, ctx, "rooms", []mongo.IndexModel{
+		{Keys: bson.D{{Key: "users", Value: 1}}},
+		{
+			Keys: bson.D{{Key: "direct_key", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"direct_key": bson.M{"$type": "string"}}),
+		},
+	})
+}
+
 func (s *RoomStore) Create(ctx context.Context, newRoom types.Room) (bson.ObjectID, error) {
 	result, err := db.Add(
 		s.dbRepo,
@@ -47,7 +62,7 @@ func (s *RoomStore) GetByID(ctx context.Context, roomID string) (*types.Room, er
 		return nil, err
 	}
 
-	result, err := db.GetByFilter[types.Room](s.dbRepo, ctx, "rooms", bson.M{"_id": objectID})
+	result, err := db.GetByFilter[types.Room](s.dbRepo, ctx, "rooms", bson.M{"_id": objectID, "deleted_at": nil})
 
 	if err != nil {
 		return nil, err
@@ -56,16 +71,22 @@ func (s *RoomStore) GetByID(ctx context.Context, roomID string) (*types.Room, er
 	return result, nil
 }
 
-func (s *RoomStore) GetOrCreate(ctx context.Context, users []int) (*types.Room, error) {
+// GetOrCreate returns the direct room between users, creating it on first contact. Rooms
+// are matched on their direct key rather than on their users so a group that happens to
+// contain the same users is never picked.
+func (s *RoomStore) GetOrCreate(ctx context.Context, users []string) (*types.Room, error) {
+	directKey := types.DirectKey(users)
+
 	filter := bson.M{
-		"users": bson.M{
-			"$all": users,
-		},
+		"direct_key": directKey,
+		"type":       types.RoomTypeDirect,
 	}
 
 	result, err := db.GetOrCreate(s.dbRepo, ctx, "rooms", filter, types.Room{
 		ID:        bson.NewObjectID(),
-		Users:     users,
+		Type:      types.RoomTypeDirect,
+		DirectKey: directKey,
+		Users:     strings.Split(directKey, ":"),
 		CreatedAt: time.Now(),
 		UpdatedAt: nil,
 		DeletedAt: nil,
@@ -77,3 +98,195 @@ func (s *RoomStore) GetOrCreate(ctx context.Context, users []int) (*types.Room,
 
 	return result, nil
 }
+
+// ListByUser returns every room the user participates in, most recently active first.
+// The last message and the unread count are resolved with lookups on messages that
+// are served by the (room_id, created_at, _id) index. Neither counts messages deleted
+// for everyone or deleted by the user for themselves.
+func (s *RoomStore) ListByUser(ctx context.Context, userID string) ([]types.RoomSummary, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"users": userID, "deleted_at": nil}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "messages",
+			"localField":   "_id",
+			"foreignField": "room_id",
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"deleted_at": nil, "deleted_for": bson.M{"$ne": userID}}},
+				bson.M{"$sort": bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+				bson.M{"$limit": 1},
+			},
+			"as": "last_message",
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "messages",
+			"localField":   "_id",
+			"foreignField": "room_id",
+			"let": bson.M{
+				"last_read_at": bson.M{"$ifNull": bson.A{"$last_read_at." + userID, time.Time{}}},
+			},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{
+					"sender_id":   bson.M{"$ne": userID},
+					"deleted_at":  nil,
+					"deleted_for": bson.M{"$ne": userID},
+					"$expr":       bson.M{"$gt": bson.A{"$created_at", "$$last_read_at"}},
+				}},
+				bson.M{"$count": "count"},
+			},
+			"as": "unread",
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"last_message": bson.M{"$first": "$last_message"},
+			"unread_count": bson.M{"$ifNull": bson.A{bson.M{"$first": "$unread.count"}, 0}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"last_activity_at": bson.M{"$ifNull": bson.A{"$last_message.created_at", "$created_at"}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "last_activity_at", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$project", Value: bson.M{"unread": 0, "last_read_at": 0}}},
+	}
+
+	result, err := db.Aggregate[types.RoomSummary](s.dbRepo, ctx, "rooms", pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ListIDsByUser returns the IDs of every room the user participates in.
+func (s *RoomStore) ListIDsByUser(ctx context.Context, userID string) ([]bson.ObjectID, error) {
+	rooms, err := db.List[types.Room](
+		s.dbRepo,
+		ctx,
+		"rooms",
+		bson.M{"users": userID, "deleted_at": nil},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]bson.ObjectID, len(rooms))
+	for i, room := range rooms {
+		ids[i] = room.ID
+	}
+	return ids, nil
+}
+
+func (s *RoomStore) UpdateDetails(ctx context.Context, roomID bson.ObjectID, payload types.UpdateRoomPayload) (*types.Room, error) {
+	set := bson.M{"updated_at": time.Now()}
+	if payload.Name != nil {
+		set["name"] = *payload.Name
+	}
+	if payload.AvatarURL != nil {
+		set["avatar_url"] = *payload.AvatarURL
+	}
+
+	return s.update(ctx, bson.M{"_id": roomID}, bson.M{"$set": set})
+}
+
+// AddMembers appends members to a group. The update only matches when none of them
+// already belongs to the room so concurrent requests cannot duplicate a member.
+func (s *RoomStore) AddMembers(ctx context.Context, roomID bson.ObjectID, members []types.RoomMember) (*types.Room, error) {
+	userIDs := make([]string, 0, len(members))
+	for _, member := range members {
+		userIDs = append(userIDs, member.UserID)
+	}
+
+	return s.update(
+		ctx,
+		bson.M{"_id": roomID, "users": bson.M{"$nin": userIDs}},
+		bson.M{
+			"$push": bson.M{
+				"users":   bson.M{"$each": userIDs},
+				"members": bson.M{"$each": members},
+			},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+	)
+}
+
+func (s *RoomStore) RemoveMember(ctx context.Context, roomID bson.ObjectID, userID string) (*types.Room, error) {
+	return s.update(
+		ctx,
+		bson.M{"_id": roomID, "users": userID},
+		bson.M{
+			"$pull":  bson.M{"users": userID, "members": bson.M{"user_id": userID}},
+			"$unset": bson.M{"last_read_at." + userID: ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		},
+	)
+}
+
+func (s *RoomStore) SetMemberRole(ctx context.Context, roomID bson.ObjectID, userID string, role types.MemberRole) (*types.Room, error) {
+	return s.update(
+		ctx,
+		bson.M{"_id": roomID, "members.user_id": userID},
+		bson.M{"$set": bson.M{"members.$.role": role, "updated_at": time.Now()}},
+	)
+}
+
+// TransferOwnershipAndLeave hands the group over to successorID and removes its owner
+// in a single update, so the group is never left without an owner nor with a demoted
+// owner still in it. It only matches while ownerID owns the group and successorID is a
+// member; a concurrent change makes it fail with mongo.ErrNoDocuments.
+func (s *RoomStore) TransferOwnershipAndLeave(ctx context.Context, roomID bson.ObjectID, ownerID, successorID string) (*types.Room, error) {
+	// The members array is both filtered and changed, which a single update document
+	// cannot express, hence the pipeline.
+	remaining := bson.M{"$filter": bson.M{
+		"input": "$members",
+		"as":    "member",
+		"cond":  bson.M{"$ne": bson.A{"$$member.user_id", ownerID}},
+	}}
+	update := bson.A{
+		bson.M{"$set": bson.M{
+			"owner_id": successorID,
+			"users": bson.M{"$filter": bson.M{
+				"input": "$users",
+				"as":    "user",
+				"cond":  bson.M{"$ne": bson.A{"$$user", ownerID}},
+			}},
+			"members": bson.M{"$map": bson.M{
+				"input": remaining,
+				"as":    "member",
+				"in": bson.M{"$cond": bson.A{
+					bson.M{"$eq": bson.A{"$$member.user_id", successorID}},
+					bson.M{"$mergeObjects": bson.A{"$$member", bson.M{"role": types.RoleOwner}}},
+					"$$member",
+				}},
+			}},
+			"updated_at": time.Now(),
+		}},
+		bson.M{"$unset": "last_read_at." + ownerID},
+	}
+
+	return s.update(
+		ctx,
+		bson.M{"_id": roomID, "owner_id": ownerID, "members.user_id": successorID},
+		update,
+	)
+}
+
+func (s *RoomStore) Delete(ctx context.Context, roomID bson.ObjectID) error {
+	_, err := db.UpdateOne(s.dbRepo, ctx, "rooms", bson.M{"_id": roomID}, bson.M{"$set": bson.M{"deleted_at": time.Now()}})
+	return err
+}
+
+func (s *RoomStore) update(ctx context.Context, filter bson.M, update any) (*types.Room, error) {
+	filter["deleted_at"] = nil
+	return db.FindOneAndUpdate[types.Room](s.dbRepo, ctx, "rooms", filter, update)
+}
+
+// MarkRead moves the read marker of a member forward to at. The marker never moves back,
+// so read receipts arriving out of order are harmless.
+func (s *RoomStore) MarkRead(ctx context.Context, roomID bson.ObjectID, userID string, at time.Time) error {
+	_, err := db.UpdateOne(
+		s.dbRepo,
+		ctx,
+		"rooms",
+		bson.M{"_id": roomID},
+		bson.M{"$max": bson.M{"last_read_at." + userID: at}},
+	)
+	return err
+}
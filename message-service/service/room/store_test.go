@@ -0,0 +1,52 @@
+package room
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hoyci/ms-chat/message-service/db"
+	"github.com/hoyci/ms-chat/message-service/testutils/mongotest"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestListByUser(t *testing.T) {
+	ctx := context.Background()
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	otherUserID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+
+	t.Run("it should leave deleted messages out of the last message and the unread count", func(t *testing.T) {
+		store := &RoomStore{dbRepo: mongotest.NewRepository(t)}
+		roomID := bson.NewObjectID()
+		sentAt := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+		_, err := db.Add(store.dbRepo, ctx, "rooms", types.Room{
+			ID:        roomID,
+			Type:      types.RoomTypeDirect,
+			Users:     []string{userID, otherUserID},
+			CreatedAt: sentAt.Add(-time.Minute),
+		})
+		assert.NoError(t, err)
+
+		deletedAt := sentAt.Add(3 * time.Minute)
+		visible := types.Message{ID: bson.NewObjectID(), RoomID: roomID, SenderID: otherUserID, Content: "visible", CreatedAt: sentAt}
+		messages := []types.Message{
+			visible,
+			{ID: bson.NewObjectID(), RoomID: roomID, SenderID: otherUserID, CreatedAt: sentAt.Add(time.Minute), DeletedAt: &deletedAt},
+			{ID: bson.NewObjectID(), RoomID: roomID, SenderID: otherUserID, Content: "hidden", CreatedAt: sentAt.Add(2 * time.Minute), DeletedFor: []string{userID}},
+		}
+		for _, message := range messages {
+			_, err := db.Add(store.dbRepo, ctx, "messages", message)
+			assert.NoError(t, err)
+		}
+
+		rooms, err := store.ListByUser(ctx, userID)
+
+		assert.NoError(t, err)
+		assert.Len(t, rooms, 1)
+		assert.Equal(t, visible.ID, rooms[0].LastMessage.ID)
+		assert.Equal(t, 1, rooms[0].UnreadCount)
+		assert.True(t, sentAt.Equal(rooms[0].LastActivityAt))
+	})
+}
@@ -0,0 +1,214 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	coreMiddlewares "github.com/hoyci/ms-chat/core/middlewares"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	coreUtils "github.com/hoyci/ms-chat/core/utils"
+	"github.com/hoyci/ms-chat/message-service/keys"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 50
+	// maxOffset bounds how deep results can be paged through, as every page skips the
+	// results before it.
+	maxOffset      = 1000
+	maxQueryLength = 256
+)
+
+var validate = validator.New()
+
+type SearchHandler struct {
+	searcher  types.MessageSearcher
+	roomStore types.RoomStore
+}
+
+func NewSearchHandler(searcher types.MessageSearcher, roomStore types.RoomStore) *SearchHandler {
+	return &SearchHandler{searcher: searcher, roomStore: roomStore}
+}
+
+// RegisterRoutes adds the endpoints of the handler to the versioned API router.
+func (h *SearchHandler) RegisterRoutes(router *mux.Router) {
+	router.Handle(
+		"/messages/search", coreMiddlewares.AuthMiddleware(
+			http.HandlerFunc(h.HandleSearchMessages),
+			keys.PublicKeyAccess,
+		),
+	).Methods(http.MethodGet)
+}
+
+// HandleSearchMessages
+// @Summary Search the messages of the rooms of the user
+// @Description Results are ranked by relevance. Quoted phrases must match as a whole and words prefixed with - must not match.
+// @Tags Messages
+// @Produce json
+// @Security BearerAuth
+// @Param q query string true "Words to search for"
+// @Param room_id query string false "Only search this room"
+// @Param sender_id query string false "Only search messages sent by this user"
+// @Param from query string false "Only search messages sent at or after this RFC 3339 time"
+// @Param to query string false "Only search messages sent at or before this RFC 3339 time"
+// @Param limit query int false "Page size (default 20, max 50)"
+// @Param offset query int false "Number of results to skip (max 1000)"
+// @Success 200 {object} types.SearchMessagesResponse "Matching messages with highlighted snippets"
+// @Failure 400 {object} coreTypes.BadRequestResponse "Invalid query or filters"
+// @Failure 403 {object} coreTypes.ForbiddenResponse "User is not a member of the room"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /messages/search [get]
+func (h *SearchHandler) HandleSearchMessages(w http.ResponseWriter, r *http.Request) {
+	userID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			"HandleSearchMessages", coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	query, roomID, err := parseSearchQuery(r)
+	if err != nil {
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, err, "HandleSearchMessages",
+			coreTypes.BadRequestResponse{Error: err.Error()},
+		)
+		return
+	}
+	query.UserID = userID
+
+	roomIDs, err := h.roomStore.ListIDsByUser(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, err, "HandleSearchMessages")
+		return
+	}
+
+	if roomID != nil {
+		if !slices.Contains(roomIDs, *roomID) {
+			coreUtils.WriteError(
+				w, http.StatusForbidden, fmt.Errorf("user %s is not a member of room %s", userID, roomID.Hex()),
+				"HandleSearchMessages", coreTypes.ForbiddenResponse{Error: "You are not a member of this room"},
+			)
+			return
+		}
+		roomIDs = []bson.ObjectID{*roomID}
+	}
+
+	if len(roomIDs) == 0 {
+		_ = coreUtils.WriteJSON(w, http.StatusOK, types.SearchMessagesResponse{Results: []types.SearchHit{}})
+		return
+	}
+	query.RoomIDs = roomIDs
+
+	hits, hasMore, err := h.searcher.Search(r.Context(), query)
+	if err != nil {
+		writeStoreError(w, err, "HandleSearchMessages")
+		return
+	}
+
+	if hits == nil {
+		hits = []types.SearchHit{}
+	}
+
+	response := types.SearchMessagesResponse{Results: hits, HasMore: hasMore}
+	if hasMore {
+		next := query.Offset + len(hits)
+		response.NextOffset = &next
+	}
+
+	_ = coreUtils.WriteJSON(w, http.StatusOK, response)
+}
+
+// parseSearchQuery reads the query and filters of a search request, returning the room
+// it is restricted to apart since membership is checked by the caller.
+func parseSearchQuery(r *http.Request) (types.SearchQuery, *bson.ObjectID, error) {
+	params := r.URL.Query()
+	query := types.SearchQuery{Limit: defaultPageLimit}
+
+	query.Text = strings.TrimSpace(params.Get("q"))
+	if query.Text == "" {
+		return query, nil, fmt.Errorf("q is required")
+	}
+	if utf8.RuneCountInString(query.Text) > maxQueryLength {
+		return query, nil, fmt.Errorf("q must not exceed %d characters", maxQueryLength)
+	}
+
+	var roomID *bson.ObjectID
+	if rawRoomID := params.Get("room_id"); rawRoomID != "" {
+		id, err := bson.ObjectIDFromHex(rawRoomID)
+		if err != nil {
+			return query, nil, fmt.Errorf("room_id must be a valid room ID")
+		}
+		roomID = &id
+	}
+
+	if senderID := params.Get("sender_id"); senderID != "" {
+		if err := validate.Var(senderID, "uuid"); err != nil {
+			return query, nil, fmt.Errorf("sender_id must be a valid user ID")
+		}
+		query.SenderID = senderID
+	}
+
+	for _, bound := range []struct {
+		name   string
+		target **time.Time
+	}{{"from", &query.From}, {"to", &query.To}} {
+		raw := params.Get(bound.name)
+		if raw == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return query, nil, fmt.Errorf("%s must be an RFC 3339 time", bound.name)
+		}
+		*bound.target = &at
+	}
+	if query.From != nil && query.To != nil && query.From.After(*query.To) {
+		return query, nil, fmt.Errorf("from must not be after to")
+	}
+
+	if rawLimit := params.Get("limit"); rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return query, nil, fmt.Errorf("limit must be a number between 1 and %d", maxPageLimit)
+		}
+		query.Limit = limit
+	}
+
+	if rawOffset := params.Get("offset"); rawOffset != "" {
+		offset, err := strconv.Atoi(rawOffset)
+		if err != nil || offset < 0 || offset > maxOffset {
+			return query, nil, fmt.Errorf("offset must be a number between 0 and %d", maxOffset)
+		}
+		query.Offset = offset
+	}
+
+	return query, roomID, nil
+}
+
+func writeStoreError(w http.ResponseWriter, err error, handlerName string) {
+	if errors.Is(err, context.Canceled) {
+		coreUtils.WriteError(
+			w, http.StatusServiceUnavailable, err, handlerName,
+			coreTypes.ContextCanceledResponse{Error: "Request canceled"},
+		)
+		return
+	}
+
+	coreUtils.WriteError(
+		w, http.StatusInternalServerError, err, handlerName,
+		coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+	)
+}
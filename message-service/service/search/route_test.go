@@ -0,0 +1,142 @@
+package search_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hoyci/ms-chat/message-service/mocks"
+	"github.com/hoyci/ms-chat/message-service/service/search"
+	"github.com/hoyci/ms-chat/message-service/testutils"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func setupTestServer() (*mocks.MockMessageSearcher, *mocks.MockRoomStore, *mux.Router) {
+	mockSearcher := new(mocks.MockMessageSearcher)
+	mockRoomStore := new(mocks.MockRoomStore)
+	searchHandler := search.NewSearchHandler(mockSearcher, mockRoomStore)
+	router := testutils.NewRouter(searchHandler)
+	return mockSearcher, mockRoomStore, router
+}
+
+func TestHandleSearchMessages(t *testing.T) {
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	senderID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+	roomID := bson.NewObjectID()
+	otherRoomID := bson.NewObjectID()
+
+	t.Run("it should search every room of the user", func(t *testing.T) {
+		mockSearcher, mockRoomStore, router := setupTestServer()
+		hits := []types.SearchHit{
+			{
+				Message:    types.Message{ID: bson.NewObjectID(), RoomID: roomID, Content: "lunch at noon"},
+				Snippet:    "lunch at noon",
+				Highlights: []types.Highlight{{Start: 0, End: 5}},
+			},
+		}
+		mockRoomStore.On("ListIDsByUser", mock.Anything, userID).Return([]bson.ObjectID{roomID, otherRoomID}, nil)
+		mockSearcher.On("Search", mock.Anything, types.SearchQuery{
+			Text: "lunch", RoomIDs: []bson.ObjectID{roomID, otherRoomID}, UserID: userID, Limit: 20,
+		}).Return(hits, false, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/messages/search?q=lunch", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response types.SearchMessagesResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Len(t, response.Results, 1)
+		assert.Equal(t, "lunch at noon", response.Results[0].Snippet)
+		assert.False(t, response.HasMore)
+		assert.Nil(t, response.NextOffset)
+	})
+
+	t.Run("it should forward the filters and point to the next page", func(t *testing.T) {
+		mockSearcher, mockRoomStore, router := setupTestServer()
+		from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		to := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+		hits := []types.SearchHit{{Snippet: "a"}, {Snippet: "b"}}
+		mockRoomStore.On("ListIDsByUser", mock.Anything, userID).Return([]bson.ObjectID{roomID, otherRoomID}, nil)
+		mockSearcher.On("Search", mock.Anything, types.SearchQuery{
+			Text:     "lunch",
+			RoomIDs:  []bson.ObjectID{roomID},
+			SenderID: senderID,
+			From:     &from,
+			To:       &to,
+			UserID:   userID,
+			Offset:   4,
+			Limit:    2,
+		}).Return(hits, true, nil)
+
+		req := testutils.NewAuthenticatedRequest(
+			http.MethodGet,
+			"/api/v1/messages/search?q=lunch&room_id="+roomID.Hex()+"&sender_id="+senderID+
+				"&from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z&limit=2&offset=4",
+			userID,
+		)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response types.SearchMessagesResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.True(t, response.HasMore)
+		assert.Equal(t, 6, *response.NextOffset)
+		mockSearcher.AssertExpectations(t)
+	})
+
+	t.Run("it should reject searches in rooms the user does not belong to", func(t *testing.T) {
+		_, mockRoomStore, router := setupTestServer()
+		mockRoomStore.On("ListIDsByUser", mock.Anything, userID).Return([]bson.ObjectID{otherRoomID}, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/messages/search?q=lunch&room_id="+roomID.Hex(), userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.JSONEq(t, `{"error":"You are not a member of this room"}`, w.Body.String())
+	})
+
+	t.Run("it should return no results to users without rooms", func(t *testing.T) {
+		mockSearcher, mockRoomStore, router := setupTestServer()
+		mockRoomStore.On("ListIDsByUser", mock.Anything, userID).Return([]bson.ObjectID{}, nil)
+
+		req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/messages/search?q=lunch", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"results":[],"has_more":false}`, w.Body.String())
+		mockSearcher.AssertNotCalled(t, "Search", mock.Anything, mock.Anything)
+	})
+
+	t.Run("it should reject invalid queries", func(t *testing.T) {
+		cases := map[string]string{
+			"":                       "q is required",
+			"q=lunch&sender_id=nope": "sender_id must be a valid user ID",
+			"q=lunch&from=yesterday": "from must be an RFC 3339 time",
+			"q=lunch&from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z": "from must not be after to",
+			"q=lunch&limit=51":     "limit must be a number between 1 and 50",
+			"q=lunch&offset=-1":    "offset must be a number between 0 and 1000",
+			"q=lunch&room_id=nope": "room_id must be a valid room ID",
+		}
+
+		for query, message := range cases {
+			_, _, router := setupTestServer()
+
+			req := testutils.NewAuthenticatedRequest(http.MethodGet, "/api/v1/messages/search?"+query, userID)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+			assert.JSONEq(t, `{"error":"`+message+`"}`, w.Body.String(), query)
+		}
+	})
+}
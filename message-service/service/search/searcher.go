@@ -0,0 +1,104 @@
+package search
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hoyci/ms-chat/message-service/config"
+	"github.com/hoyci/ms-chat/message-service/db"
+	"github.com/hoyci/ms-chat/message-service/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	instance *TextSearcher
+	once     sync.Once
+)
+
+// TextSearcher searches messages through a MongoDB text index on their content.
+type TextSearcher struct {
+	dbRepo *db.MongoRepository
+}
+
+func GetTextSearcher(dbRepo *db.MongoRepository) *TextSearcher {
+	once.Do(func() {
+		instance = &TextSearcher{dbRepo: dbRepo}
+	})
+	return instance
+}
+
+// EnsureIndexes creates the text index searches rely on. Its language, "none" by
+// default, decides the stemming and stop words applied; "none" matches whole words
+// whatever language the messages are written in.
+func (s *TextSearcher) EnsureIndexes(ctx context.Context) error {
+	return db.CreateIndexes(s.dbRepo, ctx, "messages", []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "content", Value: "text"}},
+			Options: options.Index().SetName("content_text").SetDefaultLanguage(config.Envs.SearchLanguage),
+		},
+	})
+}
+
+// scoredMessage is a message along with the relevance MongoDB gave it.
+type scoredMessage struct {
+	types.Message `bson:",inline"`
+	Score         float64 `bson:"score"`
+}
+
+func (s *TextSearcher) Search(ctx context.Context, query types.SearchQuery) ([]types.SearchHit, bool, error) {
+	filter := bson.M{
+		"$text":      bson.M{"$search": query.Text},
+		"room_id":    bson.M{"$in": query.RoomIDs},
+		"deleted_at": nil,
+	}
+	if query.UserID != "" {
+		filter["deleted_for"] = bson.M{"$ne": query.UserID}
+	}
+	if query.SenderID != "" {
+		filter["sender_id"] = query.SenderID
+	}
+
+	createdAt := bson.M{}
+	if query.From != nil {
+		createdAt["$gte"] = *query.From
+	}
+	if query.To != nil {
+		createdAt["$lte"] = *query.To
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(query.Offset)).
+		SetLimit(int64(query.Limit + 1))
+
+	found, err := db.List[scoredMessage](s.dbRepo, ctx, "messages", filter, opts)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(found) > query.Limit
+	if hasMore {
+		found = found[:query.Limit]
+	}
+
+	terms := queryTerms(query.Text)
+	hits := make([]types.SearchHit, 0, len(found))
+	for _, message := range found {
+		snippet, highlights := highlight(message.Content, terms)
+		hits = append(hits, types.SearchHit{
+			Message:    message.Message,
+			Snippet:    snippet,
+			Highlights: highlights,
+			Score:      message.Score,
+		})
+	}
+
+	return hits, hasMore, nil
+}
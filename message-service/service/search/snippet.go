@@ -0,0 +1,123 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/hoyci/ms-chat/message-service/types"
+)
+
+const (
+	// snippetLength is the number of runes of a message shown in search results.
+	snippetLength = 160
+	// snippetLeadIn is the number of runes kept before the first match when a message
+	// is too long to be shown whole.
+	snippetLeadIn = 40
+	// minPrefixLength is the shortest word or term matched on a prefix, so stemmed
+	// matches such as "run" for "running" are highlighted too.
+	minPrefixLength = 3
+)
+
+// queryTerms extracts the words of a search query, leaving out the negated ones.
+func queryTerms(query string) []string {
+	var terms []string
+	seen := map[string]bool{}
+
+	for _, field := range strings.Fields(query) {
+		if strings.HasPrefix(field, "-") {
+			continue
+		}
+
+		for _, word := range strings.FieldsFunc(field, isSeparator) {
+			word = strings.ToLower(word)
+			if !seen[word] {
+				seen[word] = true
+				terms = append(terms, word)
+			}
+		}
+	}
+
+	return terms
+}
+
+// highlight picks the part of content to show for a search and the ranges of it that
+// match terms. Short contents are shown whole; longer ones are cut around the first
+// match, with ellipses marking what was left out.
+func highlight(content string, terms []string) (string, []types.Highlight) {
+	runes := []rune(content)
+	matches := matchWords(runes, terms)
+
+	if len(runes) <= snippetLength {
+		return content, matches
+	}
+
+	start := 0
+	if len(matches) > 0 {
+		start = max(matches[0].Start-snippetLeadIn, 0)
+	}
+	end := min(start+snippetLength, len(runes))
+	start = max(end-snippetLength, 0)
+
+	var snippet strings.Builder
+	offset := -start
+	if start > 0 {
+		snippet.WriteRune('…')
+		offset++
+	}
+	snippet.WriteString(string(runes[start:end]))
+	if end < len(runes) {
+		snippet.WriteRune('…')
+	}
+
+	highlights := []types.Highlight{}
+	for _, match := range matches {
+		if match.Start >= start && match.End <= end {
+			highlights = append(highlights, types.Highlight{Start: match.Start + offset, End: match.End + offset})
+		}
+	}
+
+	return snippet.String(), highlights
+}
+
+// matchWords returns the rune ranges of the words of content matching one of terms.
+func matchWords(content []rune, terms []string) []types.Highlight {
+	highlights := []types.Highlight{}
+
+	for start := 0; start < len(content); {
+		if isSeparator(content[start]) {
+			start++
+			continue
+		}
+
+		end := start
+		for end < len(content) && !isSeparator(content[end]) {
+			end++
+		}
+
+		if word := strings.ToLower(string(content[start:end])); matchesAny(word, terms) {
+			highlights = append(highlights, types.Highlight{Start: start, End: end})
+		}
+		start = end
+	}
+
+	return highlights
+}
+
+func matchesAny(word string, terms []string) bool {
+	for _, term := range terms {
+		if word == term {
+			return true
+		}
+		if len([]rune(word)) < minPrefixLength || len([]rune(term)) < minPrefixLength {
+			continue
+		}
+		if strings.HasPrefix(word, term) || strings.HasPrefix(term, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
@@ -0,0 +1,53 @@
+package search
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hoyci/ms-chat/message-service/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryTerms(t *testing.T) {
+	t.Run("it should keep the lowercased words of the query but the negated ones", func(t *testing.T) {
+		assert.Equal(t, []string{"lunch", "friday", "team"}, queryTerms(`Lunch "friday TEAM" -dinner lunch`))
+	})
+}
+
+func TestHighlight(t *testing.T) {
+	t.Run("it should show short messages whole with the matching words", func(t *testing.T) {
+		snippet, highlights := highlight("Running late, see you at lunch!", []string{"run", "lunch"})
+
+		assert.Equal(t, "Running late, see you at lunch!", snippet)
+		assert.Equal(t, []types.Highlight{{Start: 0, End: 7}, {Start: 25, End: 30}}, highlights)
+	})
+
+	t.Run("it should only match short terms as whole words", func(t *testing.T) {
+		_, highlights := highlight("a cat ate an apple", []string{"a"})
+
+		assert.Equal(t, []types.Highlight{{Start: 0, End: 1}}, highlights)
+	})
+
+	t.Run("it should cut long messages around the first match", func(t *testing.T) {
+		content := strings.Repeat("filler ", 50) + "the déjà vu moment " + strings.Repeat("filler ", 50)
+
+		snippet, highlights := highlight(content, []string{"déjà"})
+
+		runes := []rune(snippet)
+		assert.Equal(t, snippetLength+2, len(runes))
+		assert.True(t, strings.HasPrefix(snippet, "…"))
+		assert.True(t, strings.HasSuffix(snippet, "…"))
+		assert.Len(t, highlights, 1)
+		assert.Equal(t, "déjà", string(runes[highlights[0].Start:highlights[0].End]))
+	})
+
+	t.Run("it should start long messages without matches at their beginning", func(t *testing.T) {
+		content := strings.Repeat("word ", 100)
+
+		snippet, highlights := highlight(content, []string{"other"})
+
+		assert.True(t, strings.HasPrefix(snippet, "word"))
+		assert.True(t, strings.HasSuffix(snippet, "…"))
+		assert.Empty(t, highlights)
+	})
+}
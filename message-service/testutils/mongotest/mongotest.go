@@ -0,0 +1,32 @@
+// Package mongotest connects tests to the MongoDB named by MONGO_TEST_URL. Tests using it
+// are skipped when the variable is not set.
+package mongotest
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/hoyci/ms-chat/message-service/config"
+	"github.com/hoyci/ms-chat/message-service/db"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// NewRepository returns a repository on a database of its own, dropped once the test
+// is over.
+func NewRepository(t *testing.T) *db.MongoRepository {
+	t.Helper()
+
+	url := os.Getenv("MONGO_TEST_URL")
+	if url == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+
+	repo := db.NewMongoRepository(config.Config{DatabaseURL: url, DatabaseName: "test_" + bson.NewObjectID().Hex()})
+	t.Cleanup(func() {
+		if err := repo.DropDatabase(context.Background()); err != nil {
+			t.Logf("failed to drop the test database: %v", err)
+		}
+	})
+	return repo
+}
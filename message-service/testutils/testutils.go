@@ -0,0 +1,54 @@
+// Package testutils holds the scaffolding shared by the HTTP tests of the service
+// packages: a router serving their handlers and requests authenticated for any user.
+package testutils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	coreUtils "github.com/hoyci/ms-chat/core/utils"
+	"github.com/hoyci/ms-chat/message-service/cmd/api"
+	"github.com/hoyci/ms-chat/message-service/keys"
+)
+
+var (
+	keyOnce    sync.Once
+	privateKey *rsa.PrivateKey
+)
+
+// signingKey generates, once per test binary, the key test tokens are signed with and
+// makes the auth middleware trust it.
+func signingKey() *rsa.PrivateKey {
+	keyOnce.Do(func() {
+		var err error
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(err)
+		}
+		keys.PublicKeyAccess = &privateKey.PublicKey
+	})
+	return privateKey
+}
+
+// NewRouter serves the endpoints of handlers the way the API server does.
+func NewRouter(handlers ...api.Routes) *mux.Router {
+	// The middleware is given the public key when the routes are registered.
+	signingKey()
+	return api.NewApiServer(":8082").SetupRouter(handlers...)
+}
+
+func NewAuthenticatedRequest(method, url, userID string) *http.Request {
+	return NewAuthenticatedRequestWithBody(method, url, userID, "")
+}
+
+func NewAuthenticatedRequestWithBody(method, url, userID, body string) *http.Request {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	token := coreUtils.GenerateTestToken(userID, "JohnDoe", "johndoe@example.com", signingKey())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
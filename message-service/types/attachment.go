@@ -0,0 +1,65 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps the content of attachments under opaque keys.
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+type AttachmentStore interface {
+	Create(ctx context.Context, attachment Attachment) error
+	GetByID(ctx context.Context, attachmentID string) (*Attachment, error)
+	ListByIDs(ctx context.Context, ids []bson.ObjectID) ([]Attachment, error)
+}
+
+// Attachment is a file uploaded to a room, described in the attachments collection and
+// copied into the messages it is attached to. Its content, and the thumbnail of images,
+// live in the BlobStore. DeletedAt is set when a message carrying it is deleted for
+// everyone, after which the attachment can no longer be downloaded.
+type Attachment struct {
+	ID           bson.ObjectID `json:"_id" bson:"_id"`
+	RoomID       bson.ObjectID `json:"room_id" bson:"room_id"`
+	UploaderID   string        `json:"uploader_id" bson:"uploader_id"`
+	Filename     string        `json:"filename" bson:"filename"`
+	ContentType  string        `json:"content_type" bson:"content_type"`
+	Size         int64         `json:"size" bson:"size"`
+	Width        int           `json:"width,omitempty" bson:"width,omitempty"`
+	Height       int           `json:"height,omitempty" bson:"height,omitempty"`
+	StorageKey   string        `json:"-" bson:"storage_key"`
+	ThumbnailKey string        `json:"-" bson:"thumbnail_key,omitempty"`
+	CreatedAt    time.Time     `json:"created_at" bson:"created_at"`
+	DeletedAt    *time.Time    `json:"-" bson:"deleted_at,omitempty"`
+}
+
+func (a Attachment) HasThumbnail() bool {
+	return a.ThumbnailKey != ""
+}
+
+func (a Attachment) ToCore() coreTypes.Attachment {
+	return coreTypes.Attachment{
+		ID:           a.ID.Hex(),
+		Filename:     a.Filename,
+		ContentType:  a.ContentType,
+		Size:         a.Size,
+		Width:        a.Width,
+		Height:       a.Height,
+		HasThumbnail: a.HasThumbnail(),
+	}
+}
+
+type AttachmentResponse struct {
+	Attachment *Attachment `json:"attachment"`
+}
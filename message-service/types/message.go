@@ -0,0 +1,243 @@
+package types
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type MessageStore interface {
+	Create(ctx context.Context, newMessage map[string]any) (bson.ObjectID, error)
+	ListByRoom(ctx context.Context, roomID bson.ObjectID, page MessagePage) ([]Message, bool, error)
+	ListByIDs(ctx context.Context, ids []bson.ObjectID) ([]Message, error)
+	ListReceivedBetween(ctx context.Context, roomID bson.ObjectID, userID string, after, upTo time.Time) ([]Message, error)
+	AdvanceStatus(ctx context.Context, ids []bson.ObjectID, status coreTypes.Status) (int64, error)
+	MarkDelivered(ctx context.Context, ids []bson.ObjectID, userID string) error
+	ListPending(ctx context.Context, roomIDs []bson.ObjectID, userID string, limit int) ([]Message, error)
+	ListAfter(ctx context.Context, roomIDs []bson.ObjectID, userID string, after Message, limit int) ([]Message, error)
+	GetByID(ctx context.Context, messageID string) (*Message, error)
+	GetByIdempotencyKey(ctx context.Context, senderID, key string) (*Message, error)
+	Edit(ctx context.Context, message Message, content string, at time.Time) (*Message, error)
+	DeleteForEveryone(ctx context.Context, messageID bson.ObjectID, at time.Time) (*Message, error)
+	HideFor(ctx context.Context, messageID bson.ObjectID, userID string) error
+	ListRevisions(ctx context.Context, messageID bson.ObjectID) ([]MessageRevision, error)
+	ListThread(ctx context.Context, rootID bson.ObjectID, page MessagePage) ([]Message, bool, error)
+	RecountReplies(ctx context.Context, rootID bson.ObjectID) error
+	AddReaction(ctx context.Context, reaction Reaction) (bool, error)
+	RemoveReaction(ctx context.Context, messageID bson.ObjectID, userID, emoji string) (bool, error)
+}
+
+// Broadcaster asks ws-service to deliver events to the devices of users.
+type Broadcaster interface {
+	PublishBroadcast(ctx context.Context, broadcast coreTypes.BroadcastMessage) error
+}
+
+// Message is a chat message as persisted in the messages collection. A message deleted
+// for everyone keeps its place in the history with DeletedAt set and no content;
+// DeletedFor lists the users who deleted it only for themselves. Replies point to the
+// message they answer and to the root of their thread, whose ReplyCount counts every
+// reply of the thread. Reactions maps each emoji to the users who reacted with it.
+// IdempotencyKey is the envelope ID the sender sent the message with, unique per sender.
+// DeliveredTo lists the recipients who received the message, which Status alone cannot
+// tell in groups.
+type Message struct {
+	ID             bson.ObjectID       `json:"_id" bson:"_id"`
+	RoomID         bson.ObjectID       `json:"room_id" bson:"room_id"`
+	SenderID       string              `json:"sender_id" bson:"sender_id"`
+	ReceiverID     string              `json:"receiver_id" bson:"receiver_id"`
+	Content        string              `json:"content" bson:"content"`
+	Status         coreTypes.Status    `json:"status" bson:"status"`
+	ReplyTo        *bson.ObjectID      `json:"reply_to,omitempty" bson:"reply_to,omitempty"`
+	ThreadID       *bson.ObjectID      `json:"thread_id,omitempty" bson:"thread_id,omitempty"`
+	Quote          *Quote              `json:"quote,omitempty" bson:"quote,omitempty"`
+	ReplyCount     int                 `json:"reply_count" bson:"reply_count,omitempty"`
+	Reactions      map[string][]string `json:"reactions,omitempty" bson:"reactions,omitempty"`
+	Attachments    []Attachment        `json:"attachments,omitempty" bson:"attachments,omitempty"`
+	CreatedAt      time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt      *time.Time          `json:"updated_at" bson:"updated_at"`
+	DeletedAt      *time.Time          `json:"deleted_at" bson:"deleted_at"`
+	DeletedFor     []string            `json:"-" bson:"deleted_for,omitempty"`
+	DeliveredTo    []string            `json:"-" bson:"delivered_to,omitempty"`
+	IdempotencyKey string              `json:"-" bson:"idempotency_key,omitempty"`
+}
+
+// Quote is the excerpt of the message a reply answers, copied into the reply so it is
+// delivered and listed along with it.
+type Quote struct {
+	MessageID bson.ObjectID `json:"message_id" bson:"message_id"`
+	SenderID  string        `json:"sender_id" bson:"sender_id"`
+	Snippet   string        `json:"snippet" bson:"snippet"`
+	Deleted   bool          `json:"deleted,omitempty" bson:"deleted,omitempty"`
+}
+
+// maxSnippetLength is the number of characters of a message kept in the quotes of its
+// replies.
+const maxSnippetLength = 140
+
+// QuoteOf builds the quote replies to m carry.
+func QuoteOf(m Message) *Quote {
+	snippet := []rune(m.Content)
+	if len(snippet) > maxSnippetLength {
+		snippet = append(snippet[:maxSnippetLength], '…')
+	}
+
+	return &Quote{
+		MessageID: m.ID,
+		SenderID:  m.SenderID,
+		Snippet:   string(snippet),
+		Deleted:   m.DeletedAt != nil,
+	}
+}
+
+// ToCore converts a stored message to the representation devices receive.
+func (m Message) ToCore() coreTypes.Message {
+	message := coreTypes.Message{
+		ID:             m.ID.Hex(),
+		RoomID:         m.RoomID.Hex(),
+		SenderID:       m.SenderID,
+		ReceiverID:     m.ReceiverID,
+		Content:        m.Content,
+		Status:         m.Status,
+		ReplyCount:     m.ReplyCount,
+		Reactions:      m.Reactions,
+		IdempotencyKey: m.IdempotencyKey,
+		CreatedAt:      m.CreatedAt,
+		UpdatedAt:      m.UpdatedAt,
+		DeletedAt:      m.DeletedAt,
+	}
+
+	if m.ReplyTo != nil {
+		message.ReplyTo = m.ReplyTo.Hex()
+	}
+	if m.ThreadID != nil {
+		message.ThreadID = m.ThreadID.Hex()
+	}
+	if m.Quote != nil {
+		message.Quote = &coreTypes.Quote{
+			MessageID: m.Quote.MessageID.Hex(),
+			SenderID:  m.Quote.SenderID,
+			Snippet:   m.Quote.Snippet,
+			Deleted:   m.Quote.Deleted,
+		}
+	}
+	for _, attachment := range m.Attachments {
+		message.Attachments = append(message.Attachments, attachment.ToCore())
+	}
+
+	return message
+}
+
+// MessagePage selects a window of a room history as seen by UserID. Before and After
+// are message IDs used as exclusive cursors and are mutually exclusive; with neither set
+// the most recent messages are returned.
+type MessagePage struct {
+	UserID string
+	Before *bson.ObjectID
+	After  *bson.ObjectID
+	Limit  int
+}
+
+type ListMessagesResponse struct {
+	Messages []Message `json:"messages"`
+	HasMore  bool      `json:"has_more"`
+}
+
+// MessageRevision is a previous content of an edited message, kept in the
+// message_revisions collection until the message is deleted for everyone. WrittenAt is
+// when that content was written and, with MessageID, identifies the revision.
+type MessageRevision struct {
+	ID        bson.ObjectID `json:"_id" bson:"_id"`
+	MessageID bson.ObjectID `json:"message_id" bson:"message_id"`
+	Content   string        `json:"content" bson:"content"`
+	WrittenAt time.Time     `json:"-" bson:"written_at,omitempty"`
+	EditedAt  time.Time     `json:"edited_at" bson:"edited_at"`
+}
+
+type ThreadResponse struct {
+	Root    *Message  `json:"root"`
+	Replies []Message `json:"replies"`
+	HasMore bool      `json:"has_more"`
+}
+
+type EditMessagePayload struct {
+	Content string `json:"content" validate:"required"`
+}
+
+type MessageResponse struct {
+	Message *Message `json:"message"`
+}
+
+type ListRevisionsResponse struct {
+	Revisions []MessageRevision `json:"revisions"`
+}
+
+// Reaction is the reaction of a user to a message, kept in the message_reactions
+// collection where each (message, user, emoji) is unique. Messages carry the same
+// reactions grouped by emoji.
+type Reaction struct {
+	ID        bson.ObjectID `json:"_id" bson:"_id"`
+	MessageID bson.ObjectID `json:"message_id" bson:"message_id"`
+	RoomID    bson.ObjectID `json:"room_id" bson:"room_id"`
+	UserID    string        `json:"user_id" bson:"user_id"`
+	Emoji     string        `json:"emoji" bson:"emoji"`
+	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+}
+
+// maxEmojiLength leaves room for the longest emoji sequences, such as families joined
+// by zero-width joiners.
+const maxEmojiLength = 16
+
+// ValidEmoji reports whether emoji looks like an emoji: a short sequence holding at least
+// one non-ASCII character, without whitespace. Emoji are used as document keys, so they
+// may not contain dots or start with a dollar sign either.
+func ValidEmoji(emoji string) bool {
+	runes := []rune(emoji)
+	if len(runes) == 0 || len(runes) > maxEmojiLength {
+		return false
+	}
+
+	hasNonASCII := false
+	for _, r := range runes {
+		if r > unicode.MaxASCII {
+			hasNonASCII = true
+		}
+		if unicode.IsSpace(r) || unicode.IsControl(r) || r == '.' || r == '$' {
+			return false
+		}
+	}
+	return hasNonASCII
+}
+
+type ReactionSummary struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	UserIDs []string `json:"user_ids"`
+}
+
+// SummarizeReactions lists the reactions of a message by emoji, most used first.
+func SummarizeReactions(reactions map[string][]string) []ReactionSummary {
+	summaries := make([]ReactionSummary, 0, len(reactions))
+	for emoji, userIDs := range reactions {
+		if len(userIDs) == 0 {
+			continue
+		}
+		summaries = append(summaries, ReactionSummary{Emoji: emoji, Count: len(userIDs), UserIDs: userIDs})
+	}
+
+	slices.SortFunc(summaries, func(a, b ReactionSummary) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Emoji, b.Emoji)
+	})
+	return summaries
+}
+
+type ListReactionsResponse struct {
+	Reactions []ReactionSummary `json:"reactions"`
+}
@@ -1,18 +1,94 @@
 package types
 
 import (
+	"context"
 	"encoding/json"
+	"slices"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
+type RoomStore interface {
+	Create(ctx context.Context, newRoom Room) (bson.ObjectID, error)
+	GetByID(ctx context.Context, roomID string) (*Room, error)
+	GetOrCreate(ctx context.Context, users []string) (*Room, error)
+	ListByUser(ctx context.Context, userID string) ([]RoomSummary, error)
+	ListIDsByUser(ctx context.Context, userID string) ([]bson.ObjectID, error)
+	UpdateDetails(ctx context.Context, roomID bson.ObjectID, payload UpdateRoomPayload) (*Room, error)
+	AddMembers(ctx context.Context, roomID bson.ObjectID, members []RoomMember) (*Room, error)
+	RemoveMember(ctx context.Context, roomID bson.ObjectID, userID string) (*Room, error)
+	SetMemberRole(ctx context.Context, roomID bson.ObjectID, userID string, role MemberRole) (*Room, error)
+	TransferOwnershipAndLeave(ctx context.Context, roomID bson.ObjectID, ownerID, successorID string) (*Room, error)
+	Delete(ctx context.Context, roomID bson.ObjectID) error
+	MarkRead(ctx context.Context, roomID bson.ObjectID, userID string, at time.Time) error
+}
+
+type RoomType string
+
+const (
+	RoomTypeDirect RoomType = "direct"
+	RoomTypeGroup  RoomType = "group"
+)
+
+type MemberRole string
+
+const (
+	RoleOwner  MemberRole = "owner"
+	RoleAdmin  MemberRole = "admin"
+	RoleMember MemberRole = "member"
+)
+
+// rank orders roles so permission checks can compare them.
+func (r MemberRole) rank() int {
+	switch r {
+	case RoleOwner:
+		return 3
+	case RoleAdmin:
+		return 2
+	case RoleMember:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// AtLeast reports whether r grants every permission of other.
+func (r MemberRole) AtLeast(other MemberRole) bool {
+	return r.rank() >= other.rank()
+}
+
+type RoomMember struct {
+	UserID   string     `json:"user_id" bson:"user_id"`
+	Role     MemberRole `json:"role" bson:"role"`
+	JoinedAt time.Time  `json:"joined_at" bson:"joined_at"`
+}
+
+// Room is either a direct conversation between two users or a group. Users lists the
+// participant IDs of both kinds and backs membership queries; DirectKey is only set on
+// direct rooms, and Members, Name, AvatarURL and OwnerID only on groups.
 type Room struct {
-	ID        bson.ObjectID `json:"_id" bson:"_id"`
-	Users     []int         `json:"users" bson:"users"`
-	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
-	UpdatedAt *time.Time    `json:"updated_at" bson:"updated_at"`
-	DeletedAt *time.Time    `json:"deleted_at" bson:"deleted_at"`
+	ID         bson.ObjectID        `json:"_id" bson:"_id"`
+	Type       RoomType             `json:"type" bson:"type"`
+	DirectKey  string               `json:"-" bson:"direct_key,omitempty"`
+	Name       string               `json:"name,omitempty" bson:"name,omitempty"`
+	AvatarURL  string               `json:"avatar_url,omitempty" bson:"avatar_url,omitempty"`
+	OwnerID    string               `json:"owner_id,omitempty" bson:"owner_id,omitempty"`
+	Users      []string             `json:"users" bson:"users"`
+	Members    []RoomMember         `json:"members,omitempty" bson:"members,omitempty"`
+	LastReadAt map[string]time.Time `json:"-" bson:"last_read_at,omitempty"`
+	CreatedAt  time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt  *time.Time           `json:"updated_at" bson:"updated_at"`
+	DeletedAt  *time.Time           `json:"deleted_at" bson:"deleted_at"`
+}
+
+// DirectKey identifies the direct room between users whatever order they are given in.
+// Rooms store it under a unique index so each pair of users has exactly one direct room.
+func DirectKey(users []string) string {
+	participants := slices.Clone(users)
+	slices.Sort(participants)
+	return strings.Join(slices.Compact(participants), ":")
 }
 
 func (r Room) MarshalJSON() ([]byte, error) {
@@ -26,12 +102,81 @@ func (r Room) MarshalJSON() ([]byte, error) {
 	})
 }
 
-// type CreateRoomPayload struct {
-// 	RoomName string `bson:"room_name" json:"room_name" validate:"required,min=5"`
-// }
+func (r Room) IsMember(userID string) bool {
+	for _, user := range r.Users {
+		if user == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Member returns the group membership of userID.
+func (r Room) Member(userID string) (RoomMember, bool) {
+	for _, member := range r.Members {
+		if member.UserID == userID {
+			return member, true
+		}
+	}
+	return RoomMember{}, false
+}
 
-// type CreateRoomResponse struct {
-// 	ObjectID bson.ObjectID `json:"-" bson:"_id"`
-// 	RoomID   string        `json:"room_id" bson:"-"`
-// 	Message  string        `json:"message"`
-// }
+// Successor picks who inherits ownership when the owner leaves: the longest-standing
+// admin, or the longest-standing member when there are no other admins.
+func (r Room) Successor() (RoomMember, bool) {
+	var successor RoomMember
+	found := false
+	for _, member := range r.Members {
+		if member.UserID == r.OwnerID {
+			continue
+		}
+		if !found ||
+			member.Role.rank() > successor.Role.rank() ||
+			(member.Role == successor.Role && member.JoinedAt.Before(successor.JoinedAt)) {
+			successor = member
+			found = true
+		}
+	}
+	return successor, found
+}
+
+// RoomSummary is a room as listed to one of its members: the latest message and how
+// many messages from other members arrived after the member last read the room.
+type RoomSummary struct {
+	ID             bson.ObjectID `json:"_id" bson:"_id"`
+	Type           RoomType      `json:"type" bson:"type"`
+	Name           string        `json:"name,omitempty" bson:"name,omitempty"`
+	AvatarURL      string        `json:"avatar_url,omitempty" bson:"avatar_url,omitempty"`
+	Users          []string      `json:"users" bson:"users"`
+	LastMessage    *Message      `json:"last_message" bson:"last_message"`
+	UnreadCount    int           `json:"unread_count" bson:"unread_count"`
+	LastActivityAt time.Time     `json:"last_activity_at" bson:"last_activity_at"`
+	CreatedAt      time.Time     `json:"created_at" bson:"created_at"`
+}
+
+type ListRoomsResponse struct {
+	Rooms []RoomSummary `json:"rooms"`
+}
+
+type CreateRoomPayload struct {
+	Name      string   `json:"name" validate:"required,min=1,max=100"`
+	AvatarURL string   `json:"avatar_url" validate:"omitempty,url"`
+	Members   []string `json:"members" validate:"required,min=1,dive,uuid"`
+}
+
+type UpdateRoomPayload struct {
+	Name      *string `json:"name" validate:"omitempty,min=1,max=100"`
+	AvatarURL *string `json:"avatar_url" validate:"omitempty,url"`
+}
+
+type AddMembersPayload struct {
+	Members []string `json:"members" validate:"required,min=1,dive,uuid"`
+}
+
+type UpdateMemberRolePayload struct {
+	Role MemberRole `json:"role" validate:"required,oneof=admin member"`
+}
+
+type RoomResponse struct {
+	Room *Room `json:"room"`
+}
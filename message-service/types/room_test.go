@@ -0,0 +1,33 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirectKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		users []string
+		want  string
+	}{
+		{name: "it should sort the users", users: []string{"bob", "alice"}, want: "alice:bob"},
+		{name: "it should not depend on the order given", users: []string{"alice", "bob"}, want: "alice:bob"},
+		{name: "it should drop repeated users", users: []string{"bob", "alice", "bob"}, want: "alice:bob"},
+		{name: "it should key a conversation with oneself", users: []string{"alice", "alice"}, want: "alice"},
+		{name: "it should key no users as empty", users: nil, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, DirectKey(tt.users))
+		})
+	}
+
+	t.Run("it should leave the given users untouched", func(t *testing.T) {
+		users := []string{"bob", "alice", "bob"}
+		DirectKey(users)
+		assert.Equal(t, []string{"bob", "alice", "bob"}, users)
+	})
+}
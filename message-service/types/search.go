@@ -0,0 +1,49 @@
+package types
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// MessageSearcher finds messages matching a full-text query. Any engine able to filter
+// on rooms, sender and date and to rank by relevance can back it.
+type MessageSearcher interface {
+	Search(ctx context.Context, query SearchQuery) ([]SearchHit, bool, error)
+}
+
+// SearchQuery restricts a search to RoomIDs, and optionally to messages sent by SenderID
+// between From and To. Messages deleted for everyone or hidden by UserID never match.
+// Results are ranked by relevance and paginated by Offset.
+type SearchQuery struct {
+	Text     string
+	RoomIDs  []bson.ObjectID
+	SenderID string
+	From     *time.Time
+	To       *time.Time
+	UserID   string
+	Offset   int
+	Limit    int
+}
+
+// SearchHit is a message matching a search along with the part of its content to show,
+// Highlights giving the rune ranges of Snippet that matched the query.
+type SearchHit struct {
+	Message    Message     `json:"message"`
+	Snippet    string      `json:"snippet"`
+	Highlights []Highlight `json:"highlights"`
+	Score      float64     `json:"score"`
+}
+
+// Highlight is a range of runes, End excluded.
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type SearchMessagesResponse struct {
+	Results    []SearchHit `json:"results"`
+	HasMore    bool        `json:"has_more"`
+	NextOffset *int        `json:"next_offset,omitempty"`
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	amqp "github.com/rabbitmq/amqp091-go"
 	"log"
 	"net/http"
 
 	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/hoyci/ms-chat/ws-service/keys"
+	"github.com/hoyci/ms-chat/ws-service/service/healthcheck"
+	"github.com/hoyci/ms-chat/ws-service/service/presence"
 	"github.com/hoyci/ms-chat/ws-service/service/rabbitmq"
+	"github.com/hoyci/ms-chat/ws-service/service/registry"
 	"github.com/hoyci/ms-chat/ws-service/service/websocket"
 	"github.com/hoyci/ms-chat/ws-service/utils"
 )
@@ -15,17 +19,23 @@ import (
 func main() {
 	path := fmt.Sprintf("0.0.0.0:%d", config.Envs.Port)
 
-	rabbitmq.Init()
-	defer func(channel *amqp.Channel) {
-		err := channel.Close()
-		if err != nil {
+	keys.LoadRunKeys()
 
-		}
-	}(rabbitmq.GetChannel())
+	rabbitmq.Init()
+	registry.Init()
+	go registry.Heartbeat(context.Background())
 
 	utils.InitValidator()
 
+	websocket.Init(rabbitmq.NewBus())
+	go websocket.RelayOutbox(context.Background())
+
 	websocket.RegisterRoutes()
+	presence.RegisterRoutes()
+	healthcheck.NewHealthCheckHandler(config.Envs, rabbitmq.Monitor{}).RegisterRoutes()
+	go websocket.StartBroadcastConsumer(context.Background())
+	go websocket.StartNodeConsumer(context.Background())
+	go websocket.StartUserEventsConsumer(context.Background())
 
 	log.Println("Listening on:", path)
 	err := http.ListenAndServe(path, nil)
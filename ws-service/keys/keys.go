@@ -0,0 +1,23 @@
+package keys
+
+import (
+	"crypto/rsa"
+	"log"
+
+	coreUtils "github.com/hoyci/ms-chat/core/utils"
+	"github.com/hoyci/ms-chat/ws-service/config"
+)
+
+var PublicKeyAccess *rsa.PublicKey
+
+func LoadRunKeys() {
+	PublicKeyAccess = loadKey(config.Envs.KeysPath, config.Envs.PublicKeyFilename, false).(*rsa.PublicKey)
+}
+
+func loadKey(path, filename string, isPrivate bool) interface{} {
+	key, err := coreUtils.LoadRSAKey(path, filename, isPrivate)
+	if err != nil {
+		log.Fatalf("Failed to load key: %v", err)
+	}
+	return key
+}
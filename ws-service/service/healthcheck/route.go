@@ -0,0 +1,54 @@
+package healthcheck
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/broker"
+	coreUtils "github.com/hoyci/ms-chat/core/utils"
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/hoyci/ms-chat/ws-service/types"
+)
+
+type HealthCheckHandler struct {
+	cfg    config.Config
+	broker types.BrokerMonitor
+}
+
+func NewHealthCheckHandler(cfg config.Config, broker types.BrokerMonitor) *HealthCheckHandler {
+	return &HealthCheckHandler{
+		cfg:    cfg,
+		broker: broker,
+	}
+}
+
+func (h *HealthCheckHandler) RegisterRoutes() {
+	http.HandleFunc("GET /healthcheck", h.HandleHealthCheck)
+}
+
+// HandleHealthCheck reports the node as unavailable while it is not connected to
+// RabbitMQ: the messages its devices send then wait in the outbox, and no broadcast
+// reaches them.
+func (h *HealthCheckHandler) HandleHealthCheck(w http.ResponseWriter, r *http.Request) {
+	brokerStatus := h.broker.Status()
+
+	status, code := "available", http.StatusOK
+	if brokerStatus.State != broker.StateConnected {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	systemInfo := map[string]string{
+		"environment":    h.cfg.Environment,
+		"node_id":        h.cfg.NodeID,
+		"rabbitmq":       string(brokerStatus.State),
+		"rabbitmq_since": brokerStatus.Since.UTC().Format(time.RFC3339),
+	}
+	if brokerStatus.LastError != "" {
+		systemInfo["rabbitmq_last_error"] = brokerStatus.LastError
+	}
+
+	coreUtils.WriteJSON(w, code, &types.HealthcheckResponse{
+		Status:     status,
+		SystemInfo: systemInfo,
+	})
+}
@@ -0,0 +1,78 @@
+package healthcheck_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/broker"
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/hoyci/ms-chat/ws-service/service/healthcheck"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMonitor struct {
+	status broker.Status
+}
+
+func (m fakeMonitor) Status() broker.Status {
+	return m.status
+}
+
+func TestHealthCheck(t *testing.T) {
+	cfg := config.Config{Environment: "production", NodeID: "node-1"}
+	since := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("it should report the node as available while connected to RabbitMQ", func(t *testing.T) {
+		handler := healthcheck.NewHealthCheckHandler(cfg, fakeMonitor{broker.Status{State: broker.StateConnected, Since: since}})
+
+		w := httptest.NewRecorder()
+		handler.HandleHealthCheck(w, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{
+			"status": "available",
+			"system_info": {
+				"environment": "production",
+				"node_id": "node-1",
+				"rabbitmq": "connected",
+				"rabbitmq_since": "2024-05-01T12:00:00Z"
+			}
+		}`, string(body))
+	})
+
+	t.Run("it should report the node as unavailable while reconnecting to RabbitMQ", func(t *testing.T) {
+		handler := healthcheck.NewHealthCheckHandler(cfg, fakeMonitor{broker.Status{
+			State:     broker.StateConnecting,
+			Since:     since,
+			LastError: "connection refused",
+		}})
+
+		w := httptest.NewRecorder()
+		handler.HandleHealthCheck(w, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{
+			"status": "unavailable",
+			"system_info": {
+				"environment": "production",
+				"node_id": "node-1",
+				"rabbitmq": "connecting",
+				"rabbitmq_since": "2024-05-01T12:00:00Z",
+				"rabbitmq_last_error": "connection refused"
+			}
+		}`, string(body))
+	})
+}
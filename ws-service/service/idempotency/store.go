@@ -0,0 +1,66 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hoyci/ms-chat/ws-service/service/registry"
+	"github.com/redis/go-redis/v9"
+)
+
+// Clients retry a frame with the envelope ID they first sent it with. The message each
+// message.send frame was turned into is kept in Redis under that ID, so a retry is
+// answered with the message already sent instead of creating another one.
+
+// getClient shares the connection of the registry.
+var getClient = registry.GetClient
+
+func key(userID, requestID string) string {
+	return "idempotency:" + userID + ":" + requestID
+}
+
+// Reservation is the message a frame was turned into.
+type Reservation struct {
+	MessageID string    `json:"message_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Reserve records that the frame requestID of userID became reservation, for ttl. When
+// the frame was already reserved, the earlier reservation is returned along with false.
+func Reserve(ctx context.Context, userID, requestID string, reservation Reservation, ttl time.Duration) (Reservation, bool, error) {
+	value, err := json.Marshal(reservation)
+	if err != nil {
+		return Reservation{}, false, err
+	}
+
+	reserved, err := getClient().SetNX(ctx, key(userID, requestID), value, ttl).Result()
+	if err != nil {
+		return Reservation{}, false, err
+	}
+	if reserved {
+		return reservation, true, nil
+	}
+
+	existing, err := getClient().Get(ctx, key(userID, requestID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// The earlier reservation expired or was released in between.
+		return Reserve(ctx, userID, requestID, reservation, ttl)
+	}
+	if err != nil {
+		return Reservation{}, false, err
+	}
+
+	var earlier Reservation
+	if err := json.Unmarshal(existing, &earlier); err != nil {
+		return Reservation{}, false, err
+	}
+	return earlier, false, nil
+}
+
+// Release forgets the reservation of a frame that could not be handled, so retrying it
+// sends a message again.
+func Release(ctx context.Context, userID, requestID string) error {
+	return getClient().Del(ctx, key(userID, requestID)).Err()
+}
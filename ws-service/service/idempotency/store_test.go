@@ -0,0 +1,69 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTestStore(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	getClient = func() *redis.Client { return client }
+	return server
+}
+
+func TestReserve(t *testing.T) {
+	ctx := context.Background()
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	first := Reservation{MessageID: "665f1c2b9d3e4a5b6c7d8e9f", CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	second := Reservation{MessageID: "665f1c2b9d3e4a5b6c7d8ea0", CreatedAt: first.CreatedAt.Add(time.Second)}
+
+	t.Run("it should answer retries with the first reservation", func(t *testing.T) {
+		setupTestStore(t)
+
+		reservation, reserved, err := Reserve(ctx, userID, "frame-1", first, time.Hour)
+		assert.NoError(t, err)
+		assert.True(t, reserved)
+		assert.Equal(t, first, reservation)
+
+		reservation, reserved, err = Reserve(ctx, userID, "frame-1", second, time.Hour)
+		assert.NoError(t, err)
+		assert.False(t, reserved)
+		assert.Equal(t, first.MessageID, reservation.MessageID)
+		assert.True(t, first.CreatedAt.Equal(reservation.CreatedAt))
+	})
+
+	t.Run("it should keep the frames of each user apart", func(t *testing.T) {
+		setupTestStore(t)
+
+		_, _, err := Reserve(ctx, userID, "frame-1", first, time.Hour)
+		assert.NoError(t, err)
+
+		_, reserved, err := Reserve(ctx, "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b", "frame-1", second, time.Hour)
+		assert.NoError(t, err)
+		assert.True(t, reserved)
+	})
+
+	t.Run("it should reserve again once released or expired", func(t *testing.T) {
+		server := setupTestStore(t)
+
+		_, _, err := Reserve(ctx, userID, "frame-1", first, time.Hour)
+		assert.NoError(t, err)
+		assert.NoError(t, Release(ctx, userID, "frame-1"))
+
+		_, reserved, err := Reserve(ctx, userID, "frame-1", second, time.Hour)
+		assert.NoError(t, err)
+		assert.True(t, reserved)
+
+		server.FastForward(2 * time.Hour)
+		_, reserved, err = Reserve(ctx, userID, "frame-1", first, time.Hour)
+		assert.NoError(t, err)
+		assert.True(t, reserved)
+	})
+}
@@ -0,0 +1,295 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ErrFull is returned when the outbox holds as many entries as it may.
+var ErrFull = errors.New("outbox is full")
+
+// Publisher takes publishings in charge once the broker confirmed them, like
+// messaging.Bus. Entries are routed by their headers, so no routing key is given.
+type Publisher interface {
+	Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+}
+
+// Origin identifies the client frame an entry comes from, so its sender can be told
+// when the entry is abandoned.
+type Origin struct {
+	UserID    string `json:"user_id"`
+	ClientID  string `json:"client_id"`
+	RequestID string `json:"request_id,omitempty"`
+	FrameType string `json:"frame_type"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// Entry is a publishing waiting in the outbox.
+type Entry struct {
+	ID        string          `json:"id"`
+	Exchange  string          `json:"exchange"`
+	Headers   amqp.Table      `json:"headers"`
+	Type      string          `json:"type"`
+	Body      json.RawMessage `json:"body"`
+	Origin    Origin          `json:"origin"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func (e Entry) publishing() amqp.Publishing {
+	return amqp.Publishing{
+		Headers:      e.Headers,
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    e.ID,
+		Type:         e.Type,
+		Body:         e.Body,
+	}
+}
+
+// Outbox keeps the publishings the broker could not take in files of a directory, one
+// per entry, and relays them in order once the broker is back. Entries survive restarts
+// of the node. Publishings made while entries are waiting join the queue so the order
+// events are published in is kept.
+type Outbox struct {
+	dir        string
+	publisher  Publisher
+	timeout    time.Duration
+	maxEntries int
+	maxAge     time.Duration
+	onRelayed  func(Entry)
+	onAbandon  func(Entry, error)
+
+	// mu guards pending and is never held while publishing. flushing keeps a single
+	// Flush relaying at a time, so the head of pending only changes under the flush
+	// relaying it.
+	mu       sync.Mutex
+	pending  []string
+	flushing sync.Mutex
+	wake     chan struct{}
+}
+
+// New opens the outbox kept in dir, picking up the entries left by a previous run.
+// onRelayed is called for every entry published once it waited in the outbox, and
+// onAbandon for every entry given up on, either because no queue can receive it or
+// because it waited longer than maxAge.
+func New(dir string, publisher Publisher, timeout time.Duration, maxEntries int, maxAge time.Duration, onRelayed func(Entry), onAbandon func(Entry, error)) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for _, file := range files {
+		if name := file.Name(); strings.HasSuffix(name, ".json") {
+			pending = append(pending, strings.TrimSuffix(name, ".json"))
+		}
+	}
+	slices.Sort(pending)
+
+	return &Outbox{
+		dir:        dir,
+		publisher:  publisher,
+		timeout:    timeout,
+		maxEntries: maxEntries,
+		maxAge:     maxAge,
+		onRelayed:  onRelayed,
+		onAbandon:  onAbandon,
+		pending:    pending,
+		wake:       make(chan struct{}, 1),
+	}, nil
+}
+
+// Publish hands an entry to the broker, or keeps it in the outbox when the broker is
+// unavailable or entries are already waiting. It reports whether the entry was kept.
+// Entries no queue can receive are refused with messaging.ErrUnroutable.
+func (o *Outbox) Publish(ctx context.Context, entry Entry) (bool, error) {
+	// IDs sort in creation order, which is the order entries are relayed in.
+	entry.ID = fmt.Sprintf("%020d-%s", time.Now().UnixNano(), bson.NewObjectID().Hex())
+	entry.CreatedAt = time.Now()
+
+	if o.Len() == 0 {
+		ctx, cancel := context.WithTimeout(ctx, o.timeout)
+		err := o.publisher.Publish(ctx, entry.Exchange, "", entry.publishing())
+		cancel()
+
+		if err == nil || errors.Is(err, messaging.ErrUnroutable) {
+			return false, err
+		}
+		log.Printf("Broker unavailable, keeping %s event in the outbox: %v", entry.Type, err)
+	}
+
+	if o.Len() >= o.maxEntries {
+		return false, ErrFull
+	}
+
+	if err := o.write(entry); err != nil {
+		return false, err
+	}
+
+	o.mu.Lock()
+	o.pending = append(o.pending, entry.ID)
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+// Len returns the number of entries waiting.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
+
+// Run relays the waiting entries every interval, and as soon as one is added, until ctx
+// is done.
+func (o *Outbox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+
+		o.Flush(ctx)
+	}
+}
+
+// Flush relays the waiting entries in order, stopping at the first one the broker
+// cannot take yet.
+func (o *Outbox) Flush(ctx context.Context) {
+	o.flushing.Lock()
+	defer o.flushing.Unlock()
+
+	for {
+		id, ok := o.head()
+		if !ok {
+			return
+		}
+
+		entry, err := o.read(id)
+		if err != nil {
+			log.Printf("Dropping unreadable outbox entry %s: %v", id, err)
+			o.remove(id)
+			continue
+		}
+
+		if time.Since(entry.CreatedAt) > o.maxAge {
+			o.abandon(entry, fmt.Errorf("waited more than %s for the broker", o.maxAge))
+			continue
+		}
+
+		publishCtx, cancel := context.WithTimeout(ctx, o.timeout)
+		err = o.publisher.Publish(publishCtx, entry.Exchange, "", entry.publishing())
+		cancel()
+
+		if errors.Is(err, messaging.ErrUnroutable) {
+			o.abandon(entry, err)
+			continue
+		}
+		if err != nil {
+			log.Printf("Broker still unavailable, %d events waiting in the outbox: %v", o.Len(), err)
+			return
+		}
+
+		o.remove(entry.ID)
+		if o.onRelayed != nil {
+			o.onRelayed(entry)
+		}
+	}
+}
+
+// head returns the ID of the oldest entry waiting.
+func (o *Outbox) head() (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.pending) == 0 {
+		return "", false
+	}
+	return o.pending[0], true
+}
+
+func (o *Outbox) abandon(entry Entry, err error) {
+	log.Printf("Abandoning %s event %s of %s: %v", entry.Type, entry.ID, entry.Origin.UserID, err)
+	o.remove(entry.ID)
+	if o.onAbandon != nil {
+		o.onAbandon(entry, err)
+	}
+}
+
+// write stores an entry durably: the file is synced before it is moved in place.
+func (o *Outbox) write(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(o.dir, ".entry-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), o.path(entry.ID))
+}
+
+func (o *Outbox) read(id string) (Entry, error) {
+	var entry Entry
+	data, err := os.ReadFile(o.path(id))
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(data, &entry)
+	return entry, err
+}
+
+func (o *Outbox) remove(id string) {
+	if err := os.Remove(o.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Failed to remove outbox entry %s: %v", id, err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pending = slices.DeleteFunc(o.pending, func(pending string) bool { return pending == id })
+}
+
+func (o *Outbox) path(id string) string {
+	return filepath.Join(o.dir, id+".json")
+}
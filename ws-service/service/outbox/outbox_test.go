@@ -0,0 +1,248 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePublisher struct {
+	mu        sync.Mutex
+	err       error
+	published []amqp.Publishing
+	// gate, when set, holds every publishing until it is closed, like a broker slow
+	// to confirm.
+	gate chan struct{}
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	if p.gate != nil {
+		<-p.gate
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.published = append(p.published, msg)
+	return nil
+}
+
+func (p *fakePublisher) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+func (p *fakePublisher) bodies() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var bodies []string
+	for _, msg := range p.published {
+		bodies = append(bodies, string(msg.Body))
+	}
+	return bodies
+}
+
+func entry(body string) Entry {
+	return Entry{
+		Exchange: "chat_events",
+		Headers:  amqp.Table{"persistence": "true", "schema_version": int32(1)},
+		Type:     "chat_message",
+		Body:     []byte(body),
+		Origin:   Origin{UserID: "user", ClientID: "client", RequestID: "frame-" + body, FrameType: "message.send"},
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	unavailable := errors.New("connection refused")
+
+	t.Run("it should publish right away when the broker is available", func(t *testing.T) {
+		publisher := &fakePublisher{}
+		outbox, err := New(t.TempDir(), publisher, time.Second, 10, time.Hour, nil, nil)
+		assert.NoError(t, err)
+
+		queued, err := outbox.Publish(ctx, entry(`"1"`))
+		assert.NoError(t, err)
+		assert.False(t, queued)
+		assert.Equal(t, []string{`"1"`}, publisher.bodies())
+		assert.Equal(t, "true", publisher.published[0].Headers["persistence"])
+		assert.Equal(t, "chat_message", publisher.published[0].Type)
+		assert.Equal(t, amqp.Persistent, publisher.published[0].DeliveryMode)
+		assert.Equal(t, 0, outbox.Len())
+	})
+
+	t.Run("it should refuse unroutable entries without keeping them", func(t *testing.T) {
+		publisher := &fakePublisher{err: messaging.ErrUnroutable}
+		outbox, err := New(t.TempDir(), publisher, time.Second, 10, time.Hour, nil, nil)
+		assert.NoError(t, err)
+
+		queued, err := outbox.Publish(ctx, entry(`"1"`))
+		assert.ErrorIs(t, err, messaging.ErrUnroutable)
+		assert.False(t, queued)
+		assert.Equal(t, 0, outbox.Len())
+	})
+
+	t.Run("it should keep entries while the broker is unavailable and relay them in order", func(t *testing.T) {
+		publisher := &fakePublisher{err: unavailable}
+		outbox, err := New(t.TempDir(), publisher, time.Second, 10, time.Hour, nil, nil)
+		assert.NoError(t, err)
+
+		queued, err := outbox.Publish(ctx, entry(`"1"`))
+		assert.NoError(t, err)
+		assert.True(t, queued)
+
+		// Once entries wait, new ones join them even if the broker is back.
+		publisher.fail(nil)
+		queued, err = outbox.Publish(ctx, entry(`"2"`))
+		assert.NoError(t, err)
+		assert.True(t, queued)
+		assert.Empty(t, publisher.bodies())
+
+		outbox.Flush(ctx)
+		assert.Equal(t, []string{`"1"`, `"2"`}, publisher.bodies())
+		assert.Equal(t, 0, outbox.Len())
+	})
+
+	t.Run("it should keep taking entries while a relay waits for the broker", func(t *testing.T) {
+		publisher := &fakePublisher{err: unavailable}
+		outbox, err := New(t.TempDir(), publisher, time.Second, 10, time.Hour, nil, nil)
+		assert.NoError(t, err)
+
+		outbox.Publish(ctx, entry(`"1"`))
+		publisher.fail(nil)
+		publisher.gate = make(chan struct{})
+
+		flushed := make(chan struct{})
+		go func() {
+			outbox.Flush(ctx)
+			close(flushed)
+		}()
+
+		published := make(chan bool)
+		go func() {
+			queued, _ := outbox.Publish(ctx, entry(`"2"`))
+			published <- queued
+		}()
+
+		select {
+		case queued := <-published:
+			assert.True(t, queued)
+		case <-time.After(time.Second):
+			t.Fatal("publishing waited for the relay in progress")
+		}
+
+		close(publisher.gate)
+		<-flushed
+		assert.Equal(t, []string{`"1"`, `"2"`}, publisher.bodies())
+		assert.Equal(t, 0, outbox.Len())
+	})
+
+	t.Run("it should report the entries relayed once they waited", func(t *testing.T) {
+		publisher := &fakePublisher{err: unavailable}
+		var relayed []string
+		onRelayed := func(entry Entry) { relayed = append(relayed, string(entry.Body)) }
+		outbox, err := New(t.TempDir(), publisher, time.Second, 10, time.Hour, onRelayed, nil)
+		assert.NoError(t, err)
+
+		outbox.Publish(ctx, entry(`"1"`))
+		assert.Empty(t, relayed)
+
+		publisher.fail(nil)
+		outbox.Flush(ctx)
+		outbox.Publish(ctx, entry(`"2"`))
+
+		assert.Equal(t, []string{`"1"`}, relayed)
+	})
+
+	t.Run("it should stop relaying at the first entry the broker cannot take", func(t *testing.T) {
+		publisher := &fakePublisher{err: unavailable}
+		outbox, err := New(t.TempDir(), publisher, time.Second, 10, time.Hour, nil, nil)
+		assert.NoError(t, err)
+
+		outbox.Publish(ctx, entry(`"1"`))
+		outbox.Publish(ctx, entry(`"2"`))
+		outbox.Flush(ctx)
+
+		assert.Empty(t, publisher.bodies())
+		assert.Equal(t, 2, outbox.Len())
+	})
+
+	t.Run("it should pick up the entries of a previous run", func(t *testing.T) {
+		dir := t.TempDir()
+		publisher := &fakePublisher{err: unavailable}
+		outbox, err := New(dir, publisher, time.Second, 10, time.Hour, nil, nil)
+		assert.NoError(t, err)
+
+		outbox.Publish(ctx, entry(`"1"`))
+		outbox.Publish(ctx, entry(`"2"`))
+
+		publisher = &fakePublisher{}
+		reopened, err := New(dir, publisher, time.Second, 10, time.Hour, nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, reopened.Len())
+
+		reopened.Flush(ctx)
+		assert.Equal(t, []string{`"1"`, `"2"`}, publisher.bodies())
+		assert.Equal(t, "true", publisher.published[0].Headers["persistence"])
+		assert.Equal(t, 1, messaging.SchemaVersion(publisher.published[0].Headers))
+		assert.Equal(t, 0, reopened.Len())
+	})
+
+	t.Run("it should refuse entries once full", func(t *testing.T) {
+		publisher := &fakePublisher{err: unavailable}
+		outbox, err := New(t.TempDir(), publisher, time.Second, 1, time.Hour, nil, nil)
+		assert.NoError(t, err)
+
+		_, err = outbox.Publish(ctx, entry(`"1"`))
+		assert.NoError(t, err)
+
+		queued, err := outbox.Publish(ctx, entry(`"2"`))
+		assert.ErrorIs(t, err, ErrFull)
+		assert.False(t, queued)
+		assert.Equal(t, 1, outbox.Len())
+	})
+
+	t.Run("it should abandon entries that became unroutable or waited too long", func(t *testing.T) {
+		var abandoned []Entry
+		var causes []error
+		onAbandon := func(entry Entry, err error) {
+			abandoned = append(abandoned, entry)
+			causes = append(causes, err)
+		}
+
+		publisher := &fakePublisher{err: unavailable}
+		outbox, err := New(t.TempDir(), publisher, time.Second, 10, time.Hour, nil, onAbandon)
+		assert.NoError(t, err)
+
+		outbox.Publish(ctx, entry(`"1"`))
+		publisher.fail(messaging.ErrUnroutable)
+		outbox.Flush(ctx)
+
+		assert.Len(t, abandoned, 1)
+		assert.Equal(t, "frame-\"1\"", abandoned[0].Origin.RequestID)
+		assert.ErrorIs(t, causes[0], messaging.ErrUnroutable)
+		assert.Equal(t, 0, outbox.Len())
+
+		expiring, err := New(t.TempDir(), publisher, time.Second, 10, 0, nil, onAbandon)
+		assert.NoError(t, err)
+
+		publisher.fail(unavailable)
+		expiring.Publish(ctx, entry(`"2"`))
+		publisher.fail(nil)
+		expiring.Flush(ctx)
+
+		assert.Len(t, abandoned, 2)
+		assert.Equal(t, "message.send", abandoned[1].Origin.FrameType)
+		assert.Empty(t, publisher.bodies())
+		assert.Equal(t, 0, expiring.Len())
+	})
+}
@@ -0,0 +1,55 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hoyci/ms-chat/ws-service/config"
+)
+
+var httpClient = &http.Client{Timeout: 5 * time.Second}
+
+type contactsResponse struct {
+	Contact []struct {
+		ContactID string `json:"contact_id"`
+		Status    string `json:"status"`
+	} `json:"contact"`
+}
+
+// FetchContacts lists the contacts of the owner of token from contacts-service. Only
+// accepted contacts are listed: presence is not shared over a pending or rejected
+// request.
+func FetchContacts(ctx context.Context, token string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.Envs.ContactsServiceURL+"/api/v1/contacts", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("contacts-service answered %s", resp.Status)
+	}
+
+	var body contactsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	contactIDs := make([]string, 0, len(body.Contact))
+	for _, contact := range body.Contact {
+		if contact.Status != "accepted" {
+			continue
+		}
+		contactIDs = append(contactIDs, contact.ContactID)
+	}
+	return contactIDs, nil
+}
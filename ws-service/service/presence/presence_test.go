@@ -0,0 +1,174 @@
+package presence
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTestStore(t *testing.T) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	getClient = func() *redis.Client { return client }
+}
+
+func TestGet(t *testing.T) {
+	ctx := context.Background()
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	otherUserID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+
+	t.Run("it should return the recorded status and last seen time", func(t *testing.T) {
+		setupTestStore(t)
+		seenAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		assert.NoError(t, SetStatus(ctx, userID, coreTypes.PresenceAway, seenAt))
+
+		presences, err := Get(ctx, []string{userID})
+
+		assert.NoError(t, err)
+		assert.Len(t, presences, 1)
+		assert.Equal(t, coreTypes.PresenceAway, presences[0].Status)
+		assert.True(t, seenAt.Equal(*presences[0].LastSeenAt))
+	})
+
+	t.Run("it should report users never seen as offline", func(t *testing.T) {
+		setupTestStore(t)
+
+		presences, err := Get(ctx, []string{otherUserID})
+
+		assert.NoError(t, err)
+		assert.Equal(t, coreTypes.PresenceOffline, presences[0].Status)
+		assert.Nil(t, presences[0].LastSeenAt)
+	})
+}
+
+func TestDeviceStatuses(t *testing.T) {
+	ctx := context.Background()
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+
+	t.Run("it should return the status of each device, online for devices never reported", func(t *testing.T) {
+		setupTestStore(t)
+		assert.NoError(t, SetDeviceStatus(ctx, userID, "client-1", coreTypes.PresenceAway))
+
+		statuses, err := DeviceStatuses(ctx, userID, []string{"client-1", "client-2"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []coreTypes.PresenceStatus{coreTypes.PresenceAway, coreTypes.PresenceOnline}, statuses)
+	})
+
+	t.Run("it should forget the status of removed devices", func(t *testing.T) {
+		setupTestStore(t)
+		assert.NoError(t, SetDeviceStatus(ctx, userID, "client-1", coreTypes.PresenceAway))
+		assert.NoError(t, RemoveDeviceStatus(ctx, userID, "client-1"))
+
+		statuses, err := DeviceStatuses(ctx, userID, []string{"client-1"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []coreTypes.PresenceStatus{coreTypes.PresenceOnline}, statuses)
+	})
+}
+
+func TestAggregate(t *testing.T) {
+	online, away, offline := coreTypes.PresenceOnline, coreTypes.PresenceAway, coreTypes.PresenceOffline
+
+	tests := []struct {
+		name     string
+		statuses []coreTypes.PresenceStatus
+		want     coreTypes.PresenceStatus
+	}{
+		{"it should be offline without any device", nil, offline},
+		{"it should be online when the only device is", []coreTypes.PresenceStatus{online}, online},
+		{"it should stay online while one device is in use", []coreTypes.PresenceStatus{away, online}, online},
+		{"it should be away when every device is", []coreTypes.PresenceStatus{away, away}, away},
+		{"it should ignore devices reporting offline", []coreTypes.PresenceStatus{offline, away}, away},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Aggregate(tt.statuses))
+		})
+	}
+}
+
+func TestVisibleTo(t *testing.T) {
+	ctx := context.Background()
+	viewerID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	contactID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+	strangerID := "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"
+
+	t.Run("it should only keep the viewer and users having the viewer as a contact", func(t *testing.T) {
+		setupTestStore(t)
+		assert.NoError(t, SetContacts(ctx, contactID, []string{viewerID}))
+		assert.NoError(t, SetContacts(ctx, strangerID, []string{contactID}))
+
+		visible, err := VisibleTo(ctx, viewerID, []string{viewerID, contactID, strangerID})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{viewerID, contactID}, visible)
+	})
+
+	t.Run("it should replace the previous contacts", func(t *testing.T) {
+		setupTestStore(t)
+		assert.NoError(t, SetContacts(ctx, contactID, []string{viewerID}))
+		assert.NoError(t, SetContacts(ctx, contactID, nil))
+
+		visible, err := VisibleTo(ctx, viewerID, []string{contactID})
+
+		assert.NoError(t, err)
+		assert.Empty(t, visible)
+	})
+}
+
+func TestFetchContacts(t *testing.T) {
+	t.Run("it should forward the token and only list accepted contacts", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/api/v1/contacts", r.URL.Path)
+			assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+			w.Write([]byte(`{"contact":[
+				{"contact_id":"accepted-user","status":"accepted"},
+				{"contact_id":"pending-user","status":"pending"},
+				{"contact_id":"rejected-user","status":"rejected"}
+			]}`))
+		}))
+		defer server.Close()
+		config.Envs.ContactsServiceURL = server.URL
+
+		contactIDs, err := FetchContacts(context.Background(), "token")
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"accepted-user"}, contactIDs)
+	})
+
+	t.Run("it should not list the user a contact request is pending with", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"contact":[{"contact_id":"pending-user","status":"pending"}]}`))
+		}))
+		defer server.Close()
+		config.Envs.ContactsServiceURL = server.URL
+
+		contactIDs, err := FetchContacts(context.Background(), "token")
+
+		assert.NoError(t, err)
+		assert.Empty(t, contactIDs)
+	})
+
+	t.Run("it should fail when contacts-service answers with an error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer server.Close()
+		config.Envs.ContactsServiceURL = server.URL
+
+		_, err := FetchContacts(context.Background(), "token")
+
+		assert.Error(t, err)
+	})
+}
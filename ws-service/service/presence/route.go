@@ -0,0 +1,75 @@
+package presence
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	coreMiddlewares "github.com/hoyci/ms-chat/core/middlewares"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	coreUtils "github.com/hoyci/ms-chat/core/utils"
+	"github.com/hoyci/ms-chat/ws-service/keys"
+	"github.com/hoyci/ms-chat/ws-service/types"
+)
+
+const maxPresenceUsers = 100
+
+func RegisterRoutes() {
+	http.Handle("GET /presence", coreMiddlewares.AuthMiddleware(http.HandlerFunc(HandleGetPresence), keys.PublicKeyAccess))
+}
+
+// HandleGetPresence
+// @Summary Get the presence of users
+// @Description Users that do not have the caller as a contact are left out of the response.
+// @Tags Presence
+// @Produce json
+// @Security BearerAuth
+// @Param user_ids query string true "Comma-separated user IDs (max 100)"
+// @Success 200 {object} types.GetPresenceResponse "Presence of the visible users"
+// @Failure 400 {object} coreTypes.BadRequestResponse "Missing or too many user IDs"
+// @Failure 500 {object} coreTypes.InternalServerErrorResponse "An unexpected error occurred"
+// @Router /presence [get]
+func HandleGetPresence(w http.ResponseWriter, r *http.Request) {
+	viewerID, ok := coreUtils.GetClaimFromContext[string](r, "UserID")
+	if !ok {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve userID from context"),
+			"HandleGetPresence", coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	var userIDs []string
+	for _, userID := range strings.Split(r.URL.Query().Get("user_ids"), ",") {
+		if userID = strings.TrimSpace(userID); userID != "" {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	if len(userIDs) == 0 || len(userIDs) > maxPresenceUsers {
+		coreUtils.WriteError(
+			w, http.StatusBadRequest, fmt.Errorf("user sent %d user IDs", len(userIDs)), "HandleGetPresence",
+			coreTypes.BadRequestResponse{Error: fmt.Sprintf("user_ids must list between 1 and %d user IDs", maxPresenceUsers)},
+		)
+		return
+	}
+
+	visible, err := VisibleTo(r.Context(), viewerID, userIDs)
+	if err != nil {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, err, "HandleGetPresence",
+			coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	presences, err := Get(r.Context(), visible)
+	if err != nil {
+		coreUtils.WriteError(
+			w, http.StatusInternalServerError, err, "HandleGetPresence",
+			coreTypes.InternalServerErrorResponse{Error: "An unexpected error occurred"},
+		)
+		return
+	}
+
+	_ = coreUtils.WriteJSON(w, http.StatusOK, types.GetPresenceResponse{Presence: presences})
+}
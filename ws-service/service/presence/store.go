@@ -0,0 +1,156 @@
+package presence
+
+import (
+	"context"
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/ws-service/service/registry"
+	"github.com/hoyci/ms-chat/ws-service/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// The presence of a user is kept in Redis with the contacts allowed to see it. Contacts
+// are fetched from contacts-service every time the user connects.
+
+// getClient shares the connection of the registry.
+var getClient = registry.GetClient
+
+func statusKey(userID string) string {
+	return "presence:user:" + userID
+}
+
+func contactsKey(userID string) string {
+	return "presence:contacts:" + userID
+}
+
+func devicesKey(userID string) string {
+	return "presence:devices:" + userID
+}
+
+// SetStatus records the status of the user, seen at the given time.
+func SetStatus(ctx context.Context, userID string, status coreTypes.PresenceStatus, at time.Time) error {
+	return getClient().HSet(ctx, statusKey(userID),
+		"status", string(status),
+		"last_seen_at", at.Format(time.RFC3339Nano),
+	).Err()
+}
+
+// SetDeviceStatus records the status a device of the user reported.
+func SetDeviceStatus(ctx context.Context, userID, clientID string, status coreTypes.PresenceStatus) error {
+	return getClient().HSet(ctx, devicesKey(userID), clientID, string(status)).Err()
+}
+
+// RemoveDeviceStatus forgets the status of a device that disconnected.
+func RemoveDeviceStatus(ctx context.Context, userID, clientID string) error {
+	return getClient().HDel(ctx, devicesKey(userID), clientID).Err()
+}
+
+// DeviceStatuses returns the status of each of the given devices of the user. Devices
+// that never reported one are online.
+func DeviceStatuses(ctx context.Context, userID string, clientIDs []string) ([]coreTypes.PresenceStatus, error) {
+	if len(clientIDs) == 0 {
+		return nil, nil
+	}
+
+	values, err := getClient().HMGet(ctx, devicesKey(userID), clientIDs...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]coreTypes.PresenceStatus, len(values))
+	for i, value := range values {
+		statuses[i] = coreTypes.PresenceOnline
+		if status, ok := value.(string); ok {
+			statuses[i] = coreTypes.PresenceStatus(status)
+		}
+	}
+	return statuses, nil
+}
+
+// Aggregate returns the status of a user from the statuses of their connected devices:
+// online when one of them is, away when none is online, offline without any device.
+func Aggregate(statuses []coreTypes.PresenceStatus) coreTypes.PresenceStatus {
+	if len(statuses) == 0 {
+		return coreTypes.PresenceOffline
+	}
+
+	aggregate := coreTypes.PresenceOffline
+	for _, status := range statuses {
+		switch status {
+		case coreTypes.PresenceOnline:
+			return coreTypes.PresenceOnline
+		case coreTypes.PresenceAway:
+			aggregate = coreTypes.PresenceAway
+		}
+	}
+	return aggregate
+}
+
+// Get returns the presence of each user. Users never seen are offline.
+func Get(ctx context.Context, userIDs []string) ([]types.Presence, error) {
+	pipe := getClient().Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		cmds[i] = pipe.HGetAll(ctx, statusKey(userID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	presences := make([]types.Presence, len(userIDs))
+	for i, cmd := range cmds {
+		presences[i] = types.Presence{UserID: userIDs[i], Status: coreTypes.PresenceOffline}
+
+		fields := cmd.Val()
+		if status, ok := fields["status"]; ok {
+			presences[i].Status = coreTypes.PresenceStatus(status)
+		}
+		if lastSeenAt, err := time.Parse(time.RFC3339Nano, fields["last_seen_at"]); err == nil {
+			presences[i].LastSeenAt = &lastSeenAt
+		}
+	}
+
+	return presences, nil
+}
+
+// SetContacts replaces the users allowed to see the presence of userID.
+func SetContacts(ctx context.Context, userID string, contactIDs []string) error {
+	pipe := getClient().TxPipeline()
+	pipe.Del(ctx, contactsKey(userID))
+	if len(contactIDs) > 0 {
+		members := make([]any, len(contactIDs))
+		for i, contactID := range contactIDs {
+			members[i] = contactID
+		}
+		pipe.SAdd(ctx, contactsKey(userID), members...)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Contacts returns the users allowed to see the presence of userID.
+func Contacts(ctx context.Context, userID string) ([]string, error) {
+	return getClient().SMembers(ctx, contactsKey(userID)).Result()
+}
+
+// VisibleTo filters userIDs down to the users whose presence viewerID may see: the
+// viewer and the users having the viewer as a contact.
+func VisibleTo(ctx context.Context, viewerID string, userIDs []string) ([]string, error) {
+	pipe := getClient().Pipeline()
+	cmds := make([]*redis.BoolCmd, len(userIDs))
+	for i, userID := range userIDs {
+		cmds[i] = pipe.SIsMember(ctx, contactsKey(userID), viewerID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	visible := make([]string, 0, len(userIDs))
+	for i, cmd := range cmds {
+		if userIDs[i] == viewerID || cmd.Val() {
+			visible = append(visible, userIDs[i])
+		}
+	}
+	return visible, nil
+}
@@ -1,73 +1,52 @@
 package rabbitmq
 
 import (
+	"context"
 	"log"
 
+	"github.com/hoyci/ms-chat/core/broker"
+	"github.com/hoyci/ms-chat/core/messaging"
 	"github.com/hoyci/ms-chat/ws-service/config"
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-var conn *amqp.Connection
-var channel *amqp.Channel
+var manager *broker.Manager
 
+// Init connects to RabbitMQ, waiting for the broker to be up, and keeps the connection
+// open from then on: the topology is declared again and the consumers resumed after
+// every reconnection.
 func Init() {
-	var err error
-	conn, err = amqp.Dial(config.Envs.RabbitMQURL)
-	if err != nil {
-		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
-	}
+	manager = broker.NewManager(config.Envs.RabbitMQURL, broker.Backoff{
+		Initial: config.Envs.RabbitMQReconnectDelay,
+		Max:     config.Envs.RabbitMQReconnectMaxDelay,
+	})
+	manager.Declare(messaging.Topology(Queues()))
 
-	channel, err = conn.Channel()
-	if err != nil {
-		log.Fatalf("Failed to open channel: %v", err)
+	if err := manager.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
 	}
-
-	createExchange("chat_events", "headers")
-	createExchange("user_events", "fanout")
-
-	persistenceQueue := createQueue(config.Envs.PersistenceQueueName)
-
-	channel.QueueBind(
-		persistenceQueue.Name,
-		"",
-		"chat_events",
-		false,
-		amqp.Table{"x-match": "any", "persistence": "true"},
-	)
 }
 
-func createExchange(name string, kind string) {
-	err := channel.ExchangeDeclare(
-		name,
-		kind,
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		log.Fatalf("Failed to declare exchange %s: %v", name, err)
+// Queues names the queues ws-service consumes and publishes to. The ephemeral queue is
+// declared by message-service too, with the same TTL.
+func Queues() messaging.Queues {
+	return messaging.Queues{
+		Persistence:  config.Envs.PersistenceQueueName,
+		Broadcast:    config.Envs.BroadcastQueueName,
+		Ephemeral:    config.Envs.EphemeralQueueName,
+		EphemeralTTL: config.Envs.TypingTimeout,
+		UserEvents:   config.Envs.UserEventsQueueName,
+		NodeID:       config.Envs.NodeID,
 	}
 }
 
-func createQueue(name string) amqp.Queue {
-	q, err := channel.QueueDeclare(
-		name,
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-
-	if err != nil {
-		log.Printf("Failed to declare queue %s", name)
-	}
-
-	return q
+// NewBus returns the bus of the connection opened by Init.
+func NewBus() messaging.Bus {
+	return messaging.NewAMQPBus(manager)
 }
 
-func GetChannel() *amqp.Channel {
-	return channel
+// Monitor reports the state of the connection to RabbitMQ to health checks.
+type Monitor struct{}
+
+func (Monitor) Status() broker.Status {
+	return manager.Status()
 }
@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// Every connected device is recorded in Redis under its user, with the node holding its
+// socket, so any node can route events to the nodes a user is connected to. Each node
+// keeps an alive key refreshed by Heartbeat: devices of nodes that stopped refreshing it
+// are skipped and cleaned up as they are found.
+
+const (
+	heartbeatInterval = 10 * time.Second
+	nodeTTL           = 30 * time.Second
+)
+
+var client *redis.Client
+
+func Init() {
+	c := redis.NewClient(&redis.Options{
+		Addr:     config.Envs.RedisAddr,
+		Password: config.Envs.RedisPassword,
+		DB:       config.Envs.RedisDB,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.Ping(ctx).Err(); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	if err := Start(ctx, c); err != nil {
+		log.Fatalf("Failed to register node %s: %v", config.Envs.NodeID, err)
+	}
+}
+
+// Start keeps the registry in c and records this node as alive in it.
+func Start(ctx context.Context, c *redis.Client) error {
+	client = c
+	return client.Set(ctx, nodeKey(config.Envs.NodeID), time.Now().Unix(), nodeTTL).Err()
+}
+
+func GetClient() *redis.Client {
+	return client
+}
+
+func userKey(userID string) string {
+	return "ws:user:" + userID
+}
+
+func nodeKey(nodeID string) string {
+	return "ws:node:" + nodeID
+}
+
+// Heartbeat keeps this node alive in the registry until ctx is done, then removes it.
+func Heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			client.Del(context.Background(), nodeKey(config.Envs.NodeID))
+			return
+		case <-ticker.C:
+			if err := client.Set(ctx, nodeKey(config.Envs.NodeID), time.Now().Unix(), nodeTTL).Err(); err != nil {
+				log.Printf("Failed to refresh node %s: %v", config.Envs.NodeID, err)
+			}
+		}
+	}
+}
+
+// AddDevice records that a device of the user is connected to this node.
+func AddDevice(ctx context.Context, userID string, clientID string) error {
+	return client.HSet(ctx, userKey(userID), clientID, config.Envs.NodeID).Err()
+}
+
+func RemoveDevice(ctx context.Context, userID string, clientID string) error {
+	return client.HDel(ctx, userKey(userID), clientID).Err()
+}
+
+// Devices returns the devices of the user connected to live nodes.
+func Devices(ctx context.Context, userID string) ([]string, error) {
+	nodes, err := NodesOf(ctx, []string{userID})
+	if err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+
+	// NodesOf dropped the devices of dead nodes.
+	devices, err := client.HKeys(ctx, userKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(devices)
+	return devices, nil
+}
+
+// NodesOf groups the users by the live nodes their devices are connected to. Users
+// without any connected device are left out.
+func NodesOf(ctx context.Context, userIDs []string) (map[string][]string, error) {
+	pipe := client.Pipeline()
+	devices := make([]*redis.MapStringStringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		devices[i] = pipe.HGetAll(ctx, userKey(userID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	alive := make(map[string]bool)
+	for _, cmd := range devices {
+		for _, nodeID := range cmd.Val() {
+			alive[nodeID] = false
+		}
+	}
+	if len(alive) == 0 {
+		return map[string][]string{}, nil
+	}
+
+	pipe = client.Pipeline()
+	exists := make(map[string]*redis.IntCmd, len(alive))
+	for nodeID := range alive {
+		exists[nodeID] = pipe.Exists(ctx, nodeKey(nodeID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for nodeID, cmd := range exists {
+		alive[nodeID] = cmd.Val() == 1
+	}
+
+	nodes := make(map[string][]string)
+	for i, cmd := range devices {
+		userID := userIDs[i]
+		routed := make(map[string]bool)
+		for clientID, nodeID := range cmd.Val() {
+			if !alive[nodeID] {
+				client.HDel(ctx, userKey(userID), clientID)
+				continue
+			}
+			if routed[nodeID] {
+				continue
+			}
+			routed[nodeID] = true
+			nodes[nodeID] = append(nodes[nodeID], userID)
+		}
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTestRegistry(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	config.Envs.NodeID = "node-a"
+	server.Set(nodeKey("node-a"), "1")
+	return server
+}
+
+func TestNodesOf(t *testing.T) {
+	ctx := context.Background()
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	otherUserID := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+
+	t.Run("it should group users by the nodes holding their devices", func(t *testing.T) {
+		server := setupTestRegistry(t)
+		server.Set(nodeKey("node-b"), "1")
+		assert.NoError(t, AddDevice(ctx, userID, "client-1"))
+		server.HSet(userKey(userID), "client-2", "node-b")
+		server.HSet(userKey(otherUserID), "client-3", "node-b")
+
+		nodes, err := NodesOf(ctx, []string{userID, otherUserID})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{userID}, nodes["node-a"])
+		assert.ElementsMatch(t, []string{userID, otherUserID}, nodes["node-b"])
+	})
+
+	t.Run("it should skip and clean up devices of dead nodes", func(t *testing.T) {
+		server := setupTestRegistry(t)
+		server.HSet(userKey(userID), "client-1", "node-gone")
+
+		nodes, err := NodesOf(ctx, []string{userID})
+
+		assert.NoError(t, err)
+		assert.Empty(t, nodes)
+		assert.False(t, server.Exists(userKey(userID)))
+	})
+
+	t.Run("it should forget devices once they are removed", func(t *testing.T) {
+		setupTestRegistry(t)
+		assert.NoError(t, AddDevice(ctx, userID, "client-1"))
+		assert.NoError(t, RemoveDevice(ctx, userID, "client-1"))
+
+		nodes, err := NodesOf(ctx, []string{userID})
+
+		assert.NoError(t, err)
+		assert.Empty(t, nodes)
+	})
+}
+
+func TestDevices(t *testing.T) {
+	ctx := context.Background()
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+
+	t.Run("it should list the devices of the user on live nodes", func(t *testing.T) {
+		server := setupTestRegistry(t)
+		server.Set(nodeKey("node-b"), "1")
+		assert.NoError(t, AddDevice(ctx, userID, "client-2"))
+		server.HSet(userKey(userID), "client-1", "node-b")
+		server.HSet(userKey(userID), "client-3", "node-gone")
+
+		devices, err := Devices(ctx, userID)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"client-1", "client-2"}, devices)
+	})
+
+	t.Run("it should return no device for a user without any", func(t *testing.T) {
+		setupTestRegistry(t)
+
+		devices, err := Devices(ctx, userID)
+
+		assert.NoError(t, err)
+		assert.Empty(t, devices)
+	})
+}
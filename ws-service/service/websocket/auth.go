@@ -0,0 +1,103 @@
+package websocket
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	coreUtils "github.com/hoyci/ms-chat/core/utils"
+)
+
+const (
+	CloseUnauthorized = 4401
+	CloseTokenExpired = 4001
+
+	tokenSubprotocol = "access_token"
+	closeWriteWait   = time.Second
+)
+
+var errMissingToken = errors.New("missing access token")
+
+// extractToken looks for the access token in the Authorization header, then in the
+// Sec-WebSocket-Protocol header (sent as "access_token, <token>" by browsers, which
+// cannot set custom headers) and finally in the "token" query param.
+func extractToken(r *http.Request) (string, error) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return "", fmt.Errorf("invalid authorization header format")
+		}
+		return parts[1], nil
+	}
+
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == tokenSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1], nil
+		}
+	}
+
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token, nil
+	}
+
+	return "", errMissingToken
+}
+
+func authenticate(r *http.Request, publicKey *rsa.PublicKey) (*coreTypes.CustomClaims, error) {
+	token, err := extractToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyToken(token, publicKey)
+}
+
+func verifyToken(token string, publicKey *rsa.PublicKey) (*coreTypes.CustomClaims, error) {
+	claims, err := coreUtils.VerifyJWT(token, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.UserID == "" {
+		return nil, fmt.Errorf("token has no user_id claim")
+	}
+
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("token has no exp claim")
+	}
+
+	return claims, nil
+}
+
+func closeCodeFor(err error) (int, string) {
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return CloseTokenExpired, "Token expired"
+	}
+	return CloseUnauthorized, "Invalid or missing token"
+}
+
+func closeWithCode(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(closeWriteWait),
+	)
+	conn.Close()
+}
+
+// watchExpiry closes the connection with CloseTokenExpired once the session token
+// expires. Resetting the returned timer extends the session after a re-auth.
+func watchExpiry(conn *websocket.Conn, clientID string, claims *coreTypes.CustomClaims) *time.Timer {
+	return time.AfterFunc(time.Until(claims.ExpiresAt.Time), func() {
+		log.Printf("Token of connection %s expired", clientID)
+		closeWithCode(conn, CloseTokenExpired, "Token expired")
+	})
+}
@@ -0,0 +1,271 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	coreUtils "github.com/hoyci/ms-chat/core/utils"
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/hoyci/ms-chat/ws-service/keys"
+	"github.com/hoyci/ms-chat/ws-service/types"
+	"github.com/hoyci/ms-chat/ws-service/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func generateTestToken(t *testing.T, privateKey *rsa.PrivateKey, userID string, expiresAt time.Time) string {
+	t.Helper()
+	token, err := coreUtils.CreateJWTTestTokenFromClaims(coreTypes.CustomClaims{
+		UserID:   userID,
+		Username: "johndoe",
+		Email:    "johndoe@email.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to generate test token: %v", err)
+	}
+	return token
+}
+
+func TestAuthenticate(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	validToken := generateTestToken(t, privateKey, userID, time.Now().Add(time.Hour))
+
+	t.Run("it should read the token from the Authorization header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.Header.Set("Authorization", "Bearer "+validToken)
+
+		claims, err := authenticate(req, &privateKey.PublicKey)
+
+		assert.NoError(t, err)
+		assert.Equal(t, userID, claims.UserID)
+	})
+
+	t.Run("it should read the token from the Sec-WebSocket-Protocol header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.Header.Set("Sec-WebSocket-Protocol", "access_token, "+validToken)
+
+		claims, err := authenticate(req, &privateKey.PublicKey)
+
+		assert.NoError(t, err)
+		assert.Equal(t, userID, claims.UserID)
+	})
+
+	t.Run("it should read the token from the query string", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws?token="+validToken, nil)
+
+		claims, err := authenticate(req, &privateKey.PublicKey)
+
+		assert.NoError(t, err)
+		assert.Equal(t, userID, claims.UserID)
+	})
+
+	t.Run("it should ignore the X-User-ID header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.Header.Set("X-User-ID", userID)
+
+		_, err := authenticate(req, &privateKey.PublicKey)
+
+		assert.True(t, errors.Is(err, errMissingToken))
+	})
+
+	t.Run("it should reject a malformed Authorization header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.Header.Set("Authorization", "Token "+validToken)
+
+		_, err := authenticate(req, &privateKey.PublicKey)
+
+		assert.Error(t, err)
+	})
+
+	t.Run("it should reject a token signed by another key", func(t *testing.T) {
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("Failed to generate RSA key: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/ws?token="+validToken, nil)
+
+		_, err = authenticate(req, &otherKey.PublicKey)
+
+		code, _ := closeCodeFor(err)
+		assert.Error(t, err)
+		assert.Equal(t, CloseUnauthorized, code)
+	})
+
+	t.Run("it should map an expired token to CloseTokenExpired", func(t *testing.T) {
+		expiredToken := generateTestToken(t, privateKey, userID, time.Now().Add(-time.Minute))
+		req := httptest.NewRequest(http.MethodGet, "/ws?token="+expiredToken, nil)
+
+		_, err := authenticate(req, &privateKey.PublicKey)
+
+		code, _ := closeCodeFor(err)
+		assert.Error(t, err)
+		assert.Equal(t, CloseTokenExpired, code)
+	})
+}
+
+// dialTestConnection opens a websocket connection to a test server and returns both of
+// its ends: the one the node holds and the one of the client.
+func dialTestConnection(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+
+	accepted := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Failed to upgrade: %v", err)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	select {
+	case conn := <-accepted:
+		t.Cleanup(func() { conn.Close() })
+		return conn, client
+	case <-time.After(2 * time.Second):
+		t.Fatal("The connection was not accepted")
+		return nil, nil
+	}
+}
+
+// closeCodeOf waits for the client to be closed and returns the close code it was sent,
+// or 0 if it is still open after wait.
+func closeCodeOf(t *testing.T, client *websocket.Conn, wait time.Duration) int {
+	t.Helper()
+
+	client.SetReadDeadline(time.Now().Add(wait))
+	for {
+		_, _, err := client.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return closeErr.Code
+		}
+		return 0
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	utils.InitValidator()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	publicKey := keys.PublicKeyAccess
+	keys.PublicKeyAccess = &privateKey.PublicKey
+	t.Cleanup(func() { keys.PublicKeyAccess = publicKey })
+
+	userID := "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+
+	// startSession watches a session whose token expires after lifetime.
+	startSession := func(t *testing.T, lifetime time.Duration) (types.Connection, *websocket.Conn, *time.Timer) {
+		conn, client := dialTestConnection(t)
+		connection := types.Connection{
+			Channel:     conn,
+			ClientID:    "client-1",
+			UserID:      userID,
+			Version:     types.ProtocolV1,
+			Outbox:      types.NewOutbox(16, types.OverflowDropOldest),
+			Credentials: types.NewCredentials(""),
+		}
+		// jwt.NewNumericDate truncates to the second, which would expire the session at once.
+		claims := &coreTypes.CustomClaims{
+			UserID:           userID,
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: &jwt.NumericDate{Time: time.Now().Add(lifetime)}},
+		}
+
+		expiry := watchExpiry(conn, connection.ClientID, claims)
+		t.Cleanup(func() { expiry.Stop() })
+		return connection, client, expiry
+	}
+
+	authFrame := func(token string) types.Envelope {
+		payload, _ := json.Marshal(types.AuthPayload{Token: token})
+		return types.Envelope{Type: types.EventAuth, ID: "auth-1", Payload: payload}
+	}
+
+	t.Run("it should close the connection once the token expires", func(t *testing.T) {
+		_, client, _ := startSession(t, 100*time.Millisecond)
+
+		assert.Equal(t, CloseTokenExpired, closeCodeOf(t, client, 2*time.Second))
+	})
+
+	t.Run("it should keep the connection open after a re-auth with a fresh token", func(t *testing.T) {
+		connection, client, expiry := startSession(t, 100*time.Millisecond)
+
+		reauthenticate(connection, authFrame(generateTestToken(t, privateKey, userID, time.Now().Add(time.Hour))), expiry)
+
+		ack := nextFrame(t, connection)
+		assert.Equal(t, types.EventAck, ack.Type)
+		assert.Equal(t, "auth-1", ack.ID)
+		assert.Zero(t, closeCodeOf(t, client, 500*time.Millisecond), "the session outlived its first token")
+	})
+
+	t.Run("it should answer an invalid re-auth token without extending the session", func(t *testing.T) {
+		connection, client, expiry := startSession(t, 300*time.Millisecond)
+
+		reauthenticate(connection, authFrame(generateTestToken(t, privateKey, userID, time.Now().Add(-time.Minute))), expiry)
+
+		answer := nextFrame(t, connection)
+		assert.Equal(t, types.EventError, answer.Type)
+		assert.Contains(t, string(answer.Payload), "invalid_token")
+		assert.Equal(t, CloseTokenExpired, closeCodeOf(t, client, 2*time.Second))
+	})
+
+	t.Run("it should close the connection when the re-auth token belongs to another user", func(t *testing.T) {
+		connection, client, expiry := startSession(t, time.Hour)
+
+		otherUser := "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+		reauthenticate(connection, authFrame(generateTestToken(t, privateKey, otherUser, time.Now().Add(time.Hour))), expiry)
+
+		assert.Equal(t, CloseUnauthorized, closeCodeOf(t, client, 2*time.Second))
+	})
+
+	t.Run("it should fetch the contacts with the token of the last re-auth", func(t *testing.T) {
+		setupTestFlow(t)
+		var authorization string
+		contacts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			w.Write([]byte(`{"contact":[]}`))
+		}))
+		t.Cleanup(contacts.Close)
+		contactsURL := config.Envs.ContactsServiceURL
+		config.Envs.ContactsServiceURL = contacts.URL
+		t.Cleanup(func() { config.Envs.ContactsServiceURL = contactsURL })
+
+		connection, _, expiry := startSession(t, time.Hour)
+		connection.Credentials.SetToken(generateTestToken(t, privateKey, userID, time.Now().Add(time.Minute)))
+		fresh := generateTestToken(t, privateKey, userID, time.Now().Add(time.Hour))
+		reauthenticate(connection, authFrame(fresh), expiry)
+		nextFrame(t, connection)
+
+		announceOnline(connection)
+
+		assert.Equal(t, "Bearer "+fresh, authorization)
+	})
+}
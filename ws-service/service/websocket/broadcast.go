@@ -1,42 +1,158 @@
 package websocket
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"log"
+	"time"
 
-	"github.com/hoyci/ms-chat/ws-service/service/rabbitmq"
+	"github.com/hoyci/ms-chat/core/messaging"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/hoyci/ms-chat/ws-service/service/registry"
 	"github.com/hoyci/ms-chat/ws-service/types"
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func StartBroadcastConsumer() {
-	ch := rabbitmq.GetChannel()
+// requeueDelay spaces out the attempts at a broadcast that could not be handled, which
+// mostly happens while Redis or the broker is unavailable.
+var requeueDelay = time.Second
 
-	msgs, _ := ch.Consume(
-		"broadcast_queue",
-		"",
-		false,
-		false,
-		true,
-		false,
-		nil,
-	)
+// StartBroadcastConsumer routes the broadcasts published by other services to the nodes
+// holding the devices of their users. Every node consumes the shared broadcast queue, so
+// each broadcast is routed once whatever node picks it up.
+func StartBroadcastConsumer(ctx context.Context) {
+	consume(ctx, config.Envs.BroadcastQueueName, func(broadcast coreTypes.BroadcastMessage) error {
+		return route(ctx, broadcast)
+	})
+}
+
+// StartNodeConsumer delivers the broadcasts routed to this node to its local devices.
+func StartNodeConsumer(ctx context.Context) {
+	consume(ctx, messaging.NodeQueueName(config.Envs.NodeID), func(broadcast coreTypes.BroadcastMessage) error {
+		deliverBroadcast(broadcast)
+		return nil
+	})
+}
+
+// consume hands the broadcasts of a queue to handle until ctx is done. The broadcasts
+// handle fails on are requeued, so they are not lost while a dependency is down.
+func consume(ctx context.Context, queueName string, handle func(coreTypes.BroadcastMessage) error) {
+	msgs, err := bus.Consume(ctx, queueName, 0)
+	if err != nil {
+		log.Printf("Failed to start consumption from %s: %v", queueName, err)
+		return
+	}
 
 	for msg := range msgs {
-		var broadcast types.BroadcastMessage
-		if err := json.Unmarshal(msg.Body, &broadcast); err != nil {
+		broadcast, err := messaging.Broadcast.Decode(msg)
+		if err != nil {
+			log.Printf("Dropping broadcast: %v", err)
+			msg.Ack(false)
 			continue
 		}
 
-		receiverDevices := GetUserDevicesConnections(broadcast.UserID)
+		if err := handle(broadcast); err != nil {
+			log.Printf("Failed to handle broadcast from %s, requeueing it: %v", queueName, err)
+			requeue(ctx, msg)
+			continue
+		}
 
-		connectionsCopy := make([]types.Connection, len(receiverDevices))
-		copy(connectionsCopy, receiverDevices)
+		msg.Ack(false)
+	}
+}
 
-		if connections := GetUserDevicesConnections(broadcast.UserID); len(connections) > 0 {
-			for _, conn := range connections {
-				conn.Channel.WriteJSON(broadcast.Messages)
-			}
+// requeue hands a delivery back to its queue once requeueDelay elapsed.
+func requeue(ctx context.Context, msg amqp.Delivery) {
+	select {
+	case <-time.After(requeueDelay):
+	case <-ctx.Done():
+	}
+	msg.Nack(false, true)
+}
+
+// route splits a broadcast by node and publishes each part to the node holding the
+// devices of its users.
+func route(ctx context.Context, broadcast coreTypes.BroadcastMessage) error {
+	nodes, err := registry.NodesOf(ctx, broadcast.UserIDs)
+	if err != nil {
+		return err
+	}
+
+	for nodeID, userIDs := range nodes {
+		part := broadcast
+		part.UserIDs = userIDs
+
+		// The queue of a node goes away with it, and so do the devices it held.
+		err := messaging.Broadcast.ToNode(nodeID).Publish(ctx, bus, part)
+		if errors.Is(err, messaging.ErrUnroutable) {
+			log.Printf("Skipping node %s which went away", nodeID)
+			continue
+		}
+		if err != nil {
+			return err
 		}
+	}
 
-		msg.Ack(false)
+	return nil
+}
+
+func deliverBroadcast(broadcast coreTypes.BroadcastMessage) {
+	for _, userID := range broadcast.UserIDs {
+		for _, conn := range GetUserDevicesConnections(userID) {
+			if conn.ClientID == broadcast.ExcludeClientID {
+				continue
+			}
+			if broadcast.ClientID != "" && conn.ClientID != broadcast.ClientID {
+				continue
+			}
+
+			for _, message := range broadcast.Messages {
+				message.ClientID = ""
+				if err := writeEvent(conn, types.EventMessageNew, "", message); err != nil {
+					log.Printf("An error occurred while sending the message to %s: %v", conn.ClientID, err)
+				}
+			}
+
+			for _, message := range broadcast.Updated {
+				if err := writeEvent(conn, types.EventMessageUpdated, "", message); err != nil {
+					log.Printf("An error occurred while sending the edit to %s: %v", conn.ClientID, err)
+				}
+			}
+
+			for _, deletion := range broadcast.Deleted {
+				if err := writeEvent(conn, types.EventMessageDeleted, "", deletion); err != nil {
+					log.Printf("An error occurred while sending the deletion to %s: %v", conn.ClientID, err)
+				}
+			}
+
+			for _, reaction := range broadcast.Reactions {
+				if err := writeEvent(conn, types.EventReaction, "", reaction); err != nil {
+					log.Printf("An error occurred while sending the reaction to %s: %v", conn.ClientID, err)
+				}
+			}
+
+			for _, receipt := range broadcast.Receipts {
+				if err := writeEvent(conn, types.EventReceipt, "", receipt); err != nil {
+					log.Printf("An error occurred while sending the receipt to %s: %v", conn.ClientID, err)
+				}
+			}
+
+			for _, event := range broadcast.Presence {
+				if err := writeEvent(conn, types.EventPresence, "", event); err != nil {
+					log.Printf("An error occurred while sending the presence to %s: %v", conn.ClientID, err)
+				}
+			}
+
+			for _, event := range broadcast.Typing {
+				if err := writeEvent(conn, types.EventTyping, "", event); err != nil {
+					log.Printf("An error occurred while sending the typing indicator to %s: %v", conn.ClientID, err)
+				}
+			}
+
+			for _, rejection := range broadcast.Rejections {
+				writeError(conn, rejection.RequestID, rejection.Code, rejection.Message)
+			}
+		}
 	}
 }
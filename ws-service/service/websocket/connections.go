@@ -23,7 +23,7 @@ func RemoveConnection(clientID string) {
 	delete(connections, clientID)
 }
 
-func GetUserDevicesConnections(userID int) []types.Connection {
+func GetUserDevicesConnections(userID string) []types.Connection {
 	mu.RLock()
 	defer mu.RUnlock()
 
@@ -31,11 +31,7 @@ func GetUserDevicesConnections(userID int) []types.Connection {
 
 	for _, conn := range connections {
 		if ok := conn.UserID == userID; ok {
-			result = append(result, types.Connection{
-				ClientID: conn.ClientID,
-				UserID:   conn.UserID,
-				Channel:  conn.Channel,
-			})
+			result = append(result, conn)
 		}
 	}
 
@@ -0,0 +1,296 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hoyci/ms-chat/core/messaging"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/hoyci/ms-chat/ws-service/service/rabbitmq"
+	"github.com/hoyci/ms-chat/ws-service/service/registry"
+	"github.com/hoyci/ms-chat/ws-service/types"
+	"github.com/hoyci/ms-chat/ws-service/utils"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	senderID   = "0c4b5c9e-1c6a-4d0c-9f3e-7a8f9c2a1b3d"
+	receiverID = "5f1d7e2a-8b3c-4e6f-9a0b-1c2d3e4f5a6b"
+)
+
+// testBus is an in-memory bus whose publishings fail while it is down, like a broker
+// that went away.
+type testBus struct {
+	*messaging.MemoryBus
+	down atomic.Bool
+}
+
+func (b *testBus) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	if b.down.Load() {
+		return errors.New("connection closed")
+	}
+	return b.MemoryBus.Publish(ctx, exchange, key, msg)
+}
+
+// setupTestFlow runs the node on an in-memory bus and a Redis of its own, with its
+// broadcast consumers started.
+func setupTestFlow(t *testing.T) (*testBus, *miniredis.Miniredis) {
+	t.Helper()
+	utils.InitValidator()
+
+	config.Envs.NodeID = "node-a"
+	config.Envs.PublishOutboxPath = t.TempDir()
+
+	server := miniredis.RunT(t)
+	if err := registry.Start(context.Background(), redis.NewClient(&redis.Options{Addr: server.Addr()})); err != nil {
+		t.Fatalf("failed to start the registry: %v", err)
+	}
+
+	bus := &testBus{MemoryBus: messaging.NewMemoryBus(rabbitmq.Queues())}
+	Init(bus)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go StartBroadcastConsumer(ctx)
+	go StartNodeConsumer(ctx)
+
+	return bus, server
+}
+
+// connectDevice registers a device of the user as connected to the node.
+func connectDevice(t *testing.T, userID, clientID string) types.Connection {
+	t.Helper()
+
+	connection := types.Connection{
+		ClientID:    clientID,
+		UserID:      userID,
+		Version:     types.ProtocolV1,
+		Outbox:      types.NewOutbox(16, types.OverflowDropOldest),
+		Typing:      newTypingTracker(userID),
+		Credentials: types.NewCredentials(""),
+	}
+	AddUserDeviceConnection(clientID, connection)
+	if err := registry.AddDevice(context.Background(), userID, clientID); err != nil {
+		t.Fatalf("failed to register %s: %v", clientID, err)
+	}
+	t.Cleanup(func() { RemoveConnection(clientID) })
+
+	return connection
+}
+
+// nextFrame waits for the next frame queued for a device.
+func nextFrame(t *testing.T, connection types.Connection) types.Envelope {
+	t.Helper()
+
+	select {
+	case frame := <-connection.Outbox.Frames():
+		var envelope types.Envelope
+		if err := json.Unmarshal(frame, &envelope); err != nil {
+			t.Fatalf("invalid frame sent to %s: %v", connection.ClientID, err)
+		}
+		return envelope
+	case <-time.After(2 * time.Second):
+		t.Fatalf("nothing was sent to %s", connection.ClientID)
+		return types.Envelope{}
+	}
+}
+
+// nextEvent waits for the next event published to a queue and acknowledges it.
+func nextEvent[T any](t *testing.T, bus messaging.Bus, queueName string, event messaging.Event[T]) T {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deliveries, err := bus.Consume(ctx, queueName, 1)
+	if err != nil {
+		t.Fatalf("failed to consume %s: %v", queueName, err)
+	}
+
+	select {
+	case delivery := <-deliveries:
+		delivery.Ack(false)
+		payload, err := event.Decode(delivery)
+		if err != nil {
+			t.Fatalf("invalid %s delivery: %v", delivery.Type, err)
+		}
+		return payload
+	case <-time.After(2 * time.Second):
+		t.Fatalf("nothing was published to %s", queueName)
+		var zero T
+		return zero
+	}
+}
+
+func sendFrame(connection types.Connection, id string, payload types.SendMessagePayload) {
+	body, _ := json.Marshal(payload)
+	sendMessage(connection, types.Envelope{Type: types.EventMessageSend, ID: id, Payload: body})
+}
+
+func TestMessageFlow(t *testing.T) {
+	roomID := "6650f1a2b3c4d5e6f7a8b9c0"
+
+	t.Run("it should hand a room message to message-service and deliver its broadcast", func(t *testing.T) {
+		bus, _ := setupTestFlow(t)
+		sender := connectDevice(t, senderID, "sender-device")
+		receiver := connectDevice(t, receiverID, "receiver-device")
+
+		sendFrame(sender, "frame-1", types.SendMessagePayload{RoomID: roomID, Content: "hello"})
+
+		ack := nextFrame(t, sender)
+		assert.Equal(t, types.EventAck, ack.Type)
+		assert.Equal(t, "frame-1", ack.ID)
+		var ackPayload types.AckPayload
+		assert.NoError(t, json.Unmarshal(ack.Payload, &ackPayload))
+		assert.False(t, ackPayload.Queued)
+
+		persisted := nextEvent(t, bus, config.Envs.PersistenceQueueName, messaging.ChatMessage)
+		assert.Equal(t, ackPayload.MessageID, persisted.ID)
+		assert.Equal(t, roomID, persisted.RoomID)
+		assert.Equal(t, senderID, persisted.SenderID)
+		assert.Equal(t, "frame-1", persisted.IdempotencyKey)
+
+		// message-service fans the persisted message out to the room members.
+		err := messaging.Broadcast.Publish(context.Background(), bus, coreTypes.BroadcastMessage{
+			UserIDs:         []string{senderID, receiverID},
+			Messages:        []coreTypes.Message{persisted},
+			ExcludeClientID: persisted.ClientID,
+			Timestamp:       time.Now(),
+		})
+		assert.NoError(t, err)
+
+		delivered := nextFrame(t, receiver)
+		assert.Equal(t, types.EventMessageNew, delivered.Type)
+		var message coreTypes.Message
+		assert.NoError(t, json.Unmarshal(delivered.Payload, &message))
+		assert.Equal(t, persisted.ID, message.ID)
+		assert.Equal(t, "hello", message.Content)
+		assert.Empty(t, message.ClientID)
+		assert.Zero(t, sender.Outbox.Len())
+	})
+
+	t.Run("it should deliver a direct message to the receiver and the other devices of the sender", func(t *testing.T) {
+		bus, _ := setupTestFlow(t)
+		sender := connectDevice(t, senderID, "sender-device")
+		otherDevice := connectDevice(t, senderID, "sender-other-device")
+		receiver := connectDevice(t, receiverID, "receiver-device")
+
+		sendFrame(sender, "frame-2", types.SendMessagePayload{ReceiverID: receiverID, Content: "hi"})
+
+		ack := nextFrame(t, sender)
+		assert.Equal(t, types.EventAck, ack.Type)
+
+		persisted := nextEvent(t, bus, config.Envs.PersistenceQueueName, messaging.ChatMessage)
+		assert.Equal(t, receiverID, persisted.ReceiverID)
+
+		for _, device := range []types.Connection{receiver, otherDevice} {
+			delivered := nextFrame(t, device)
+			assert.Equal(t, types.EventMessageNew, delivered.Type)
+			var message coreTypes.Message
+			assert.NoError(t, json.Unmarshal(delivered.Payload, &message))
+			assert.Equal(t, persisted.ID, message.ID)
+		}
+	})
+
+	t.Run("it should acknowledge a retried frame without sending the message again", func(t *testing.T) {
+		bus, _ := setupTestFlow(t)
+		sender := connectDevice(t, senderID, "sender-device")
+
+		sendFrame(sender, "frame-3", types.SendMessagePayload{RoomID: roomID, Content: "hello"})
+		sendFrame(sender, "frame-3", types.SendMessagePayload{RoomID: roomID, Content: "hello"})
+
+		var first, second types.AckPayload
+		assert.NoError(t, json.Unmarshal(nextFrame(t, sender).Payload, &first))
+		assert.NoError(t, json.Unmarshal(nextFrame(t, sender).Payload, &second))
+		assert.Equal(t, first.MessageID, second.MessageID)
+		assert.Equal(t, 1, bus.Len(config.Envs.PersistenceQueueName))
+	})
+
+	t.Run("it should requeue a broadcast until the registry is available again", func(t *testing.T) {
+		requeueDelay = 10 * time.Millisecond
+		t.Cleanup(func() { requeueDelay = time.Second })
+
+		bus, server := setupTestFlow(t)
+		receiver := connectDevice(t, receiverID, "receiver-device")
+
+		server.SetError("LOADING Redis is loading the dataset in memory")
+		err := messaging.Broadcast.Publish(context.Background(), bus, coreTypes.BroadcastMessage{
+			UserIDs:   []string{receiverID},
+			Messages:  []coreTypes.Message{{ID: "6650f1a2b3c4d5e6f7a8b9c1", Content: "hello"}},
+			Timestamp: time.Now(),
+		})
+		assert.NoError(t, err)
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Zero(t, receiver.Outbox.Len())
+		assert.Equal(t, 1, bus.Len(config.Envs.BroadcastQueueName))
+
+		server.SetError("")
+		delivered := nextFrame(t, receiver)
+		assert.Equal(t, types.EventMessageNew, delivered.Type)
+		assert.Eventually(t, func() bool { return bus.Len(config.Envs.BroadcastQueueName) == 0 }, time.Second, 10*time.Millisecond)
+	})
+	t.Run("it should deliver a direct message kept in the outbox once it is relayed", func(t *testing.T) {
+		bus, _ := setupTestFlow(t)
+		sender := connectDevice(t, senderID, "sender-device")
+		receiver := connectDevice(t, receiverID, "receiver-device")
+
+		bus.down.Store(true)
+		sendFrame(sender, "frame-4", types.SendMessagePayload{ReceiverID: receiverID, Content: "hi"})
+
+		var ackPayload types.AckPayload
+		assert.NoError(t, json.Unmarshal(nextFrame(t, sender).Payload, &ackPayload))
+		assert.True(t, ackPayload.Queued)
+		assert.Zero(t, receiver.Outbox.Len())
+
+		bus.down.Store(false)
+		chatEvents.Flush(context.Background())
+
+		persisted := nextEvent(t, bus, config.Envs.PersistenceQueueName, messaging.ChatMessage)
+		assert.Equal(t, ackPayload.MessageID, persisted.ID)
+		delivered := nextFrame(t, receiver)
+		assert.Equal(t, types.EventMessageNew, delivered.Type)
+	})
+
+	t.Run("it should send a message again when its abandoned frame is retried", func(t *testing.T) {
+		maxAge := config.Envs.PublishOutboxMaxAge
+		config.Envs.PublishOutboxMaxAge = 20 * time.Millisecond
+		t.Cleanup(func() { config.Envs.PublishOutboxMaxAge = maxAge })
+
+		bus, _ := setupTestFlow(t)
+		sender := connectDevice(t, senderID, "sender-device")
+		receiver := connectDevice(t, receiverID, "receiver-device")
+
+		bus.down.Store(true)
+		sendFrame(sender, "frame-5", types.SendMessagePayload{ReceiverID: receiverID, Content: "hi"})
+		var first types.AckPayload
+		assert.NoError(t, json.Unmarshal(nextFrame(t, sender).Payload, &first))
+
+		// The broker stays away longer than the outbox waits.
+		time.Sleep(30 * time.Millisecond)
+		chatEvents.Flush(context.Background())
+
+		failed := nextFrame(t, sender)
+		assert.Equal(t, types.EventPersistenceFailed, failed.Type)
+		assert.Equal(t, "frame-5", failed.ID)
+		assert.Zero(t, receiver.Outbox.Len())
+
+		bus.down.Store(false)
+		sendFrame(sender, "frame-5", types.SendMessagePayload{ReceiverID: receiverID, Content: "hi"})
+
+		var second types.AckPayload
+		assert.NoError(t, json.Unmarshal(nextFrame(t, sender).Payload, &second))
+		assert.NotEqual(t, first.MessageID, second.MessageID)
+		persisted := nextEvent(t, bus, config.Envs.PersistenceQueueName, messaging.ChatMessage)
+		assert.Equal(t, second.MessageID, persisted.ID)
+		assert.Equal(t, types.EventMessageNew, nextFrame(t, receiver).Type)
+	})
+}
@@ -1,23 +1,28 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
-	"strconv"
 	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/hoyci/ms-chat/core/messaging"
 	coreTypes "github.com/hoyci/ms-chat/core/types"
-	"github.com/hoyci/ms-chat/ws-service/service/rabbitmq"
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/hoyci/ms-chat/ws-service/keys"
+	"github.com/hoyci/ms-chat/ws-service/service/idempotency"
+	"github.com/hoyci/ms-chat/ws-service/service/outbox"
+	"github.com/hoyci/ms-chat/ws-service/service/registry"
 	"github.com/hoyci/ms-chat/ws-service/types"
-	"github.com/hoyci/ms-chat/ws-service/utils"
-	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
+// The subprotocol is negotiated by negotiateProtocol and passed to Upgrade as a response
+// header, so the upgrader does not list any.
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -25,150 +30,380 @@ var upgrader = websocket.Upgrader{
 }
 
 func HandleWebsocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	claims, authErr := authenticate(r, keys.PublicKeyAccess)
+	version, subprotocol, protocolErr := negotiateProtocol(r)
+
+	var responseHeader http.Header
+	if subprotocol != "" {
+		responseHeader = http.Header{"Sec-WebSocket-Protocol": {subprotocol}}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Println("Error updating connection to websocket:", err)
 		return
 	}
 
-	clientID := uuid.New().String()
-
-	fmt.Println("Headers:")
-	for key, values := range r.Header {
-		for _, value := range values {
-			fmt.Printf("%s: %s\n", key, value)
-		}
+	if protocolErr != nil {
+		log.Printf("Rejecting websocket connection: %v", protocolErr)
+		closeWithCode(conn, CloseUnsupportedProtocol, "Unsupported protocol version")
+		return
 	}
 
-	userIDStr := r.Header.Get("X-User-ID")
-	if userIDStr == "" {
-		conn.WriteJSON(types.WsErrorMessageResponse{
-			ID:      clientID,
-			Message: []string{"Missing X-User-ID on headers"},
-			Status:  "missing_headers",
-		})
-		conn.Close()
+	if authErr != nil {
+		code, reason := closeCodeFor(authErr)
+		log.Printf("Rejecting websocket connection: %v", authErr)
+		closeWithCode(conn, code, reason)
 		return
 	}
 
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil {
-		conn.WriteJSON(types.WsErrorMessageResponse{
-			ID:      clientID,
-			Message: []string{"Invalid X-User-ID format"},
-			Status:  "invalid_headers",
-		})
-		conn.Close()
+	clientID := uuid.New().String()
+	// authenticate already succeeded, so the token is there.
+	token, _ := extractToken(r)
+
+	connection := types.Connection{
+		ClientID:    clientID,
+		UserID:      claims.UserID,
+		Username:    claims.Username,
+		Version:     version,
+		Channel:     conn,
+		Outbox:      types.NewOutbox(config.Envs.OutboxSize, overflowPolicy),
+		Typing:      newTypingTracker(claims.UserID),
+		Credentials: types.NewCredentials(token),
+	}
+
+	if err := registry.AddDevice(r.Context(), connection.UserID, clientID); err != nil {
+		log.Printf("Failed to register device %s: %v", clientID, err)
+		closeWithCode(conn, websocket.CloseInternalServerErr, "Service unavailable")
 		return
 	}
 
-	AddUserDeviceConnection(clientID, types.Connection{
-		ClientID: clientID,
-		UserID:   userID,
-		Channel:  conn,
-	})
+	AddUserDeviceConnection(clientID, connection)
+	go writePump(connection)
+
+	go announceOnline(connection)
 
-	conn.WriteJSON(types.WsConnectionResponse{
-		UserID:   userID,
+	writeEvent(connection, types.EventSession, "", types.SessionPayload{
+		UserID:   connection.UserID,
 		ClientID: clientID,
-		Message:  "Successfully connected",
-		Status:   "connected",
+		Version:  version,
 	})
 
-	go manageConnection(conn, clientID)
+	// Messages that arrived while the device was offline are replayed through the
+	// broadcast queue; last_seen_id lets a device resume right after the last message
+	// it stored.
+	syncRequest := coreTypes.SyncRequest{
+		UserID:     connection.UserID,
+		ClientID:   clientID,
+		LastSeenID: r.URL.Query().Get("last_seen_id"),
+		Timestamp:  time.Now(),
+	}
+	if _, err := publishChatEvent(messaging.Sync, syncRequest, outbox.Origin{UserID: connection.UserID, ClientID: clientID}); err != nil {
+		log.Printf("Failed to request the sync of %s: %v", clientID, err)
+	}
+
+	go manageConnection(connection, claims)
 }
 
-func manageConnection(conn *websocket.Conn, clientID string) {
+func manageConnection(connection types.Connection, claims *coreTypes.CustomClaims) {
+	conn := connection.Channel
+	clientID := connection.ClientID
+
+	expiry := watchExpiry(conn, clientID, claims)
+
 	defer func() {
-		conn.Close()
-		RemoveConnection(clientID)
-		log.Printf("Connection %s closed", clientID)
+		expiry.Stop()
+		disconnect(connection)
 	}()
 
-	ch := rabbitmq.GetChannel()
+	// The read deadline is pushed back by every pong and every frame; a client that
+	// stops answering pings is reaped once it expires.
+	conn.SetReadLimit(config.Envs.MaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(config.Envs.PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(config.Envs.PongWait))
+	})
 
 	for {
-		var msg coreTypes.Message
-		err := conn.ReadJSON(&msg)
+		_, data, err := conn.ReadMessage()
 		if err != nil {
-			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
-				log.Printf("Connection closed by client: %v", err)
-				return
+			switch {
+			case websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived):
+				log.Printf("Connection %s closed by client: %v", clientID, err)
+			case errors.Is(err, websocket.ErrReadLimit):
+				log.Printf("Connection %s sent a frame larger than %d bytes", clientID, config.Envs.MaxMessageSize)
+			default:
+				log.Printf("Connection %s lost: %v", clientID, err)
 			}
-
-			log.Printf("Read error: %v", err)
+			return
 		}
+		conn.SetReadDeadline(time.Now().Add(config.Envs.PongWait))
 
-		if err := utils.Validate.Struct(msg); err != nil {
-			var errorMessages []string
-			for _, e := range err.(validator.ValidationErrors) {
-				errorMessages = append(errorMessages, fmt.Sprintf("Field '%s' is invalid: %s", e.Field(), e.Tag()))
-			}
-
-			conn.WriteJSON(types.WsErrorMessageResponse{
-				ID:      msg.ID,
-				Message: errorMessages,
-				Status:  "validation_error",
-			})
-			log.Printf("Client invalid message %s: %v", clientID, errorMessages)
+		var envelope types.Envelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			writeError(connection, "", "invalid_json", "Frame is not a valid json envelope")
 			continue
 		}
 
-		msg.ID = uuid.New().String()
-		msg.ClientID = clientID
-		msg.CreatedAt = time.Now()
-		msg.Status = "delivered"
+		switch envelope.Type {
+		case types.EventMessageSend:
+			sendMessage(connection, envelope)
+		case types.EventMessageEdit:
+			editMessage(connection, envelope)
+		case types.EventMessageDelete:
+			deleteMessage(connection, envelope)
+		case types.EventReactionAdd:
+			react(connection, envelope, coreTypes.ReactionAdd)
+		case types.EventReactionRemove:
+			react(connection, envelope, coreTypes.ReactionRemove)
+		case types.EventReceipt:
+			sendReceipt(connection, envelope)
+		case types.EventAuth:
+			reauthenticate(connection, envelope, expiry)
+		case types.EventTyping:
+			sendTyping(connection, envelope)
+		case types.EventPresence:
+			setPresence(connection, envelope)
+		case types.EventPing:
+			writeEvent(connection, types.EventPong, envelope.ID, struct{}{})
+		default:
+			writeError(connection, envelope.ID, "unsupported_type", "Unsupported frame type: "+envelope.Type)
+		}
+	}
+}
 
-		receiverDevices := GetUserDevicesConnections(msg.ReceiverID)
+// sendMessage accepts a message.send frame: the message is handed to message-service,
+// pushed to the receiver of a direct message and to the other devices of the sender,
+// and acknowledged with its ID.
+func sendMessage(connection types.Connection, envelope types.Envelope) {
+	var payload types.SendMessagePayload
+	if !decodePayload(connection, envelope, &payload) {
+		log.Printf("Client %s sent an invalid message", connection.ClientID)
+		return
+	}
 
-		connectionsCopy := make([]types.Connection, len(receiverDevices))
-		copy(connectionsCopy, receiverDevices)
+	msg := coreTypes.Message{
+		ID:         bson.NewObjectID().Hex(),
+		RoomID:     payload.RoomID,
+		SenderID:   connection.UserID,
+		ReceiverID: payload.ReceiverID,
+		Content:    payload.Content,
+		ReplyTo:    payload.ReplyTo,
+		Status:     coreTypes.StatusSent,
+		CreatedAt:  time.Now(),
+		ClientID:   connection.ClientID,
+	}
+	for _, attachmentID := range payload.AttachmentIDs {
+		msg.Attachments = append(msg.Attachments, coreTypes.Attachment{ID: attachmentID})
+	}
 
-		if len(connectionsCopy) > 0 {
-			for _, conn := range connectionsCopy {
-				err := conn.Channel.WriteJSON(msg)
-				if err != nil {
-					log.Printf("An error occurred while sending the message to %s: %v", conn.ClientID, err)
-					continue
-				}
-			}
-		} else {
-			msg.Status = "pending"
-		}
+	// Messages addressed to a room are fanned out to its members by message-service
+	// once it has resolved the membership.
+	if msg.RoomID != "" {
+		msg.ReceiverID = ""
+	}
 
-		body, err := json.Marshal(msg)
+	// A retried frame is acknowledged with the message it was first turned into and
+	// not sent again. Should Redis be unavailable, message-service still refuses to
+	// store the message twice thanks to its idempotency key.
+	if envelope.ID != "" {
+		msg.IdempotencyKey = envelope.ID
+		reservation, reserved, err := idempotency.Reserve(
+			context.Background(), connection.UserID, envelope.ID,
+			idempotency.Reservation{MessageID: msg.ID, CreatedAt: msg.CreatedAt}, config.Envs.IdempotencyTTL,
+		)
 		if err != nil {
-			log.Printf("An unexpected error occurred while marshaling message: %v", err)
+			log.Printf("Failed to reserve frame %s of %s: %v", envelope.ID, connection.UserID, err)
+		} else if !reserved {
+			log.Printf("Frame %s of %s was already sent as message %s", envelope.ID, connection.UserID, reservation.MessageID)
+			ackMessage(connection, envelope.ID, reservation.MessageID, reservation.CreatedAt, false)
 			return
 		}
+	}
 
-		retries := 0
-		maxRetries := 3
-
-		for retries < maxRetries {
-			err = ch.Publish(
-				"chat_events",
-				"",
-				false,
-				false,
-				amqp.Publishing{
-					Headers:     amqp.Table{"persistence": "true"},
-					ContentType: "application/json",
-					Body:        body,
-				},
-			)
-			if err == nil {
-				log.Printf("Message %s published on exchange", msg.ID)
-				break
+	// The message is published before it reaches the receiver so message-service
+	// always persists it ahead of the receipts the receiver sends for it.
+	origin := originOf(connection, envelope)
+	origin.MessageID = msg.ID
+	queued, err := publishChatEvent(messaging.ChatMessage, msg, origin)
+	if err != nil {
+		log.Printf("Failed to publish message %s: %v", msg.ID, err)
+		if envelope.ID != "" {
+			if err := idempotency.Release(context.Background(), connection.UserID, envelope.ID); err != nil {
+				log.Printf("Failed to release frame %s of %s: %v", envelope.ID, connection.UserID, err)
 			}
-			log.Printf("Publish failed (attempt %d): %v", retries+1, err)
-			time.Sleep(1 * time.Second)
-			retries++
 		}
+		publishFailure(connection, envelope, err, "The message could not be sent, try again")
+		return
+	}
+	log.Printf("Message %s published on exchange (queued: %t)", msg.ID, queued)
 
-		if err := conn.WriteJSON(msg); err != nil {
-			log.Printf("An unexpected error occurred while sending message to user: %v", err)
-			continue
-		}
+	// A message waiting in the outbox is only delivered once it is relayed: the outbox
+	// may still give up on it, and the receiver would keep a message never stored.
+	if !queued {
+		deliverDirectMessage(msg)
+	}
+
+	ackMessage(connection, envelope.ID, msg.ID, msg.CreatedAt, queued)
+}
+
+// deliverDirectMessage pushes a direct message to the receiver and to the other devices
+// of the sender. Replies and messages with attachments are delivered by message-service,
+// which embeds the quoted message and the description of the attachments.
+func deliverDirectMessage(msg coreTypes.Message) {
+	if msg.RoomID != "" || msg.ReplyTo != "" || len(msg.Attachments) > 0 {
+		return
 	}
+
+	delivered := msg
+	delivered.ClientID = ""
+	err := route(context.Background(), coreTypes.BroadcastMessage{
+		UserIDs:         []string{msg.ReceiverID, msg.SenderID},
+		Messages:        []coreTypes.Message{delivered},
+		ExcludeClientID: msg.ClientID,
+		Timestamp:       time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to route message %s: %v", msg.ID, err)
+	}
+}
+
+// ackMessage tells the client which message its message.send frame became.
+func ackMessage(connection types.Connection, requestID, messageID string, createdAt time.Time, queued bool) {
+	if err := writeEvent(connection, types.EventAck, requestID, types.AckPayload{
+		MessageID: messageID,
+		Status:    coreTypes.StatusSent,
+		CreatedAt: &createdAt,
+		Queued:    queued,
+	}); err != nil {
+		log.Printf("An unexpected error occurred while sending message to user: %v", err)
+	}
+}
+
+// editMessage hands an edit to message-service, which checks that the user may make it.
+func editMessage(connection types.Connection, envelope types.Envelope) {
+	var payload types.EditMessagePayload
+	if !decodePayload(connection, envelope, &payload) {
+		return
+	}
+
+	edit := coreTypes.MessageEdit{
+		MessageID: payload.MessageID,
+		UserID:    connection.UserID,
+		ClientID:  connection.ClientID,
+		RequestID: envelope.ID,
+		Content:   payload.Content,
+		Timestamp: time.Now(),
+	}
+
+	if _, err := publishChatEvent(messaging.MessageEdit, edit, originOf(connection, envelope)); err != nil {
+		log.Printf("Failed to publish edit of %s: %v", connection.ClientID, err)
+		publishFailure(connection, envelope, err, "The message could not be edited, try again")
+	}
+}
+
+// deleteMessage hands a deletion to message-service, which checks that the user may
+// make it.
+func deleteMessage(connection types.Connection, envelope types.Envelope) {
+	var payload types.DeleteMessagePayload
+	if !decodePayload(connection, envelope, &payload) {
+		return
+	}
+
+	deletion := coreTypes.MessageDelete{
+		MessageID: payload.MessageID,
+		UserID:    connection.UserID,
+		ClientID:  connection.ClientID,
+		RequestID: envelope.ID,
+		Scope:     payload.Scope,
+		Timestamp: time.Now(),
+	}
+
+	if _, err := publishChatEvent(messaging.MessageDelete, deletion, originOf(connection, envelope)); err != nil {
+		log.Printf("Failed to publish deletion of %s: %v", connection.ClientID, err)
+		publishFailure(connection, envelope, err, "The message could not be deleted, try again")
+	}
+}
+
+// react hands a reaction change to message-service, which checks that the user can see
+// the message.
+func react(connection types.Connection, envelope types.Envelope, action coreTypes.ReactionAction) {
+	var payload types.ReactionPayload
+	if !decodePayload(connection, envelope, &payload) {
+		return
+	}
+
+	change := coreTypes.ReactionChange{
+		MessageID: payload.MessageID,
+		UserID:    connection.UserID,
+		Emoji:     payload.Emoji,
+		Action:    action,
+		ClientID:  connection.ClientID,
+		RequestID: envelope.ID,
+		Timestamp: time.Now(),
+	}
+
+	if _, err := publishChatEvent(messaging.Reaction, change, originOf(connection, envelope)); err != nil {
+		log.Printf("Failed to publish reaction of %s: %v", connection.ClientID, err)
+		publishFailure(connection, envelope, err, "The reaction could not be sent, try again")
+	}
+}
+
+// sendReceipt forwards the delivery acknowledgement or read marker of a device to
+// message-service.
+func sendReceipt(connection types.Connection, envelope types.Envelope) {
+	var payload types.ReceiptPayload
+	if !decodePayload(connection, envelope, &payload) {
+		return
+	}
+
+	receipt := coreTypes.Receipt{
+		UserID:    connection.UserID,
+		Status:    payload.Status,
+		Timestamp: time.Now(),
+	}
+	if payload.Status == coreTypes.StatusRead {
+		receipt.RoomID = payload.RoomID
+		receipt.UpToID = payload.MessageID
+	} else {
+		receipt.MessageIDs = payload.MessageIDs
+	}
+
+	queued, err := publishChatEvent(messaging.Receipt, receipt, originOf(connection, envelope))
+	if err != nil {
+		log.Printf("Failed to publish receipt of %s: %v", connection.ClientID, err)
+		publishFailure(connection, envelope, err, "The receipt could not be sent, try again")
+		return
+	}
+
+	writeEvent(connection, types.EventAck, envelope.ID, types.AckPayload{Queued: queued})
+}
+
+// reauthenticate extends the session of a connection when the client sends an auth
+// frame carrying a fresh access token for the same user.
+func reauthenticate(connection types.Connection, envelope types.Envelope, expiry *time.Timer) {
+	conn := connection.Channel
+
+	var payload types.AuthPayload
+	if !decodePayload(connection, envelope, &payload) {
+		return
+	}
+
+	claims, err := verifyToken(payload.Token, keys.PublicKeyAccess)
+	if err != nil {
+		log.Printf("Client %s sent an invalid re-auth token: %v", connection.ClientID, err)
+		writeError(connection, envelope.ID, "invalid_token", "Invalid or expired token")
+		return
+	}
+
+	if claims.UserID != connection.UserID {
+		log.Printf("Client %s tried to re-auth as a different user", connection.ClientID)
+		closeWithCode(conn, CloseUnauthorized, "Token belongs to another user")
+		return
+	}
+
+	connection.Credentials.SetToken(payload.Token)
+	expiry.Reset(time.Until(claims.ExpiresAt.Time))
+
+	writeEvent(connection, types.EventAck, envelope.ID, types.AckPayload{})
 }
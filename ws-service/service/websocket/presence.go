@@ -0,0 +1,175 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/hoyci/ms-chat/ws-service/service/presence"
+	"github.com/hoyci/ms-chat/ws-service/service/registry"
+	"github.com/hoyci/ms-chat/ws-service/types"
+)
+
+// disconnect releases everything held by a connection, whether the client closed it or
+// it died silently, and announces the user away or offline when it was their last
+// device online.
+func disconnect(connection types.Connection) {
+	connection.Outbox.Close()
+	connection.Channel.Close()
+	RemoveConnection(connection.ClientID)
+	stopTyping(connection)
+
+	ctx := context.Background()
+	if err := registry.RemoveDevice(ctx, connection.UserID, connection.ClientID); err != nil {
+		log.Printf("Failed to unregister device %s: %v", connection.ClientID, err)
+	}
+	if err := presence.RemoveDeviceStatus(ctx, connection.UserID, connection.ClientID); err != nil {
+		log.Printf("Failed to forget the presence of device %s: %v", connection.ClientID, err)
+	}
+	log.Printf("Connection %s closed", connection.ClientID)
+
+	status, err := userPresence(ctx, connection.UserID)
+	if err != nil {
+		log.Printf("Failed to look up the devices of %s: %v", connection.UserID, err)
+		return
+	}
+	// A device leaving cannot bring the user online, so an online user is unchanged.
+	if status != coreTypes.PresenceOnline {
+		publishPresence(connection.UserID, status)
+	}
+}
+
+// announceOnline refreshes the contacts allowed to see the presence of the user before
+// announcing them online, so the announcement reaches contacts added since the last
+// connection. The previous contacts are kept when contacts-service is unreachable. The
+// token is read from the connection, since a re-auth may have replaced it meanwhile.
+func announceOnline(connection types.Connection) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	contactIDs, err := presence.FetchContacts(ctx, connection.Credentials.Token())
+	if err != nil {
+		log.Printf("Failed to fetch the contacts of %s: %v", connection.UserID, err)
+	} else if err := presence.SetContacts(ctx, connection.UserID, contactIDs); err != nil {
+		log.Printf("Failed to store the contacts of %s: %v", connection.UserID, err)
+	}
+
+	updatePresence(connection.UserID, connection.ClientID, coreTypes.PresenceOnline)
+}
+
+// setPresence accepts a presence frame, sent by clients when the device goes idle or
+// comes back.
+func setPresence(connection types.Connection, envelope types.Envelope) {
+	var payload types.PresencePayload
+	if !decodePayload(connection, envelope, &payload) {
+		return
+	}
+
+	updatePresence(connection.UserID, connection.ClientID, payload.Status)
+	writeEvent(connection, types.EventAck, envelope.ID, types.AckPayload{})
+}
+
+// updatePresence records the status a device reported and announces the status of the
+// user across all their devices, so one device going idle leaves the user online while
+// another one is in use.
+func updatePresence(userID, clientID string, status coreTypes.PresenceStatus) {
+	ctx := context.Background()
+	if err := presence.SetDeviceStatus(ctx, userID, clientID, status); err != nil {
+		log.Printf("Failed to record presence of device %s: %v", clientID, err)
+		return
+	}
+
+	aggregate, err := userPresence(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to look up the devices of %s: %v", userID, err)
+		return
+	}
+	publishPresence(userID, aggregate)
+}
+
+// userPresence aggregates the statuses of the devices of the user connected to live
+// nodes.
+func userPresence(ctx context.Context, userID string) (coreTypes.PresenceStatus, error) {
+	clientIDs, err := registry.Devices(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	statuses, err := presence.DeviceStatuses(ctx, userID, clientIDs)
+	if err != nil {
+		return "", err
+	}
+	return presence.Aggregate(statuses), nil
+}
+
+// publishPresence records the status of the user and announces it on user_events.
+func publishPresence(userID string, status coreTypes.PresenceStatus) {
+	now := time.Now()
+	event := coreTypes.PresenceEvent{
+		UserID:     userID,
+		Status:     status,
+		LastSeenAt: now,
+		Timestamp:  now,
+	}
+
+	ctx := context.Background()
+	if err := presence.SetStatus(ctx, userID, status, now); err != nil {
+		log.Printf("Failed to record presence of %s: %v", userID, err)
+	}
+
+	if err := messaging.Presence.Publish(ctx, bus, event); err != nil {
+		log.Printf("Failed to publish presence of %s: %v", userID, err)
+	}
+}
+
+// StartUserEventsConsumer pushes presence changes to the connected contacts of the user
+// until ctx is done. Changes that could not be pushed are requeued.
+func StartUserEventsConsumer(ctx context.Context) {
+	queueName := config.Envs.UserEventsQueueName
+	msgs, err := bus.Consume(ctx, queueName, 0)
+	if err != nil {
+		log.Printf("Failed to start consumption from %s: %v", queueName, err)
+		return
+	}
+
+	for msg := range msgs {
+		if msg.Type != coreTypes.EventPresence {
+			msg.Ack(false)
+			continue
+		}
+
+		event, err := messaging.Presence.Decode(msg)
+		if err != nil {
+			log.Printf("Dropping presence event: %v", err)
+			msg.Ack(false)
+			continue
+		}
+
+		if err := fanOutPresence(ctx, event); err != nil {
+			log.Printf("Failed to push presence of %s, requeueing it: %v", event.UserID, err)
+			requeue(ctx, msg)
+			continue
+		}
+
+		msg.Ack(false)
+	}
+}
+
+func fanOutPresence(ctx context.Context, event coreTypes.PresenceEvent) error {
+	contactIDs, err := presence.Contacts(ctx, event.UserID)
+	if err != nil {
+		return err
+	}
+	if len(contactIDs) == 0 {
+		return nil
+	}
+
+	return route(ctx, coreTypes.BroadcastMessage{
+		UserIDs:   contactIDs,
+		Presence:  []coreTypes.PresenceEvent{event},
+		Timestamp: event.Timestamp,
+	})
+}
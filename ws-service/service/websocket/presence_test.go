@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/hoyci/ms-chat/ws-service/service/presence"
+	"github.com/hoyci/ms-chat/ws-service/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func sendPresenceFrame(connection types.Connection, status coreTypes.PresenceStatus) {
+	body, _ := json.Marshal(types.PresencePayload{Status: status})
+	setPresence(connection, types.Envelope{Type: types.EventPresence, ID: "presence-frame", Payload: body})
+}
+
+func TestPresence(t *testing.T) {
+	t.Run("it should keep the user online while another device is in use", func(t *testing.T) {
+		bus, _ := setupTestFlow(t)
+		phone := connectDevice(t, senderID, "phone")
+		laptop := connectDevice(t, senderID, "laptop")
+
+		sendPresenceFrame(phone, coreTypes.PresenceAway)
+		event := nextEvent(t, bus, config.Envs.UserEventsQueueName, messaging.Presence)
+		assert.Equal(t, coreTypes.PresenceOnline, event.Status)
+
+		sendPresenceFrame(laptop, coreTypes.PresenceAway)
+		event = nextEvent(t, bus, config.Envs.UserEventsQueueName, messaging.Presence)
+		assert.Equal(t, coreTypes.PresenceAway, event.Status)
+
+		sendPresenceFrame(phone, coreTypes.PresenceOnline)
+		event = nextEvent(t, bus, config.Envs.UserEventsQueueName, messaging.Presence)
+		assert.Equal(t, coreTypes.PresenceOnline, event.Status)
+	})
+
+	t.Run("it should requeue a presence change until the contacts can be read", func(t *testing.T) {
+		requeueDelay = 10 * time.Millisecond
+		t.Cleanup(func() { requeueDelay = time.Second })
+
+		bus, server := setupTestFlow(t)
+		contact := connectDevice(t, receiverID, "contact-device")
+		assert.NoError(t, presence.SetContacts(context.Background(), senderID, []string{receiverID}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go StartUserEventsConsumer(ctx)
+
+		server.SetError("LOADING Redis is loading the dataset in memory")
+		err := messaging.Presence.Publish(context.Background(), bus, coreTypes.PresenceEvent{
+			UserID:    senderID,
+			Status:    coreTypes.PresenceAway,
+			Timestamp: time.Now(),
+		})
+		assert.NoError(t, err)
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Zero(t, contact.Outbox.Len())
+
+		server.SetError("")
+		delivered := nextFrame(t, contact)
+		assert.Equal(t, types.EventPresence, delivered.Type)
+		var event coreTypes.PresenceEvent
+		assert.NoError(t, json.Unmarshal(delivered.Payload, &event))
+		assert.Equal(t, coreTypes.PresenceAway, event.Status)
+	})
+}
@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/websocket"
+	"github.com/hoyci/ms-chat/ws-service/types"
+	"github.com/hoyci/ms-chat/ws-service/utils"
+)
+
+// CloseUnsupportedProtocol is sent when the client only offers protocol versions this
+// server does not speak.
+const CloseUnsupportedProtocol = 4400
+
+const protocolPrefix = "ms-chat."
+
+var supportedProtocols = []string{types.ProtocolV1}
+
+var errUnsupportedProtocol = errors.New("unsupported protocol version")
+
+// negotiateProtocol picks the protocol version spoken on the connection and the
+// subprotocol echoed back in the handshake. Clients that do not offer any version get
+// the latest one; the token subprotocol is then echoed so browsers accept the handshake.
+func negotiateProtocol(r *http.Request) (version string, subprotocol string, err error) {
+	offered := websocket.Subprotocols(r)
+
+	versionOffered := false
+	for _, protocol := range offered {
+		if !strings.HasPrefix(protocol, protocolPrefix) {
+			continue
+		}
+		versionOffered = true
+		if slices.Contains(supportedProtocols, protocol) {
+			return protocol, protocol, nil
+		}
+	}
+
+	if versionOffered {
+		return "", "", errUnsupportedProtocol
+	}
+
+	latest := supportedProtocols[len(supportedProtocols)-1]
+	if slices.Contains(offered, tokenSubprotocol) {
+		return latest, tokenSubprotocol, nil
+	}
+	return latest, "", nil
+}
+
+// writeEvent queues payload wrapped in an envelope of the given type on the outbox of
+// the connection.
+func writeEvent(connection types.Connection, eventType string, id string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	frame, err := json.Marshal(types.Envelope{Type: eventType, ID: id, Payload: body})
+	if err != nil {
+		return err
+	}
+
+	return enqueue(connection, frame)
+}
+
+// writeError answers the frame identified by id with an error event.
+func writeError(connection types.Connection, id string, code string, messages ...string) error {
+	return writeEvent(connection, types.EventError, id, types.ErrorPayload{Code: code, Message: messages})
+}
+
+// decodePayload unmarshals and validates the payload of a client frame, answering the
+// client with an error event when it is invalid.
+func decodePayload(connection types.Connection, envelope types.Envelope, payload any) bool {
+	if err := json.Unmarshal(envelope.Payload, payload); err != nil {
+		writeError(connection, envelope.ID, "invalid_json", "Payload is not a valid json")
+		return false
+	}
+
+	if err := validate(payload); err != nil {
+		writeError(connection, envelope.ID, "validation_error", err...)
+		return false
+	}
+
+	return true
+}
+
+func validate(payload any) []string {
+	err := utils.Validate.Struct(payload)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return []string{err.Error()}
+	}
+
+	var errorMessages []string
+	for _, e := range validationErrors {
+		errorMessages = append(errorMessages, fmt.Sprintf("Field '%s' is invalid: %s", e.Field(), e.Tag()))
+	}
+	return errorMessages
+}
@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hoyci/ms-chat/ws-service/types"
+	"github.com/hoyci/ms-chat/ws-service/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateProtocol(t *testing.T) {
+	t.Run("it should select a supported version offered by the client", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.Header.Set("Sec-WebSocket-Protocol", "ms-chat.v9, ms-chat.v1")
+
+		version, subprotocol, err := negotiateProtocol(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, types.ProtocolV1, version)
+		assert.Equal(t, types.ProtocolV1, subprotocol)
+	})
+
+	t.Run("it should echo the version when the token is sent as a subprotocol", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.Header.Set("Sec-WebSocket-Protocol", "access_token, some.jwt.token, ms-chat.v1")
+
+		version, subprotocol, err := negotiateProtocol(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, types.ProtocolV1, version)
+		assert.Equal(t, types.ProtocolV1, subprotocol)
+	})
+
+	t.Run("it should default to the latest version when none is offered", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.Header.Set("Sec-WebSocket-Protocol", "access_token, some.jwt.token")
+
+		version, subprotocol, err := negotiateProtocol(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, types.ProtocolV1, version)
+		assert.Equal(t, tokenSubprotocol, subprotocol)
+	})
+
+	t.Run("it should not echo any subprotocol when the client sends none", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+
+		version, subprotocol, err := negotiateProtocol(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, types.ProtocolV1, version)
+		assert.Empty(t, subprotocol)
+	})
+
+	t.Run("it should reject clients offering only unsupported versions", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.Header.Set("Sec-WebSocket-Protocol", "ms-chat.v2")
+
+		_, _, err := negotiateProtocol(req)
+
+		assert.ErrorIs(t, err, errUnsupportedProtocol)
+	})
+}
+
+func TestValidatePayload(t *testing.T) {
+	utils.InitValidator()
+	messageID := "6630f0c2a1b2c3d4e5f60718"
+
+	t.Run("it should accept a delivery receipt listing message IDs", func(t *testing.T) {
+		errs := validate(&types.ReceiptPayload{Status: "delivered", MessageIDs: []string{messageID}})
+
+		assert.Empty(t, errs)
+	})
+
+	t.Run("it should require the room and message of a read receipt", func(t *testing.T) {
+		errs := validate(&types.ReceiptPayload{Status: "read"})
+
+		assert.Len(t, errs, 2)
+	})
+
+	t.Run("it should require a receiver or a room to send a message", func(t *testing.T) {
+		errs := validate(&types.SendMessagePayload{Content: "Hi"})
+
+		assert.NotEmpty(t, errs)
+	})
+
+	t.Run("it should accept a message addressed to a room", func(t *testing.T) {
+		errs := validate(&types.SendMessagePayload{RoomID: messageID, Content: "Hi"})
+
+		assert.Empty(t, errs)
+	})
+
+	t.Run("it should accept a message made only of attachments", func(t *testing.T) {
+		errs := validate(&types.SendMessagePayload{RoomID: messageID, AttachmentIDs: []string{messageID}})
+
+		assert.Empty(t, errs)
+	})
+
+	t.Run("it should require content or attachments", func(t *testing.T) {
+		errs := validate(&types.SendMessagePayload{RoomID: messageID})
+
+		assert.NotEmpty(t, errs)
+	})
+
+	t.Run("it should reject invalid attachment IDs", func(t *testing.T) {
+		errs := validate(&types.SendMessagePayload{RoomID: messageID, Content: "Hi", AttachmentIDs: []string{"nope"}})
+
+		assert.NotEmpty(t, errs)
+	})
+}
@@ -0,0 +1,139 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/hoyci/ms-chat/ws-service/service/idempotency"
+	"github.com/hoyci/ms-chat/ws-service/service/outbox"
+	"github.com/hoyci/ms-chat/ws-service/types"
+)
+
+// bus carries the events exchanged with the other services.
+var bus messaging.Bus
+
+// chatEvents holds the events handed over to message-service while the broker is
+// unavailable.
+var chatEvents *outbox.Outbox
+
+// Init publishes and consumes events on b, and opens the outbox of the node, picking up
+// the events a previous run could not publish.
+func Init(b messaging.Bus) {
+	bus = b
+
+	var err error
+	chatEvents, err = outbox.New(
+		config.Envs.PublishOutboxPath,
+		bus,
+		config.Envs.PublishTimeout,
+		config.Envs.PublishOutboxMaxEntries,
+		config.Envs.PublishOutboxMaxAge,
+		deliverRelayed,
+		notifyPersistenceFailed,
+	)
+	if err != nil {
+		log.Fatalf("Failed to open the outbox: %v", err)
+	}
+}
+
+// RelayOutbox publishes the events waiting in the outbox until ctx is done.
+func RelayOutbox(ctx context.Context) {
+	chatEvents.Run(ctx, config.Envs.PublishOutboxRetryInterval)
+}
+
+// originOf identifies the client frame an event is published for.
+func originOf(connection types.Connection, envelope types.Envelope) outbox.Origin {
+	return outbox.Origin{
+		UserID:    connection.UserID,
+		ClientID:  connection.ClientID,
+		RequestID: envelope.ID,
+		FrameType: envelope.Type,
+	}
+}
+
+// publishChatEvent hands an event over to message-service once the broker confirmed
+// it, or keeps it in the outbox while the broker is unavailable. It reports whether the
+// event was kept; an error means the event will not reach message-service.
+func publishChatEvent[T any](event messaging.Event[T], payload T, origin outbox.Origin) (bool, error) {
+	msg, err := event.Publishing(payload)
+	if err != nil {
+		return false, err
+	}
+
+	return chatEvents.Publish(context.Background(), outbox.Entry{
+		Exchange: event.Exchange,
+		Headers:  msg.Headers,
+		Type:     msg.Type,
+		Body:     msg.Body,
+		Origin:   origin,
+	})
+}
+
+// publishFailure answers a frame whose event could not be published with an error event.
+func publishFailure(connection types.Connection, envelope types.Envelope, err error, message string) {
+	code := "unavailable"
+	if errors.Is(err, messaging.ErrUnroutable) {
+		code = "unroutable"
+	}
+	writeError(connection, envelope.ID, code, message)
+}
+
+// deliverRelayed delivers the direct messages kept in the outbox once message-service
+// has them.
+func deliverRelayed(entry outbox.Entry) {
+	if entry.Type != coreTypes.EventChatMessage {
+		return
+	}
+
+	var msg coreTypes.Message
+	if err := json.Unmarshal(entry.Body, &msg); err != nil {
+		log.Printf("Failed to read relayed message %s: %v", entry.Origin.MessageID, err)
+		return
+	}
+	deliverDirectMessage(msg)
+}
+
+// notifyPersistenceFailed tells the device that sent a frame the outbox gave up on. The
+// outbox belongs to the node, so the device is looked up among the local connections.
+// The frame ID of an abandoned message is released first, so the message is sent again
+// when the device retries the frame.
+func notifyPersistenceFailed(entry outbox.Entry, cause error) {
+	origin := entry.Origin
+	if origin.FrameType == "" {
+		return
+	}
+
+	if origin.FrameType == types.EventMessageSend && origin.RequestID != "" {
+		if err := idempotency.Release(context.Background(), origin.UserID, origin.RequestID); err != nil {
+			log.Printf("Failed to release frame %s of %s: %v", origin.RequestID, origin.UserID, err)
+		}
+	}
+
+	code := "expired"
+	if errors.Is(cause, messaging.ErrUnroutable) {
+		code = "unroutable"
+	}
+
+	for _, connection := range GetUserDevicesConnections(origin.UserID) {
+		if connection.ClientID != origin.ClientID {
+			continue
+		}
+
+		if err := writeEvent(connection, types.EventPersistenceFailed, origin.RequestID, types.PersistenceFailedPayload{
+			Type:      origin.FrameType,
+			MessageID: origin.MessageID,
+			Code:      code,
+			Message:   "The server could not hand the frame over for persistence, send it again",
+		}); err != nil {
+			log.Printf("Failed to notify %s of the failed %s frame: %v", origin.ClientID, origin.FrameType, err)
+		}
+		return
+	}
+
+	log.Printf("Client %s left before its %s frame %s was abandoned", origin.ClientID, origin.FrameType, origin.RequestID)
+}
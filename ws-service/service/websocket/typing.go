@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/hoyci/ms-chat/core/messaging"
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/hoyci/ms-chat/ws-service/types"
+)
+
+func newTypingTracker(userID string) *types.TypingTracker {
+	return types.NewTypingTracker(
+		config.Envs.TypingTimeout,
+		config.Envs.TypingRateLimit,
+		config.Envs.TypingRateWindow,
+		func(roomID string) {
+			publishTyping(userID, roomID, coreTypes.TypingStop, nil)
+		},
+	)
+}
+
+// sendTyping accepts a typing frame. message-service fans it out to the other members of
+// the room; a start frame that is not refreshed expires into a stop.
+func sendTyping(connection types.Connection, envelope types.Envelope) {
+	var payload types.TypingPayload
+	if !decodePayload(connection, envelope, &payload) {
+		return
+	}
+
+	if !connection.Typing.Allow() {
+		writeError(connection, envelope.ID, "rate_limited", "Too many typing frames, slow down")
+		return
+	}
+
+	switch payload.State {
+	case coreTypes.TypingStart:
+		expiresAt := connection.Typing.Start(payload.RoomID)
+		publishTyping(connection.UserID, payload.RoomID, coreTypes.TypingStart, &expiresAt)
+	case coreTypes.TypingStop:
+		if connection.Typing.Stop(payload.RoomID) {
+			publishTyping(connection.UserID, payload.RoomID, coreTypes.TypingStop, nil)
+		}
+	}
+}
+
+// stopTyping clears the indicators of a connection going away.
+func stopTyping(connection types.Connection) {
+	for _, roomID := range connection.Typing.StopAll() {
+		publishTyping(connection.UserID, roomID, coreTypes.TypingStop, nil)
+	}
+}
+
+// publishTyping hands a typing event to message-service through the ephemeral queue.
+// It is not retried: a lost indicator is superseded by the next one or expires.
+func publishTyping(userID, roomID string, state coreTypes.TypingState, expiresAt *time.Time) {
+	err := messaging.Typing.Publish(context.Background(), bus, coreTypes.TypingEvent{
+		UserID:    userID,
+		RoomID:    roomID,
+		State:     state,
+		ExpiresAt: expiresAt,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to publish typing event of %s: %v", userID, err)
+	}
+}
@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"errors"
+	"expvar"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hoyci/ms-chat/ws-service/config"
+	"github.com/hoyci/ms-chat/ws-service/types"
+)
+
+// CloseSlowConsumer is sent to connections disconnected because their outbox filled up.
+const CloseSlowConsumer = 4008
+
+var overflowPolicy = mustParseOverflowPolicy(config.Envs.OutboxOverflowPolicy)
+
+// Outbox metrics, served on /debug/vars.
+var (
+	outboxDropped         = expvar.NewInt("ws_outbox_dropped_frames")
+	outboxSlowDisconnects = expvar.NewInt("ws_outbox_slow_consumer_disconnects")
+)
+
+func init() {
+	expvar.Publish("ws_outbox_depth", expvar.Func(outboxDepth))
+}
+
+func mustParseOverflowPolicy(value string) types.OverflowPolicy {
+	policy, err := types.ParseOverflowPolicy(value)
+	if err != nil {
+		log.Fatalf("Invalid OUTBOX_OVERFLOW_POLICY: %v", err)
+	}
+	return policy
+}
+
+// writePump is the only goroutine writing data frames to the connection, and pings the
+// client every PingInterval. It stops once the outbox is closed or a write fails,
+// closing the socket so the read loop cleans the connection up.
+func writePump(connection types.Connection) {
+	conn := connection.Channel
+	outbox := connection.Outbox
+
+	ticker := time.NewTicker(config.Envs.PingInterval)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case <-outbox.Done():
+			return
+		case frame := <-outbox.Frames():
+			conn.SetWriteDeadline(time.Now().Add(config.Envs.WriteWait))
+			if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				log.Printf("Write error on %s: %v", connection.ClientID, err)
+				outbox.Close()
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(config.Envs.WriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("Ping error on %s: %v", connection.ClientID, err)
+				outbox.Close()
+				return
+			}
+		}
+	}
+}
+
+// enqueue queues a frame for the writer of the connection, applying the overflow policy
+// when the client is not keeping up.
+func enqueue(connection types.Connection, frame []byte) error {
+	dropped, err := connection.Outbox.Push(frame)
+	if dropped > 0 {
+		outboxDropped.Add(int64(dropped))
+		log.Printf("Dropped %d frames queued for slow client %s", dropped, connection.ClientID)
+	}
+
+	if errors.Is(err, types.ErrOutboxFull) {
+		outboxSlowDisconnects.Add(1)
+		log.Printf("Disconnecting slow client %s", connection.ClientID)
+		connection.Outbox.Close()
+		closeWithCode(connection.Channel, CloseSlowConsumer, "Slow consumer")
+	}
+
+	return err
+}
+
+// outboxDepth summarizes how many frames are waiting across the connections of the node.
+func outboxDepth() any {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	total, largest := 0, 0
+	for _, connection := range connections {
+		depth := connection.Outbox.Len()
+		total += depth
+		largest = max(largest, depth)
+	}
+
+	return map[string]int{
+		"connections": len(connections),
+		"queued":      total,
+		"max":         largest,
+		"capacity":    config.Envs.OutboxSize,
+	}
+}
@@ -0,0 +1,27 @@
+package types
+
+import "sync"
+
+// Credentials holds the access token of a connection. A re-auth replaces the token
+// while the connection stays open, so calls made on behalf of the user read it when
+// they are made.
+type Credentials struct {
+	mu    sync.RWMutex
+	token string
+}
+
+func NewCredentials(token string) *Credentials {
+	return &Credentials{token: token}
+}
+
+func (c *Credentials) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+func (c *Credentials) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
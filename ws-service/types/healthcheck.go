@@ -0,0 +1,13 @@
+package types
+
+import "github.com/hoyci/ms-chat/core/broker"
+
+// BrokerMonitor reports the state of the connection to the message broker.
+type BrokerMonitor interface {
+	Status() broker.Status
+}
+
+type HealthcheckResponse struct {
+	Status     string            `json:"status"`
+	SystemInfo map[string]string `json:"system_info"`
+}
@@ -0,0 +1,103 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// OverflowPolicy decides what happens when a connection does not drain its outbox fast
+// enough and the outbox is full.
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest discards the oldest queued frame to make room for the new one.
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowDisconnect refuses the frame so the caller disconnects the slow consumer.
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+func ParseOverflowPolicy(value string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(value); policy {
+	case OverflowDropOldest, OverflowDisconnect:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy %q", value)
+	}
+}
+
+var (
+	ErrOutboxFull   = errors.New("outbox is full")
+	ErrOutboxClosed = errors.New("outbox is closed")
+)
+
+// Outbox is the bounded queue of frames waiting to be written to a connection. Any
+// goroutine may push to it; a single writer drains it.
+type Outbox struct {
+	frames    chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	policy    OverflowPolicy
+}
+
+func NewOutbox(size int, policy OverflowPolicy) *Outbox {
+	return &Outbox{
+		frames: make(chan []byte, size),
+		done:   make(chan struct{}),
+		policy: policy,
+	}
+}
+
+// Push queues a frame without blocking. It reports how many queued frames were dropped
+// to make room, and ErrOutboxFull when the policy is to disconnect.
+func (o *Outbox) Push(frame []byte) (int, error) {
+	dropped := 0
+	for {
+		select {
+		case <-o.done:
+			return dropped, ErrOutboxClosed
+		default:
+		}
+
+		select {
+		case o.frames <- frame:
+			return dropped, nil
+		default:
+		}
+
+		if o.policy == OverflowDisconnect {
+			return dropped, ErrOutboxFull
+		}
+
+		select {
+		case <-o.frames:
+			dropped++
+		default:
+		}
+	}
+}
+
+// Frames is drained by the writer of the connection.
+func (o *Outbox) Frames() <-chan []byte {
+	return o.frames
+}
+
+// Done is closed once the outbox is closed.
+func (o *Outbox) Done() <-chan struct{} {
+	return o.done
+}
+
+func (o *Outbox) Close() {
+	o.closeOnce.Do(func() {
+		close(o.done)
+	})
+}
+
+// Len is the number of frames waiting to be written.
+func (o *Outbox) Len() int {
+	return len(o.frames)
+}
+
+func (o *Outbox) Cap() int {
+	return cap(o.frames)
+}
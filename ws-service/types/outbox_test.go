@@ -0,0 +1,57 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func drain(outbox *Outbox) []string {
+	var frames []string
+	for outbox.Len() > 0 {
+		frames = append(frames, string(<-outbox.Frames()))
+	}
+	return frames
+}
+
+func TestOutboxPush(t *testing.T) {
+	t.Run("it should drop the oldest frames when full", func(t *testing.T) {
+		outbox := NewOutbox(2, OverflowDropOldest)
+		outbox.Push([]byte("1"))
+		outbox.Push([]byte("2"))
+
+		dropped, err := outbox.Push([]byte("3"))
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, dropped)
+		assert.Equal(t, []string{"2", "3"}, drain(outbox))
+	})
+
+	t.Run("it should refuse frames when full with the disconnect policy", func(t *testing.T) {
+		outbox := NewOutbox(1, OverflowDisconnect)
+		outbox.Push([]byte("1"))
+
+		_, err := outbox.Push([]byte("2"))
+
+		assert.ErrorIs(t, err, ErrOutboxFull)
+		assert.Equal(t, []string{"1"}, drain(outbox))
+	})
+
+	t.Run("it should refuse frames once closed", func(t *testing.T) {
+		outbox := NewOutbox(1, OverflowDropOldest)
+		outbox.Close()
+		outbox.Close()
+
+		_, err := outbox.Push([]byte("1"))
+
+		assert.ErrorIs(t, err, ErrOutboxClosed)
+	})
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	t.Run("it should reject unknown policies", func(t *testing.T) {
+		_, err := ParseOverflowPolicy("block")
+
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,22 @@
+package types
+
+import (
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+)
+
+type Presence struct {
+	UserID     string                   `json:"user_id"`
+	Status     coreTypes.PresenceStatus `json:"status"`
+	LastSeenAt *time.Time               `json:"last_seen_at"`
+}
+
+type GetPresenceResponse struct {
+	Presence []Presence `json:"presence"`
+}
+
+// PresencePayload is sent by clients to tell whether the user is active or away.
+type PresencePayload struct {
+	Status coreTypes.PresenceStatus `json:"status" validate:"required,oneof=online away"`
+}
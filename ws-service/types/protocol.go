@@ -0,0 +1,136 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+
+	coreTypes "github.com/hoyci/ms-chat/core/types"
+)
+
+// ProtocolV1 is the Sec-WebSocket-Protocol value clients offer to speak version 1 of
+// the protocol. Every frame, in both directions, is an Envelope.
+const ProtocolV1 = "ms-chat.v1"
+
+// Event types of the Envelope. Clients send message.send, message.edit, message.delete,
+// reaction.add, reaction.remove, receipt, typing, presence, ping and auth; the server
+// sends session, message.new, message.updated, message.deleted, reaction, ack, receipt,
+// typing, presence, persistence.failed, pong and error.
+const (
+	EventSession           = "session"
+	EventAuth              = "auth"
+	EventMessageSend       = "message.send"
+	EventMessageNew        = "message.new"
+	EventMessageEdit       = "message.edit"
+	EventMessageDelete     = "message.delete"
+	EventMessageUpdated    = "message.updated"
+	EventMessageDeleted    = "message.deleted"
+	EventReactionAdd       = "reaction.add"
+	EventReactionRemove    = "reaction.remove"
+	EventReaction          = "reaction"
+	EventAck               = "ack"
+	EventReceipt           = "receipt"
+	EventTyping            = "typing"
+	EventPresence          = "presence"
+	EventError             = "error"
+	EventPersistenceFailed = "persistence.failed"
+	EventPing              = "ping"
+	EventPong              = "pong"
+)
+
+// Envelope wraps every frame. ID is generated by the client for the frames it sends and
+// is echoed in the ack or error answering them, which lets clients retry a frame without
+// ambiguity.
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// SessionPayload is sent once the connection is authenticated.
+type SessionPayload struct {
+	UserID   string `json:"user_id"`
+	ClientID string `json:"client_id"`
+	Version  string `json:"version"`
+}
+
+type AuthPayload struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// SendMessagePayload is sent by clients to post a message to a room or to a user.
+// ReplyTo makes it a reply to a message of the same conversation. AttachmentIDs refer to
+// files the sender uploaded to message-service beforehand.
+type SendMessagePayload struct {
+	RoomID        string   `json:"room_id" validate:"required_without=ReceiverID,omitempty,mongodb"`
+	ReceiverID    string   `json:"receiver_id" validate:"required_without=RoomID,omitempty,uuid"`
+	Content       string   `json:"content" validate:"required_without=AttachmentIDs"`
+	ReplyTo       string   `json:"reply_to" validate:"omitempty,mongodb"`
+	AttachmentIDs []string `json:"attachment_ids" validate:"omitempty,max=10,dive,mongodb"`
+}
+
+// EditMessagePayload is sent by clients to replace the content of one of their messages.
+// It is not acknowledged: the message.updated event or an error carrying the frame ID
+// answers it once message-service applied or refused it.
+type EditMessagePayload struct {
+	MessageID string `json:"message_id" validate:"required,mongodb"`
+	Content   string `json:"content" validate:"required"`
+}
+
+// DeleteMessagePayload is sent by clients to delete a message for everyone or only for
+// their user. Like edits, it is answered by a message.deleted event or an error.
+type DeleteMessagePayload struct {
+	MessageID string                `json:"message_id" validate:"required,mongodb"`
+	Scope     coreTypes.DeleteScope `json:"scope" validate:"required,oneof=me everyone"`
+}
+
+// ReactionPayload is sent by clients to add or remove a reaction. Like edits, it is
+// answered by a reaction event or an error.
+type ReactionPayload struct {
+	MessageID string `json:"message_id" validate:"required,mongodb"`
+	Emoji     string `json:"emoji" validate:"required,max=64"`
+}
+
+// ReceiptPayload is sent by clients to acknowledge the delivery of MessageIDs, or to
+// mark every message of RoomID up to MessageID as read.
+type ReceiptPayload struct {
+	Status     coreTypes.Status `json:"status" validate:"required,oneof=delivered read"`
+	MessageIDs []string         `json:"message_ids" validate:"required_if=Status delivered,max=100,dive,mongodb"`
+	RoomID     string           `json:"room_id" validate:"required_if=Status read,omitempty,mongodb"`
+	MessageID  string           `json:"message_id" validate:"required_if=Status read,omitempty,mongodb"`
+}
+
+// AckPayload confirms that the server accepted a client frame. For message.send it
+// carries the ID the message is known by from then on; a message.send frame retried with
+// the same envelope ID is acknowledged with the message it first created. Queued tells
+// that the broker was unavailable and the frame waits on the server to be handed over:
+// should that never happen, a persistence.failed event follows.
+type AckPayload struct {
+	MessageID string           `json:"message_id,omitempty"`
+	Status    coreTypes.Status `json:"status,omitempty"`
+	CreatedAt *time.Time       `json:"created_at,omitempty"`
+	Queued    bool             `json:"queued,omitempty"`
+}
+
+// PersistenceFailedPayload tells the device that sent a frame, under the ID of that
+// frame, that the server gave up on handing it over to message-service after
+// acknowledging it. Type is the type of the frame and MessageID the message a
+// message.send frame was turned into.
+type PersistenceFailedPayload struct {
+	Type      string `json:"type"`
+	MessageID string `json:"message_id,omitempty"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+// TypingPayload is sent by clients when the user starts or stops typing in a room.
+// While the user keeps typing, clients repeat the start frame before the indicator
+// expires. Typing frames are not acknowledged.
+type TypingPayload struct {
+	RoomID string                `json:"room_id" validate:"required,mongodb"`
+	State  coreTypes.TypingState `json:"state" validate:"required,oneof=start stop"`
+}
+
+type ErrorPayload struct {
+	Code    string   `json:"code"`
+	Message []string `json:"message"`
+}
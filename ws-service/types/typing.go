@@ -0,0 +1,104 @@
+package types
+
+import (
+	"sync"
+	"time"
+)
+
+// TypingTracker keeps the rooms a connection is typing in. It rate limits the typing
+// frames of the connection and calls onExpire for a room when no start frame refreshed
+// it within the timeout.
+type TypingTracker struct {
+	mu          sync.Mutex
+	timeout     time.Duration
+	limit       int
+	window      time.Duration
+	windowStart time.Time
+	count       int
+	timers      map[string]*time.Timer
+	onExpire    func(roomID string)
+}
+
+func NewTypingTracker(timeout time.Duration, limit int, window time.Duration, onExpire func(roomID string)) *TypingTracker {
+	return &TypingTracker{
+		timeout:  timeout,
+		limit:    limit,
+		window:   window,
+		timers:   make(map[string]*time.Timer),
+		onExpire: onExpire,
+	}
+}
+
+// Allow reports whether the connection may send another typing frame in the current
+// window.
+func (t *TypingTracker) Allow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.windowStart) >= t.window {
+		t.windowStart = now
+		t.count = 0
+	}
+	if t.count >= t.limit {
+		return false
+	}
+	t.count++
+	return true
+}
+
+// Start marks the connection as typing in the room and pushes its expiry back. It
+// returns when the indicator expires.
+func (t *TypingTracker) Start(roomID string) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if timer, ok := t.timers[roomID]; ok {
+		timer.Stop()
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(t.timeout, func() {
+		t.mu.Lock()
+		// A later Start or Stop replaced or removed this timer.
+		if t.timers[roomID] != timer {
+			t.mu.Unlock()
+			return
+		}
+		delete(t.timers, roomID)
+		t.mu.Unlock()
+
+		t.onExpire(roomID)
+	})
+	t.timers[roomID] = timer
+
+	return time.Now().Add(t.timeout)
+}
+
+// Stop marks the connection as no longer typing in the room. It reports whether it was.
+func (t *TypingTracker) Stop(roomID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timer, ok := t.timers[roomID]
+	if !ok {
+		return false
+	}
+	timer.Stop()
+	delete(t.timers, roomID)
+	return true
+}
+
+// StopAll stops every indicator of the connection and returns their rooms.
+func (t *TypingTracker) StopAll() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	roomIDs := make([]string, 0, len(t.timers))
+	for roomID, timer := range t.timers {
+		timer.Stop()
+		roomIDs = append(roomIDs, roomID)
+	}
+	clear(t.timers)
+	return roomIDs
+}
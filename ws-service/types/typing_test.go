@@ -0,0 +1,61 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypingTracker(t *testing.T) {
+	roomID := "6650c1f2a3b4c5d6e7f80910"
+
+	t.Run("it should limit the frames allowed per window", func(t *testing.T) {
+		tracker := NewTypingTracker(time.Minute, 2, 50*time.Millisecond, func(string) {})
+
+		assert.True(t, tracker.Allow())
+		assert.True(t, tracker.Allow())
+		assert.False(t, tracker.Allow())
+
+		time.Sleep(60 * time.Millisecond)
+		assert.True(t, tracker.Allow())
+	})
+
+	t.Run("it should expire an indicator that is not refreshed", func(t *testing.T) {
+		expired := make(chan string, 1)
+		tracker := NewTypingTracker(20*time.Millisecond, 10, time.Second, func(roomID string) { expired <- roomID })
+
+		tracker.Start(roomID)
+
+		select {
+		case got := <-expired:
+			assert.Equal(t, roomID, got)
+		case <-time.After(time.Second):
+			t.Fatal("indicator did not expire")
+		}
+		assert.False(t, tracker.Stop(roomID))
+	})
+
+	t.Run("it should not expire an indicator that was stopped", func(t *testing.T) {
+		expired := make(chan string, 1)
+		tracker := NewTypingTracker(20*time.Millisecond, 10, time.Second, func(roomID string) { expired <- roomID })
+
+		tracker.Start(roomID)
+		assert.True(t, tracker.Stop(roomID))
+
+		select {
+		case <-expired:
+			t.Fatal("stopped indicator expired")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("it should return the rooms of the indicators it stops", func(t *testing.T) {
+		tracker := NewTypingTracker(time.Minute, 10, time.Second, func(string) {})
+		tracker.Start(roomID)
+		tracker.Start("6650c1f2a3b4c5d6e7f80911")
+
+		assert.ElementsMatch(t, []string{roomID, "6650c1f2a3b4c5d6e7f80911"}, tracker.StopAll())
+		assert.Empty(t, tracker.StopAll())
+	})
+}